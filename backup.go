@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// backupReport is the --output json form of backup
+type backupReport struct {
+	Dashboards []backupEntry `json:"dashboards"`
+}
+
+type backupEntry struct {
+	DashboardID string              `json:"dashboardId"`
+	Snapshot    *workspace.Snapshot `json:"snapshot,omitempty"`
+	// Taken is unset when the dashboard was unchanged since Snapshot
+	Taken bool         `json:"taken"`
+	Error *errorDetail `json:"error,omitempty"`
+}
+
+// restoreReport is the --output json form of restore
+type restoreReport struct {
+	Restored *workspace.Snapshot `json:"restored"`
+	Previous *workspace.Snapshot `json:"previous"`
+}
+
+// BackupDashboards snapshots the dashboards given, the configured dashboard by default or with --all every
+// dashboard of config.yml, into the backup archive
+func BackupDashboards(args []string) {
+	flags := newFlagSet("backup")
+	all := flags.Bool("all", false, "back up dashboard_id and every dashboard listed under dashboards in config.yml")
+	parseFlags(flags, args)
+
+	config := localConfig()
+	dashboardIds := flags.Args()
+	if *all || len(dashboardIds) == 0 {
+		configured := []string{config.DashboardID}
+		if *all {
+			configured = append(configured, config.Dashboards...)
+		}
+		dashboardIds = nil
+		for _, dashboardId := range configured {
+			if dashboardId != "" {
+				dashboardIds = append(dashboardIds, dashboardId)
+			}
+		}
+	}
+	if len(dashboardIds) == 0 {
+		usageError(flags, "No dashboard id given and none configured in %s", configPath())
+	}
+
+	ws := openWorkspace("", newDashboardsAPI(dashboardsAccessToken()))
+	failed := 0
+	report := backupReport{Dashboards: []backupEntry{}}
+	for _, dashboardId := range dashboardIds {
+		snapshot, taken, err := ws.Backup(dashboardId, "backup")
+		report.Dashboards = append(report.Dashboards, backupEntry{DashboardID: dashboardId, Snapshot: snapshot, Taken: taken})
+		if err != nil {
+			slog.Error("Failed to back up dashboard", "dashboard", dashboardId, "error", err)
+			report.Dashboards[len(report.Dashboards)-1].Error = &errorDetail{Code: errorCode(err), Message: err.Error()}
+			failed++
+			continue
+		}
+		if taken {
+			printInfo("Backed up %s (%s) as %s\n", dashboardId, snapshot.Title, snapshot.ShortHash())
+		} else {
+			printInfo("%s (%s) unchanged since snapshot %s\n", dashboardId, snapshot.Title, snapshot.ShortHash())
+		}
+	}
+
+	if jsonOutput() {
+		printJSON(report)
+	}
+	if failed > 0 {
+		failf("Failed to back up %d dashboards", failed)
+	}
+}
+
+// ShowHistory lists the snapshots of the backup archive, newest first
+func ShowHistory(args []string) {
+	flags := newFlagSet("history")
+	parseFlags(flags, args)
+
+	history, err := openWorkspace("", nil).History(flags.Arg(0))
+	if err != nil {
+		fatalf("Failed to read backup history: %v", err)
+	}
+	if jsonOutput() {
+		if history == nil {
+			history = []workspace.Snapshot{}
+		}
+		printJSON(history)
+		return
+	}
+	if len(history) == 0 {
+		fmt.Println("No snapshots")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SNAPSHOT\tTAKEN\tDASHBOARD\tTITLE\tREASON")
+	for _, snapshot := range history {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", snapshot.ShortHash(), snapshot.TakenAt.Local().Format(time.DateTime), snapshot.DashboardID, snapshot.Title, snapshot.Reason)
+	}
+	writer.Flush()
+}
+
+// RestoreDashboard pushes a snapshot of the backup archive back to its dashboard
+func RestoreDashboard(args []string) {
+	flags := newFlagSet("restore")
+	target := flags.String("to", "", "dashboard to restore the snapshot to instead of the one it was taken of")
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		usageError(flags, "Expected one snapshot to restore")
+	}
+
+	result, err := openWorkspace("", newDashboardsAPI(dashboardsAccessToken())).Restore(flags.Arg(0), *target)
+	if err != nil {
+		fatalf("Failed to restore snapshot: %v", err)
+	}
+
+	if jsonOutput() {
+		printJSON(restoreReport{Restored: result.Restored, Previous: result.Previous})
+		return
+	}
+	printInfo("Restored snapshot %s of %s taken %s to %s, its previous version is snapshot %s\n",
+		result.Restored.ShortHash(), result.Restored.DashboardID, result.Restored.TakenAt.Local().Format(time.DateTime),
+		result.Previous.DashboardID, result.Previous.ShortHash())
+}
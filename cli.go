@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+const Program_Name = "kusto-dashboards-sync"
+
+// Exit codes of the command line tool
+const (
+	// Exit_Failure is returned when a command fails, or finds what it checks for such as an invalid template
+	Exit_Failure = 1
+	// Exit_Usage is returned for unknown commands, flags and missing arguments
+	Exit_Usage = 2
+	// Exit_Interrupted is returned when a command is interrupted with Ctrl-C or SIGTERM
+	Exit_Interrupted = 130
+)
+
+// Output formats chosen with --output
+const (
+	Output_Text  = "text"
+	Output_Table = "table"
+	Output_CSV   = "csv"
+	Output_JSON  = "json"
+)
+
+// command is a subcommand of the command line tool
+type command struct {
+	// name is the words selecting the command, such as "push" or "kql fmt"
+	name string
+	// args describes the arguments the command takes after its flags
+	args    string
+	summary string
+	// run parses the flags of the command from args and runs it, exiting on failure
+	run func(args []string)
+	// complete lists the candidates for the arguments of the command, nil when they are not completed
+	complete func() []completionCandidate
+}
+
+// commands are the subcommands of the tool, in the order help lists them
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "pull", args: "[dashboard id]", summary: "Pull the dashboard into the workspace", run: PullCommand, complete: dashboardCandidates},
+		{name: "push", args: "[dashboard id]", summary: "Render the template and push it to the dashboard", run: PushCommand, complete: dashboardCandidates},
+		{name: "sync", args: "[dashboard id]", summary: "Pull the dashboard on a branch of its own and commit the changes", run: SyncCommand, complete: dashboardCandidates},
+		{name: "diff", args: "[dashboard id]", summary: "Show what pushing the template would change", run: DiffDashboard, complete: dashboardCandidates},
+		{name: "status", args: "[dashboard id]", summary: "Show whether the dashboard and the template have changed", run: ShowStatus, complete: dashboardCandidates},
+		{name: "watch-remote", args: "[dashboard id...]", summary: "Poll dashboards and report when they drift from the committed template", run: WatchRemote, complete: dashboardCandidates},
+		{name: "validate", summary: "Check the rendered template and lint its queries without connecting to anything", run: ValidateTemplate},
+		{name: "backup", args: "[dashboard id...]", summary: "Snapshot dashboards into the local backup archive", run: BackupDashboards, complete: dashboardCandidates},
+		{name: "history", args: "[dashboard id]", summary: "List the snapshots of the backup archive", run: ShowHistory, complete: dashboardCandidates},
+		{name: "restore", args: "<snapshot>", summary: "Push a snapshot back to its dashboard", run: RestoreDashboard, complete: snapshotCandidates},
+		{name: "migrate", args: "[template path]", summary: "Upgrade dashboard.yml to the latest known schema version", run: MigrateDashboard},
+		{name: "fmt", args: "[template path]", summary: "Rewrite dashboard.yml in canonical form", run: FormatDashboard},
+		{name: "preview", summary: "Render markdown tiles of each page to HTML", run: PreviewDashboard},
+		{name: "run", args: "<tile or query file>", summary: "Run a tile query against its data source", run: RunQuery},
+		{name: "expand", args: "<tile or query file>", summary: "Print a tile query with its variables bound by let statements", run: ExpandQuery},
+		{name: "test", summary: "Run every tile and base query and report failures", run: TestDashboard},
+		{name: "generate", args: "[spec path]", summary: "Expand dashboard.spec.yml into a dashboard template", run: GenerateFromSpec},
+		{name: "kql fmt", args: "[paths]", summary: "Pretty-print the query files", run: FormatQueries},
+		{name: "kql lint", args: "[paths]", summary: "Check the query files for common mistakes", run: LintQueries},
+		{name: "completion", args: "bash|zsh|fish", summary: "Print the shell completion script", run: PrintCompletion, complete: shellCandidates},
+		{name: "help", args: "[command]", summary: "Show the commands, or the flags of a command", run: ShowHelp, complete: commandCandidates},
+	}
+}
+
+// globalOptions are the flags accepted anywhere on the command line, before or after the command
+var globalOptions struct {
+	config    string
+	dir       string
+	verbose   bool
+	quiet     bool
+	logFormat string
+	// trace logs every exchange with the services, dumping them to traceDir when set
+	trace    bool
+	traceDir string
+	// otlpEndpoint and otlpFile receive the OpenTelemetry traces and metrics of the command when set
+	otlpEndpoint string
+	otlpFile     string
+	// output is the format chosen with the --output flag of the command
+	output string
+}
+
+// globalFlags describes the global flags for help and completion
+var globalFlags = []struct{ name, value, usage string }{
+	{"config", "path", "configuration file of the workspace (default " + workspace.ConfigFile + ")"},
+	{"dir", "path", "run in the workspace at path instead of the working directory"},
+	{"verbose", "", "print details of what the command does to stderr"},
+	{"quiet", "", "print only results and errors"},
+	{"log-format", "text|json", "format of the log written to stderr (default text, json with --output json)"},
+	{"trace", "", "log every request to the services with its status, latency, sizes and correlation ids"},
+	{"trace-dir", "path", "trace, and write every request and response to a file in path with credentials redacted"},
+	{"otlp-endpoint", "url", "export OpenTelemetry traces and metrics to the OTLP/HTTP collector at url (default $OTEL_EXPORTER_OTLP_ENDPOINT)"},
+	{"otlp-file", "path", "append OpenTelemetry traces and metrics to path as OTLP JSON lines, for offline use"},
+}
+
+// usageOutput receives the usage of commands, stdout when asked for with help
+var usageOutput io.Writer = os.Stderr
+
+// describeFlags, while set, is given the flag set of a command instead of printing its usage, for help --output json
+var describeFlags func(flags *flag.FlagSet)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "__complete" {
+		completeCommandLine(os.Args[2:])
+		return
+	}
+
+	args, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		printUsage(os.Stderr)
+		exit(Exit_Usage)
+	}
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		exit(Exit_Usage)
+	}
+	configureLogging()
+	applyGlobalOptions()
+
+	cmd, rest := findCommand(args)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", strings.Join(args[:min(len(args), 2)], " "))
+		printUsage(os.Stderr)
+		exit(Exit_Usage)
+	}
+
+	setupTelemetry(cmd)
+	slog.Debug("Running command", "command", cmd.name, "dir", workingDirectory())
+	cmd.run(rest)
+	exit(0)
+}
+
+// parseGlobalFlags removes the global flags from args and records them in globalOptions. Flags after -- are left to
+// the command.
+func parseGlobalFlags(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			continue
+		}
+
+		switch name {
+		case "config", "dir", "log-format", "trace-dir", "otlp-endpoint", "otlp-file":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("flag needs an argument: --%s", name)
+				}
+				i++
+				value = args[i]
+			}
+			switch name {
+			case "config":
+				globalOptions.config = value
+			case "dir":
+				globalOptions.dir = value
+			case "trace-dir":
+				globalOptions.traceDir = value
+			case "otlp-endpoint":
+				globalOptions.otlpEndpoint = value
+			case "otlp-file":
+				globalOptions.otlpFile = value
+			default:
+				if value != Output_Text && value != Output_JSON {
+					return nil, fmt.Errorf("invalid value %q for flag --log-format: expected %s or %s", value, Output_Text, Output_JSON)
+				}
+				globalOptions.logFormat = value
+			}
+		case "verbose", "quiet", "trace":
+			enabled := !hasValue || value == "true"
+			switch name {
+			case "verbose":
+				globalOptions.verbose = enabled
+			case "quiet":
+				globalOptions.quiet = enabled
+			default:
+				globalOptions.trace = enabled
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if globalOptions.verbose && globalOptions.quiet {
+		return nil, fmt.Errorf("--verbose and --quiet cannot be combined")
+	}
+	if globalOptions.traceDir != "" {
+		globalOptions.trace = true
+	}
+	return rest, nil
+}
+
+// applyGlobalOptions changes to the workspace directory and loads its .env file
+func applyGlobalOptions() {
+	if globalOptions.dir != "" {
+		if err := os.Chdir(globalOptions.dir); err != nil {
+			fatalf("Failed to change to workspace directory: %v", err)
+		}
+	}
+
+	// Variables set in the environment take precedence over .env, which is optional
+	godotenv.Load()
+}
+
+// findCommand returns the command named by the first words of args and the arguments following its name
+func findCommand(args []string) (*command, []string) {
+	if len(args) > 1 {
+		if cmd := lookupCommand(args[0] + " " + args[1]); cmd != nil {
+			return cmd, args[2:]
+		}
+	}
+	if len(args) > 0 {
+		if cmd := lookupCommand(args[0]); cmd != nil {
+			return cmd, args[1:]
+		}
+	}
+	return nil, nil
+}
+
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newHelpReport describes commands and the global flags for help --output json
+func newHelpReport(cmds []*command) *helpReport {
+	report := &helpReport{}
+	for _, cmd := range cmds {
+		report.Commands = append(report.Commands, helpCommand{Name: cmd.name, Args: cmd.args, Summary: cmd.summary})
+	}
+	for _, global := range globalFlags {
+		report.GlobalFlags = append(report.GlobalFlags, helpFlag{Name: global.name, Usage: global.usage})
+	}
+	return report
+}
+
+// printUsage lists the commands and global flags
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [global flags] <command> [flags] [arguments]\n\nCommands:\n", Program_Name)
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(writer, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	writer.Flush()
+
+	fmt.Fprintln(w, "\nGlobal flags:")
+	printGlobalFlags(w)
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command.\n", Program_Name)
+}
+
+func printGlobalFlags(w io.Writer) {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, global := range globalFlags {
+		fmt.Fprintf(writer, "  --%s %s\t%s\n", global.name, global.value, global.usage)
+	}
+	writer.Flush()
+}
+
+// newFlagSet creates the flag set of a command, whose usage is generated from the command and its flags. Every command
+// takes --output choosing between formats, text and json unless given, the first one being the default.
+func newFlagSet(name string, formats ...string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	addOutputFlag(flags, formats...)
+	flags.Usage = func() {
+		if completion != nil {
+			completion.completeFlags(flags)
+			return
+		}
+		if describeFlags != nil {
+			describeFlags(flags)
+			return
+		}
+
+		flags.SetOutput(usageOutput)
+		cmd := lookupCommand(name)
+		fmt.Fprintf(usageOutput, "%s\n\n", strings.TrimSpace(fmt.Sprintf("Usage: %s %s [flags] %s", Program_Name, name, cmd.args)))
+		fmt.Fprintf(usageOutput, "%s\n\nFlags:\n", cmd.summary)
+		flags.PrintDefaults()
+		fmt.Fprintln(usageOutput, "\nGlobal flags:")
+		printGlobalFlags(usageOutput)
+	}
+	return flags
+}
+
+// parseFlags parses the flags of a command, which may come before or after its arguments up to a -- argument
+func parseFlags(flags *flag.FlagSet, args []string) {
+	var positional []string
+	for {
+		parseArgs(flags, args)
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		if args[0] == "--" {
+			positional = append(positional, args[1:]...)
+			break
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	parseArgs(flags, append([]string{"--"}, positional...))
+}
+
+// parseArgs parses flags from args, exiting once the usage was asked for with -h or printed for a flag not valid
+func parseArgs(flags *flag.FlagSet, args []string) {
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		exit(0)
+	} else if err != nil {
+		// The flag package printed the error with the usage already
+		exitError(errorDetail{Code: Error_Usage, Message: err.Error()})
+	}
+}
+
+// usageError reports a mistake in the arguments of a command with its usage and exits with Exit_Usage
+func usageError(flags *flag.FlagSet, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n\n", args...)
+	flags.Usage()
+	exitError(errorDetail{Code: Error_Usage, Message: fmt.Sprintf(format, args...)})
+}
+
+// addOutputFlag registers the --output flag choosing between formats, the first one being the default, and records
+// the format chosen in globalOptions
+func addOutputFlag(flags *flag.FlagSet, formats ...string) {
+	if len(formats) == 0 {
+		formats = []string{Output_Text, Output_JSON}
+	}
+	globalOptions.output = formats[0]
+	usage := fmt.Sprintf("output format: %s (default %s)", strings.Join(formats, ", "), formats[0])
+	flags.Func("output", usage, func(value string) error {
+		if !slices.Contains(formats, value) {
+			return fmt.Errorf("expected %s", strings.Join(formats, ", "))
+		}
+		globalOptions.output = value
+		// The log follows the output to JSON unless --log-format says otherwise
+		configureLogging()
+		return nil
+	})
+}
+
+// jsonOutput reports whether the command prints its result as JSON, everything else then goes to the log on stderr
+func jsonOutput() bool {
+	return globalOptions.output == Output_JSON
+}
+
+// helpReport is the --output json form of help
+type helpReport struct {
+	Commands    []helpCommand `json:"commands"`
+	GlobalFlags []helpFlag    `json:"globalFlags"`
+}
+
+type helpCommand struct {
+	Name    string `json:"name"`
+	Args    string `json:"args,omitempty"`
+	Summary string `json:"summary"`
+	// Flags are listed for the command help was asked about
+	Flags []helpFlag `json:"flags,omitempty"`
+}
+
+type helpFlag struct {
+	Name    string `json:"name"`
+	Usage   string `json:"usage"`
+	Default string `json:"default,omitempty"`
+}
+
+// ShowHelp prints the usage of the tool, or of the command named by args
+func ShowHelp(args []string) {
+	flags := newFlagSet("help")
+	parseFlags(flags, args)
+
+	if flags.NArg() == 0 {
+		if jsonOutput() {
+			printJSON(newHelpReport(commands))
+			return
+		}
+		printUsage(os.Stdout)
+		return
+	}
+
+	cmd, _ := findCommand(flags.Args())
+	if cmd == nil {
+		usageError(flags, "Unknown command %q", strings.Join(flags.Args(), " "))
+	}
+
+	if jsonOutput() {
+		// The flags of a command are described through its usage, which is captured instead of printed
+		report := newHelpReport([]*command{cmd})
+		describeFlags = func(flags *flag.FlagSet) {
+			flags.VisitAll(func(f *flag.Flag) {
+				report.Commands[0].Flags = append(report.Commands[0].Flags, helpFlag{Name: f.Name, Usage: f.Usage, Default: f.DefValue})
+			})
+			printJSON(report)
+		}
+		cmd.run([]string{"-h"})
+		return
+	}
+
+	// The flags of a command are only known to the command, which prints its usage and exits when asked with -h
+	usageOutput = os.Stdout
+	cmd.run([]string{"-h"})
+}
+
+// dashboardIDArg returns the dashboard named by the first argument of a command, or else dashboard_id of config.yml
+func dashboardIDArg(flags *flag.FlagSet) string {
+	if dashboardID := flags.Arg(0); dashboardID != "" {
+		return dashboardID
+	}
+	if dashboardID := localConfig().DashboardID; dashboardID != "" {
+		return dashboardID
+	}
+	usageError(flags, "No dashboard id given and dashboard_id not set in %s", configPath())
+	return ""
+}
+
+// dashboardsAccessToken returns the authorization of requests to the dashboards service, from ACCESS_TOKEN in the
+// environment or .env
+func dashboardsAccessToken() string {
+	accessToken := os.Getenv("ACCESS_TOKEN")
+	if accessToken == "" && os.Getenv("DASHBOARDS_REPLAY") == "" {
+		fatalCodef(Error_Config, "ACCESS_TOKEN not set in .env file or the environment")
+	}
+	return "Bearer " + accessToken
+}
+
+// dashboardsTokenProvider returns the authorization of requests to the dashboards service for commands that keep
+// running: ACCESS_TOKEN as is when set, or else tokens from the Azure CLI, requested again before they expire
+func dashboardsTokenProvider() dataexplorer.TokenProvider {
+	if accessToken := os.Getenv("ACCESS_TOKEN"); accessToken != "" || os.Getenv("DASHBOARDS_REPLAY") != "" {
+		return dataexplorer.StaticToken("Bearer " + accessToken)
+	}
+	return dataexplorer.NewAzureCLIToken(Dashboards_Resource)
+}
+
+// configPath returns the configuration file of the workspace
+func configPath() string {
+	if globalOptions.config != "" {
+		return globalOptions.config
+	}
+	return workspace.ConfigFile
+}
+
+func workingDirectory() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
+// printInfo prints the progress of a command, unless --quiet is given. With --output json it is logged instead, so
+// stdout only holds the result.
+func printInfo(format string, args ...interface{}) {
+	if jsonOutput() {
+		slog.Info(logMessage(format, args...))
+	} else if !globalOptions.quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// logMessage formats a message printed as a line for the log
+func logMessage(format string, args ...interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf(format, args...))
+}
+
+// printJSON writes value as indented JSON to stdout, for --output json
+func printJSON(value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fatalf("Failed to marshal output: %v", err)
+	}
+	fmt.Println(string(data))
+}
+
+// printJSONLine writes value as JSON on a single line to stdout, for commands printing results as they go
+func printJSONLine(value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		fatalf("Failed to marshal output: %v", err)
+	}
+	fmt.Println(string(data))
+}
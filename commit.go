@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/git"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// SyncCommand pulls a dashboard on a branch of its own and commits the changes there, leaving the branch checked out
+// before untouched, so the changes can be reviewed in a pull request from the sync branch
+func SyncCommand(args []string) {
+	flags := newFlagSet("sync")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	factorLets := flags.String("factor-lets", "", "move let statements shared by several queries into snippets or base-queries")
+	branch := flags.String("branch", "", "branch to commit the pulled dashboard on, created from the current branch if missing (default dashboard-sync/<dashboard id>)")
+	parseFlags(flags, args)
+
+	dashboardID := dashboardIDArg(flags)
+	if *branch == "" {
+		*branch = "dashboard-sync/" + dashboardID
+	}
+	accessToken := dashboardsAccessToken()
+	repo := openRepo()
+
+	original, err := repo.CurrentBranch()
+	if err != nil {
+		fatalf("Failed to read the current branch: %v", err)
+	}
+	if original == "" {
+		fatalf("HEAD is detached, check out a branch to come back to after syncing")
+	}
+	if original == *branch {
+		fatalf("Already on %s, use pull --commit to commit on the current branch", *branch)
+	}
+	// Uncommitted changes would be carried over to the sync branch, or get in the way of switching to it. Untracked
+	// files of the template would be overwritten by the forced pull, or committed on the sync branch and removed when
+	// switching back.
+	changed, err := repo.Modified()
+	if err != nil {
+		fatalf("Failed to read the status of the work tree: %v", err)
+	}
+	untracked, err := repo.Untracked(templatePathspecs()...)
+	if err != nil {
+		fatalf("Failed to read the status of the work tree: %v", err)
+	}
+	if changed = append(changed, untracked...); len(changed) > 0 {
+		exitError(errorDetail{
+			Code:    Error_LocalChanges,
+			Message: fmt.Sprintf("The work tree has uncommitted changes (%s), commit or stash them (git stash --include-untracked) before syncing", strings.Join(changed, ", ")),
+			Files:   changed,
+		})
+	}
+
+	if err := repo.Checkout(*branch, !repo.BranchExists(*branch)); err != nil {
+		fatalf("Failed to check out %s: %v", *branch, err)
+	}
+	printInfo("Syncing on %s\n", *branch)
+
+	// The sync branch only ever holds pulled dashboards, so the dashboard wins over whatever is on it
+	options := workspace.PullOptions{FactorLets: *factorLets, Force: true}
+	result, err := pullDashboard(localConfig().DashboardID, dashboardID, accessToken, checkLayout(flags, *layout), options)
+	if err != nil {
+		// A failed pull leaves the work tree as it was, so switching back is safe
+		checkoutBranch(repo, original)
+		exitPullFailed(result, err)
+	}
+	hash, err := commitPull(repo, checkLayout(flags, *layout), dashboardID, result)
+	if err != nil {
+		fatalf("Failed to commit on %s, which is left checked out with the pulled files: %v", *branch, err)
+	}
+	checkoutBranch(repo, original)
+
+	if hash == "" {
+		printInfo("%s is up to date with the dashboard\n", *branch)
+	} else {
+		printInfo("Committed %s on %s, open a pull request from it to review the changes\n", shortHash(hash), *branch)
+	}
+
+	if jsonOutput() {
+		report := newPullReport(dashboardID, result, true)
+		report.Branch, report.Commit = *branch, hash
+		printJSON(report)
+	}
+}
+
+// templatePathspecs are the git pathspecs of the files making up the dashboard template of the workspace, in either
+// layout, with its query files, snippets, values files and pull state
+func templatePathspecs() []string {
+	pathspecs := []string{workspace.TemplatePath, workspace.SplitPath, utils.QueriesDir, utils.ValuesFile, utils.ValuesFileFor("*"), workspace.PullStateFile}
+	return append(pathspecs, snippetPath()...)
+}
+
+func checkoutBranch(repo *git.Repo, branch string) {
+	if err := repo.Checkout(branch, false); err != nil {
+		fatalf("Failed to check out %s again: %v", branch, err)
+	}
+}
+
+// openRepo returns the git repository of the workspace, exiting when there is none
+func openRepo() *git.Repo {
+	repo, err := git.Open(".")
+	if err != nil {
+		fatalf("Failed to open the git repository of the workspace: %v", err)
+	}
+	return repo
+}
+
+// commitPull commits the files a pull changed with a message summarizing what changed in the dashboard since the
+// template at HEAD, and returns the hash of the commit, empty when the pull changed nothing git does not have yet
+func commitPull(repo *git.Repo, layout string, dashboardID string, result *workspace.PullResult) (string, error) {
+	paths := append(append([]string{workspace.PullStateFile}, result.Files...), result.Removed...)
+	changed, err := repo.Changed(paths...)
+	if err != nil {
+		return "", err
+	}
+	if len(changed) == 0 {
+		return "", nil
+	}
+
+	message := fmt.Sprintf("Pull dashboard %q (%s)\n\n", result.Dashboard.Title, dashboardID)
+	changes, err := templateChanges(repo, layout)
+	if err != nil {
+		// The files changed still tell what the commit is about
+		slog.Debug("Failed to compare the template with HEAD, listing the files changed instead", "error", err)
+		changes = changed
+	}
+	for _, change := range changes {
+		message += "- " + change + "\n"
+	}
+
+	return repo.Commit(message, changed...)
+}
+
+// templateChanges summarizes how the template of the workspace differs from the one at HEAD
+func templateChanges(repo *git.Repo, layout string) ([]string, error) {
+	head, err := repo.Files("HEAD", workspace.BackupDir, workspace.StagingDir)
+	if errors.Is(err, git.ErrNoCommits) {
+		head = utils.NewMemFS(nil)
+	} else if err != nil {
+		return nil, err
+	}
+	return openWorkspace(layout, nil).TemplateChanges(head)
+}
+
+// shortHash abbreviates a commit hash the way git does by default
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// completionCandidate is a word offered by shell completion, with a description shown by zsh and fish
+type completionCandidate struct {
+	value       string
+	description string
+}
+
+// completionContext is the command line being completed. While it is set the flag set of the command is described
+// as completion candidates instead of printing its usage.
+type completionContext struct {
+	cmd *command
+	// args are the words after the command name before the word being completed
+	args    []string
+	current string
+}
+
+var completion *completionContext
+
+const bashCompletion = `# bash completion for kusto-dashboards-sync, load with: source <(kusto-dashboards-sync completion bash)
+_kusto_dashboards_sync() {
+    local IFS=$'\n'
+    local candidates
+    candidates=$(kusto-dashboards-sync __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1)
+    COMPREPLY=($(compgen -W "$candidates" -- "${COMP_WORDS[COMP_CWORD]}"))
+}
+complete -o default -F _kusto_dashboards_sync kusto-dashboards-sync
+`
+
+const zshCompletion = `#compdef kusto-dashboards-sync
+# zsh completion for kusto-dashboards-sync, load with: source <(kusto-dashboards-sync completion zsh)
+_kusto_dashboards_sync() {
+    local -a candidates
+    local line
+    for line in "${(@f)$(kusto-dashboards-sync __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+        [[ -n $line ]] || continue
+        candidates+=("${${line%%$'\t'*}//:/\\:}:${line#*$'\t'}")
+    done
+    _describe 'kusto-dashboards-sync' candidates || _files
+}
+compdef _kusto_dashboards_sync kusto-dashboards-sync
+`
+
+const fishCompletion = `# fish completion for kusto-dashboards-sync, load with: kusto-dashboards-sync completion fish | source
+function __kusto_dashboards_sync_complete
+    kusto-dashboards-sync __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c kusto-dashboards-sync -f -a '(__kusto_dashboards_sync_complete)'
+`
+
+// completionReport is the --output json form of completion
+type completionReport struct {
+	Shell  string `json:"shell"`
+	Script string `json:"script"`
+}
+
+// PrintCompletion prints the completion script of a shell
+func PrintCompletion(args []string) {
+	flags := newFlagSet("completion")
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		usageError(flags, "Expected the shell to print the completion script of")
+	}
+
+	var script string
+	switch flags.Arg(0) {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		usageError(flags, "Unknown shell %q, expected bash, zsh or fish", flags.Arg(0))
+	}
+
+	if jsonOutput() {
+		printJSON(completionReport{Shell: flags.Arg(0), Script: script})
+		return
+	}
+	fmt.Print(script)
+}
+
+// completeCommandLine prints the completion candidates of the last of words, the words of the command line after the
+// program name, one per line followed by a tab and their description. The completion scripts run it as __complete.
+func completeCommandLine(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	previous := words[:len(words)-1]
+
+	// Values of global flags are paths and URLs, left to the shell
+	if len(previous) > 0 && slices.Contains([]string{"--config", "--dir", "--trace-dir", "--otlp-endpoint", "--otlp-file"}, previous[len(previous)-1]) {
+		return
+	}
+	if len(previous) > 0 && previous[len(previous)-1] == "--log-format" {
+		printCandidates([]completionCandidate{{Output_Text, "key=value lines"}, {Output_JSON, "JSON lines"}})
+		return
+	}
+	previous, _ = parseGlobalFlags(previous)
+	applyGlobalOptions()
+
+	cmd, args := findCommand(previous)
+	if cmd != nil {
+		// The flags of a command are only known to the command, which describes them through its usage when asked with -h
+		completion = &completionContext{cmd: cmd, args: args, current: current}
+		cmd.run([]string{"-h"})
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(current, "-"):
+		printCandidates(globalFlagCandidates())
+	case len(previous) == 0:
+		printCandidates(commandCandidates())
+	case len(previous) == 1:
+		// Words naming a group of commands, such as kql, are completed with the rest of the command names
+		for _, cmd := range commands {
+			if group, name, ok := strings.Cut(cmd.name, " "); ok && group == previous[0] {
+				printCandidates([]completionCandidate{{name, cmd.summary}})
+			}
+		}
+	}
+}
+
+// completeFlags prints the completion candidates of the command line being completed given the flags of its command
+func (c *completionContext) completeFlags(flags *flag.FlagSet) {
+	if strings.HasPrefix(c.current, "-") {
+		var candidates []completionCandidate
+		flags.VisitAll(func(f *flag.Flag) {
+			candidates = append(candidates, completionCandidate{"--" + f.Name, f.Usage})
+		})
+		printCandidates(append(candidates, globalFlagCandidates()...))
+		return
+	}
+
+	// The value of a flag given as a separate word
+	if len(c.args) > 0 {
+		last := c.args[len(c.args)-1]
+		if name := strings.TrimLeft(last, "-"); strings.HasPrefix(last, "-") && !strings.Contains(name, "=") {
+			if f := flags.Lookup(name); f != nil && !isBoolFlag(f) {
+				if name == "layout" {
+					printCandidates([]completionCandidate{{Layout_Single, "dashboard.yml and queries"}, {Layout_Split, "a file per tile under dashboard"}})
+				}
+				return
+			}
+		}
+	}
+
+	if c.cmd.complete != nil {
+		printCandidates(c.cmd.complete())
+	}
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+func printCandidates(candidates []completionCandidate) {
+	for _, candidate := range candidates {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", candidate.value, candidate.description)
+	}
+}
+
+func globalFlagCandidates() []completionCandidate {
+	var candidates []completionCandidate
+	for _, global := range globalFlags {
+		candidates = append(candidates, completionCandidate{"--" + global.name, global.usage})
+	}
+	return candidates
+}
+
+// commandCandidates lists the first word of every command
+func commandCandidates() []completionCandidate {
+	var candidates []completionCandidate
+	seen := map[string]bool{}
+	for _, cmd := range commands {
+		name, _, grouped := strings.Cut(cmd.name, " ")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		description := cmd.summary
+		if grouped {
+			description = name + " commands"
+		}
+		candidates = append(candidates, completionCandidate{name, description})
+	}
+	return candidates
+}
+
+// dashboardCandidates lists dashboard_id and the dashboards of config.yml, described by their title in the backup
+// archive when they have been backed up
+func dashboardCandidates() []completionCandidate {
+	config := localConfig()
+	ws := openWorkspace("", nil)
+
+	var candidates []completionCandidate
+	for _, dashboardID := range append([]string{config.DashboardID}, config.Dashboards...) {
+		if dashboardID == "" {
+			continue
+		}
+		description := ""
+		if history, err := ws.History(dashboardID); err == nil && len(history) > 0 {
+			description = history[0].Title
+		}
+		candidates = append(candidates, completionCandidate{dashboardID, description})
+	}
+	return candidates
+}
+
+// snapshotCandidates lists the snapshots of the backup archive, newest first
+func snapshotCandidates() []completionCandidate {
+	history, err := openWorkspace("", nil).History("")
+	if err != nil {
+		return nil
+	}
+
+	var candidates []completionCandidate
+	for _, snapshot := range history {
+		description := fmt.Sprintf("%s, %s", snapshot.Title, snapshot.TakenAt.Local().Format(time.DateTime))
+		candidates = append(candidates, completionCandidate{snapshot.ShortHash(), description})
+	}
+	return candidates
+}
+
+func shellCandidates() []completionCandidate {
+	return []completionCandidate{{"bash", ""}, {"zsh", ""}, {"fish", ""}}
+}
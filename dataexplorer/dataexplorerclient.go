@@ -2,25 +2,83 @@ package dataexplorer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/telemetry"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
+)
+
+// DashboardsAPI is the dashboards service of Data Explorer. Code reading and writing dashboards depends on it rather
+// than on DataExplorerClient, so it can be given a fake or a client replaying recorded exchanges.
+type DashboardsAPI interface {
+	GetDashboard(dashboardID string) (*models.Dashboard, error)
+	GetDashboardRaw(dashboardID string) (*interface{}, error)
+	ListDashboards() ([]models.Dashboard, error)
+	CreateDashboardRaw(dashboard *interface{}) (*interface{}, error)
+	// UpdateDashboardRaw returns the eTag of the dashboard as saved, empty when the service does not tell it
+	UpdateDashboardRaw(dashboardID string, dashboard *interface{}) (string, error)
+	DeleteDashboard(dashboardID string) error
+}
+
+// UpdatePreparer is implemented by clients of the dashboards service that can tell the request updating a dashboard
+// would send without sending it, for dry runs
+type UpdatePreparer interface {
+	PrepareUpdateDashboard(dashboardID string, dashboard *interface{}) (*RecordedRequest, error)
+}
+
+// ContextBinder is implemented by clients of the dashboards service whose requests can be bound to a context, so their
+// telemetry spans are children of the span the context carries
+type ContextBinder interface {
+	WithContext(ctx context.Context) DashboardsAPI
+}
+
+var (
+	_ DashboardsAPI  = (*DataExplorerClient)(nil)
+	_ UpdatePreparer = (*DataExplorerClient)(nil)
+	_ ContextBinder  = (*DataExplorerClient)(nil)
 )
 
 // DataExplorerClient represents a Data Explorer client
 type DataExplorerClient struct {
 	Client  *http.Client
 	BaseURL string
+	// Context is the context of the requests sent, context.Background() when nil
+	Context context.Context
+}
+
+// WithContext returns a copy of the client sending its requests with ctx
+func (dec *DataExplorerClient) WithContext(ctx context.Context) DashboardsAPI {
+	bound := *dec
+	bound.Context = ctx
+	return &bound
+}
+
+// newRequest creates a request with the context of the client
+func (dec *DataExplorerClient) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	ctx := dec.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return http.NewRequestWithContext(ctx, method, url, body)
 }
 
 // NewDataExplorerClient creates a new instance of DataExplorerClient
 func NewDataExplorerClient(baseURL string, accessToken string) *DataExplorerClient {
+	return NewTokenProviderClient(baseURL, StaticToken(accessToken))
+}
+
+// NewTokenProviderClient creates a DataExplorerClient authorizing requests with the tokens of tokenProvider, such as
+// an AzureCLIToken requesting them again before they expire
+func NewTokenProviderClient(baseURL string, tokenProvider TokenProvider) *DataExplorerClient {
 	client := &http.Client{}
 	client.Transport = &Transport{
-		AccessToken: accessToken,
+		TokenProvider: tokenProvider,
 	}
 
 	return &DataExplorerClient{
@@ -29,21 +87,92 @@ func NewDataExplorerClient(baseURL string, accessToken string) *DataExplorerClie
 	}
 }
 
+// StatusError is returned, wrapped, when a service answers a request with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	// Body is the response, or the message of the error it describes
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("status code %d: %s", e.StatusCode, e.Body)
+}
+
+// TraceparentHeader carries the W3C trace context of a request, set by Transport with telemetry so the service can
+// tie the request to the span of the sync that sent it
+const TraceparentHeader = "Traceparent"
+
+// requestDuration is the duration of the requests sent by Transport, named and bucketed as OpenTelemetry recommends
+var requestDuration = telemetry.NewHistogram("http.client.request.duration", "Duration of the HTTP requests sent to the services", "s", telemetry.Duration_Buckets)
+
 // Transport is a custom RoundTripper that adds Authorization header to each request
 type Transport struct {
-	AccessToken string
+	TokenProvider TokenProvider
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
 }
 
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("Authorization", t.AccessToken)
+	authorization, err := t.TokenProvider.AuthorizationHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	setRequestHeaders(req, authorization)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	span := telemetry.StartClient(req.Context(), req.Method, "http.request.method", req.Method, "url.full", req.URL.String(), "server.address", req.URL.Hostname())
+	if traceparent := span.Traceparent(); traceparent != "" {
+		req.Header.Set(TraceparentHeader, traceparent)
+	}
+	metricAttrs := []any{"http.request.method", req.Method, "server.address", req.URL.Hostname()}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	duration := time.Since(start)
+	if err != nil {
+		slog.Debug("Request failed", "method", req.Method, "url", req.URL.String(), "duration", duration, "error", err)
+		requestDuration.Record(duration.Seconds(), append(metricAttrs, "error.type", fmt.Sprintf("%T", err))...)
+		span.End(err)
+		return nil, err
+	}
+	slog.Debug("Request sent", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "duration", duration)
+
+	requestDuration.Record(duration.Seconds(), append(metricAttrs, "http.response.status_code", resp.StatusCode)...)
+	span.SetAttributes("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.End(&StatusError{StatusCode: resp.StatusCode})
+	} else {
+		span.End(nil)
+	}
+	return resp, nil
+}
+
+// setRequestHeaders sets the headers Transport adds to every request
+func setRequestHeaders(req *http.Request, authorization string) {
+	req.Header.Set("Authorization", authorization)
 	req.Header.Set("Content-Type", "application/json")
-	return http.DefaultTransport.RoundTrip(req)
+}
+
+// dashboardURL returns the URL of a dashboard, or of the dashboards collection for an empty id
+func (dec *DataExplorerClient) dashboardURL(dashboardID string) string {
+	base := strings.TrimSuffix(dec.BaseURL, "/")
+	if dashboardID == "" {
+		return base
+	}
+	return base + "/" + dashboardID
 }
 
 // GetDashboard fetches the dashboard using the provided ID
 func (dec *DataExplorerClient) GetDashboard(dashboardID string) (*models.Dashboard, error) {
-	url := fmt.Sprintf("%s/%s", dec.BaseURL, dashboardID)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := dec.newRequest(http.MethodGet, dec.dashboardURL(dashboardID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
@@ -56,8 +185,7 @@ func (dec *DataExplorerClient) GetDashboard(dashboardID string) (*models.Dashboa
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		return nil, fmt.Errorf("error response from server: %s", bodyString)
+		return nil, fmt.Errorf("error response from server: %w", &StatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)})
 	}
 
 	var dashboard models.Dashboard
@@ -72,7 +200,11 @@ func (dec *DataExplorerClient) GetDashboard(dashboardID string) (*models.Dashboa
 // GetDashboardRaw retrieves a dashboard using a GET call with the provided HTTP client and returns the dashboard data or an error
 func (dec *DataExplorerClient) GetDashboardRaw(dashboardID string) (*interface{}, error) {
 	// Make a GET request to retrieve the dashboard
-	resp, err := dec.Client.Get(fmt.Sprintf("%s/%s", dec.BaseURL, dashboardID))
+	req, err := dec.newRequest(http.MethodGet, dec.dashboardURL(dashboardID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating GET request: %v", err)
+	}
+	resp, err := dec.Client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error making GET request: %v", err)
 	}
@@ -86,7 +218,7 @@ func (dec *DataExplorerClient) GetDashboardRaw(dashboardID string) (*interface{}
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error retrieving dashboard: status code %d", resp.StatusCode)
+		return nil, fmt.Errorf("error retrieving dashboard: %w", &StatusError{StatusCode: resp.StatusCode})
 	}
 
 	// Unmarshal the response body into a Dashboard struct
@@ -100,13 +232,12 @@ func (dec *DataExplorerClient) GetDashboardRaw(dashboardID string) (*interface{}
 
 // UploadDashboard uploads the dashboard using the provided ID
 func (dec *DataExplorerClient) UploadDashboard(dashboardID string, dashboard *models.Dashboard) error {
-	url := fmt.Sprintf("%s/%s", dec.BaseURL, dashboardID)
 	dashboardJSON, err := json.MarshalIndent(dashboard, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error marshaling dashboard: %w", err)
 	}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(dashboardJSON))
+	req, err := dec.newRequest(http.MethodPut, dec.dashboardURL(dashboardID), bytes.NewBuffer(dashboardJSON))
 	if err != nil {
 		return fmt.Errorf("error creating request: %w", err)
 	}
@@ -119,47 +250,175 @@ func (dec *DataExplorerClient) UploadDashboard(dashboardID string, dashboard *mo
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		return fmt.Errorf("error response from server: %s", bodyString)
+		return fmt.Errorf("error response from server: %w", &StatusError{StatusCode: resp.StatusCode, Body: string(bodyBytes)})
 	}
 
-	log.Printf("Successfully uploaded dashboard with ID %s. HTTP Status: %s", dashboardID, resp.Status)
+	slog.Info("Uploaded dashboard", "dashboard", dashboardID, "status", resp.Status)
 	return nil
 }
 
-// UpdateDashboardRaw uploads a dashboard using a POST call with the provided HTTP client and returns an error if any
-func (dec *DataExplorerClient) UpdateDashboardRaw(dashboardId string, dashboard *interface{}) error {
+// UpdateDashboardRaw uploads a dashboard using a PUT call with the provided HTTP client and returns the eTag of the
+// dashboard as saved, read from the dashboard the service answers with or else from the ETag header
+func (dec *DataExplorerClient) UpdateDashboardRaw(dashboardId string, dashboard *interface{}) (string, error) {
+	req, err := dec.newUpdateDashboardRequest(dashboardId, dashboard)
+	if err != nil {
+		return "", err
+	}
+
+	// Send the PUT request
+	resp, err := dec.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error making PUT request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %v", err)
+	}
+
+	// Check the response status code
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error uploading dashboard: %w", &StatusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+
+	var saved struct {
+		ETag string `json:"eTag"`
+	}
+	if json.Unmarshal(body, &saved) == nil && saved.ETag != "" {
+		return saved.ETag, nil
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// PrepareUpdateDashboard returns the request UpdateDashboardRaw would send, with the headers added by Transport and
+// the token redacted, without sending it or requesting a token
+func (dec *DataExplorerClient) PrepareUpdateDashboard(dashboardID string, dashboard *interface{}) (*RecordedRequest, error) {
+	req, err := dec.newUpdateDashboardRequest(dashboardID, dashboard)
+	if err != nil {
+		return nil, err
+	}
+	setRequestHeaders(req, "Bearer "+scrubbedToken)
+
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	headers := map[string]string{}
+	for name, values := range req.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	return &RecordedRequest{Method: req.Method, URL: req.URL.String(), Headers: headers, Body: body}, nil
+}
+
+// newUpdateDashboardRequest creates the PUT request uploading a dashboard
+func (dec *DataExplorerClient) newUpdateDashboardRequest(dashboardID string, dashboard *interface{}) (*http.Request, error) {
 	// Marshal the dashboard data into JSON
 	payload, err := json.Marshal(dashboard)
 	if err != nil {
-		return fmt.Errorf("error marshalling dashboard data: %v", err)
+		return nil, fmt.Errorf("error marshalling dashboard data: %v", err)
 	}
 
 	// Create a PUT request to upload the dashboard
-	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://dashboards.kusto.windows.net/dashboards/%s", dashboardId), bytes.NewBuffer(payload))
+	req, err := dec.newRequest(http.MethodPut, dec.dashboardURL(dashboardID), bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("error creating PUT request: %v", err)
+		return nil, fmt.Errorf("error creating PUT request: %v", err)
 	}
 
-	// Send the PUT request
+	return req, nil
+}
+
+// ListDashboards retrieves the dashboards the caller has access to, with their id, title and eTag
+func (dec *DataExplorerClient) ListDashboards() ([]models.Dashboard, error) {
+	req, err := dec.newRequest(http.MethodGet, dec.dashboardURL(""), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating GET request: %v", err)
+	}
 	resp, err := dec.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error making PUT request: %v", err)
+		return nil, fmt.Errorf("error making GET request: %v", err)
 	}
 	defer resp.Body.Close()
 
-	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("error reading response body: %v", err)
+		return nil, fmt.Errorf("error reading response body: %v", err)
 	}
 
-	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("error uploading dashboard: status code %d, response body: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("error listing dashboards: %w", &StatusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+
+	// The collection is either a bare array or wrapped in a value property
+	var dashboards []models.Dashboard
+	if err := json.Unmarshal(body, &dashboards); err == nil {
+		return dashboards, nil
+	}
+	var page struct {
+		Value []models.Dashboard `json:"value"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboards: %v", err)
+	}
+
+	return page.Value, nil
+}
+
+// CreateDashboardRaw creates a dashboard using a POST call and returns the dashboard created, holding its new id
+func (dec *DataExplorerClient) CreateDashboardRaw(dashboard *interface{}) (*interface{}, error) {
+	payload, err := json.Marshal(dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling dashboard data: %v", err)
+	}
+
+	req, err := dec.newRequest(http.MethodPost, dec.dashboardURL(""), bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating POST request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := dec.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making POST request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("error creating dashboard: %w", &StatusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+
+	var created interface{}
+	if err := json.Unmarshal(body, &created); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
 	}
 
-	fmt.Printf("Dashboard updated successfully\n")
+	return &created, nil
+}
+
+// DeleteDashboard deletes the dashboard with the provided ID
+func (dec *DataExplorerClient) DeleteDashboard(dashboardID string) error {
+	req, err := dec.newRequest(http.MethodDelete, dec.dashboardURL(dashboardID), nil)
+	if err != nil {
+		return fmt.Errorf("error creating DELETE request: %v", err)
+	}
+
+	resp, err := dec.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making DELETE request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error deleting dashboard: %w", &StatusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
 
 	return nil
 }
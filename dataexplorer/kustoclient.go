@@ -0,0 +1,130 @@
+package dataexplorer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// KustoClient runs queries against the REST query endpoint of Kusto clusters
+type KustoClient struct {
+	Client *http.Client
+}
+
+// NewKustoClient creates a new instance of KustoClient authenticating with the given token provider
+func NewKustoClient(tokenProvider TokenProvider) *KustoClient {
+	client := &http.Client{}
+	client.Transport = &Transport{
+		TokenProvider: tokenProvider,
+	}
+
+	return &KustoClient{
+		Client: client,
+	}
+}
+
+// QueryColumn describes a column of a query result
+type QueryColumn struct {
+	Name string
+	Type string
+}
+
+// QueryResult is the primary result table of a query
+type QueryResult struct {
+	Columns []QueryColumn
+	Rows    [][]interface{}
+}
+
+type queryRequest struct {
+	Database string `json:"db"`
+	Query    string `json:"csl"`
+}
+
+type queryResponse struct {
+	Tables []struct {
+		TableName string `json:"TableName"`
+		Columns   []struct {
+			ColumnName string `json:"ColumnName"`
+			DataType   string `json:"DataType"`
+			ColumnType string `json:"ColumnType"`
+		} `json:"Columns"`
+		Rows [][]interface{} `json:"Rows"`
+	} `json:"Tables"`
+}
+
+type queryErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Details string `json:"@message"`
+	} `json:"error"`
+}
+
+// Query runs a query against database on the cluster and returns its primary result table
+func (kc *KustoClient) Query(clusterUri string, database string, query string) (*QueryResult, error) {
+	return kc.QueryContext(context.Background(), clusterUri, database, query)
+}
+
+// QueryContext is Query sending the request with ctx
+func (kc *KustoClient) QueryContext(ctx context.Context, clusterUri string, database string, query string) (*QueryResult, error) {
+	payload, err := json.Marshal(queryRequest{Database: database, Query: query})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling query request: %w", err)
+	}
+
+	url := strings.TrimSuffix(clusterUri, "/") + "/v1/rest/query"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := kc.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var queryError queryErrorResponse
+		if json.Unmarshal(body, &queryError) == nil && queryError.Error.Message != "" {
+			message := queryError.Error.Message
+			if queryError.Error.Details != "" {
+				message = queryError.Error.Details
+			}
+			return nil, fmt.Errorf("query failed with %w", &StatusError{StatusCode: resp.StatusCode, Body: message})
+		}
+		return nil, fmt.Errorf("query failed with %w", &StatusError{StatusCode: resp.StatusCode, Body: string(body)})
+	}
+
+	var response queryResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	if len(response.Tables) == 0 {
+		return nil, fmt.Errorf("query returned no tables")
+	}
+
+	// The first table of a v1 response holds the primary result
+	table := response.Tables[0]
+	result := &QueryResult{Rows: table.Rows}
+	for _, column := range table.Columns {
+		columnType := column.ColumnType
+		if columnType == "" {
+			columnType = column.DataType
+		}
+		result.Columns = append(result.Columns, QueryColumn{Name: column.ColumnName, Type: columnType})
+	}
+
+	return result, nil
+}
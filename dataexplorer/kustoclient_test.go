@@ -0,0 +1,124 @@
+package dataexplorer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeQueryEndpoint serves the REST query endpoint of a cluster, answering every query with status and body and
+// recording the last request
+func fakeQueryEndpoint(t *testing.T, status int, body string) (*httptest.Server, *queryRequest, *http.Header) {
+	t.Helper()
+	var request queryRequest
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/rest/query" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		header = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &request, &header
+}
+
+func TestKustoClientQuery(t *testing.T) {
+	server, request, header := fakeQueryEndpoint(t, http.StatusOK, `{
+		"Tables": [
+			{
+				"TableName": "Table_0",
+				"Columns": [
+					{"ColumnName": "State", "DataType": "String", "ColumnType": "string"},
+					{"ColumnName": "Count", "DataType": "Int64"}
+				],
+				"Rows": [["TEXAS", 4701], ["KANSAS", 3166]]
+			},
+			{"TableName": "Table_1", "Columns": [{"ColumnName": "Value", "DataType": "String"}], "Rows": [["ignored"]]}
+		]
+	}`)
+
+	client := NewKustoClient(StaticToken("Bearer token"))
+	result, err := client.Query(server.URL+"/", "Samples", "StormEvents | summarize Count=count() by State | take 2")
+	if err != nil {
+		t.Fatalf("Query returned error: %v", err)
+	}
+
+	wantColumns := []QueryColumn{{Name: "State", Type: "string"}, {Name: "Count", Type: "Int64"}}
+	if !reflect.DeepEqual(result.Columns, wantColumns) {
+		t.Errorf("Columns = %v, want %v", result.Columns, wantColumns)
+	}
+	wantRows := [][]interface{}{{"TEXAS", float64(4701)}, {"KANSAS", float64(3166)}}
+	if !reflect.DeepEqual(result.Rows, wantRows) {
+		t.Errorf("Rows = %v, want %v", result.Rows, wantRows)
+	}
+
+	if request.Database != "Samples" || request.Query != "StormEvents | summarize Count=count() by State | take 2" {
+		t.Errorf("request = %+v", *request)
+	}
+	if got := header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization header = %q, want %q", got, "Bearer token")
+	}
+}
+
+func TestKustoClientQueryErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantStatus  int
+		wantMessage string
+	}{
+		{
+			name:        "error details",
+			status:      http.StatusBadRequest,
+			body:        `{"error": {"code": "General_BadRequest", "message": "Request is invalid and cannot be executed.", "@message": "Syntax error: Query could not be parsed"}}`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Syntax error: Query could not be parsed",
+		},
+		{
+			name:        "error message",
+			status:      http.StatusForbidden,
+			body:        `{"error": {"code": "Forbidden", "message": "Principal is not authorized to access database Samples"}}`,
+			wantStatus:  http.StatusForbidden,
+			wantMessage: "Principal is not authorized to access database Samples",
+		},
+		{
+			name:        "plain body",
+			status:      http.StatusServiceUnavailable,
+			body:        "Service Unavailable",
+			wantStatus:  http.StatusServiceUnavailable,
+			wantMessage: "Service Unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _, _ := fakeQueryEndpoint(t, tt.status, tt.body)
+
+			_, err := NewKustoClient(StaticToken("Bearer token")).Query(server.URL, "Samples", "StormEvents | take 1")
+			var statusError *StatusError
+			if !errors.As(err, &statusError) {
+				t.Fatalf("Query error = %v, want a StatusError", err)
+			}
+			if statusError.StatusCode != tt.wantStatus || statusError.Body != tt.wantMessage {
+				t.Errorf("StatusError = %d %q, want %d %q", statusError.StatusCode, statusError.Body, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestKustoClientQueryNoTables(t *testing.T) {
+	server, _, _ := fakeQueryEndpoint(t, http.StatusOK, `{"Tables": []}`)
+
+	if _, err := NewKustoClient(StaticToken("Bearer token")).Query(server.URL, "Samples", "print 1"); err == nil {
+		t.Error("Query of a response without tables succeeded")
+	}
+}
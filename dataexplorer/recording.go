@@ -0,0 +1,277 @@
+package dataexplorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Headers never written to recordings, as they carry credentials or change with every exchange
+var scrubbedHeaders = map[string]bool{
+	"Authorization":   true,
+	"Cookie":          true,
+	"Set-Cookie":      true,
+	"Date":            true,
+	"X-Ms-Request-Id": true,
+	// Set to a new id on every request by Tracer
+	ClientRequestIDHeader: true,
+	// Set to the span of the request with telemetry
+	TraceparentHeader: true,
+}
+
+// tokenRegex matches bearer tokens and JWTs appearing in bodies
+var tokenRegex = regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+const scrubbedToken = "REDACTED"
+
+// Exchange is a recorded HTTP request and its response
+type Exchange struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the request of a recorded exchange
+type RecordedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// RecordedResponse is the response of a recorded exchange
+type RecordedResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+}
+
+// Recorder is a RoundTripper passing requests on to Base and writing every exchange to a golden file at Path,
+// with credentials scrubbed
+type Recorder struct {
+	Path string
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewRecordingClient creates a DataExplorerClient whose exchanges with the service are recorded to path
+func NewRecordingClient(baseURL string, tokenProvider TokenProvider, path string) *DataExplorerClient {
+	return &DataExplorerClient{
+		Client: &http.Client{
+			Transport: &Transport{
+				TokenProvider: tokenProvider,
+				Base:          &Recorder{Path: path},
+			},
+		},
+		BaseURL: baseURL,
+	}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	base := r.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	exchange := Exchange{
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: recordedHeaders(req.Header),
+			Body:    scrubTokens(requestBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    recordedHeaders(resp.Header),
+			Body:       scrubTokens(responseBody),
+		},
+	}
+
+	// The file is rewritten after every exchange, so a recording survives the process exiting at any point
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exchanges = append(r.exchanges, exchange)
+	if err := writeExchanges(r.Path, r.exchanges); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Replayer is a RoundTripper answering requests with the exchanges of a golden file instead of sending them. Each
+// exchange answers one request with the same method, URL and body, in recorded order.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	used      []bool
+}
+
+// NewReplayer reads the exchanges recorded to path
+func NewReplayer(path string) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recording: %w", err)
+	}
+
+	var exchanges []Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("error unmarshalling recording %s: %w", path, err)
+	}
+
+	return &Replayer{exchanges: exchanges, used: make([]bool, len(exchanges))}, nil
+}
+
+// NewReplayClient creates a DataExplorerClient answered by the exchanges recorded to path, without network access
+func NewReplayClient(baseURL string, path string) (*DataExplorerClient, error) {
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataExplorerClient{
+		Client: &http.Client{
+			Transport: &Transport{
+				TokenProvider: StaticToken("Bearer " + scrubbedToken),
+				Base:          replayer,
+			},
+		},
+		BaseURL: baseURL,
+	}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+	body = scrubTokens(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, exchange := range r.exchanges {
+		recorded := exchange.Request
+		if r.used[i] || recorded.Method != req.Method || recorded.URL != req.URL.String() || !sameBody(recorded.Body, body) {
+			continue
+		}
+		r.used[i] = true
+
+		resp := &http.Response{
+			StatusCode:    exchange.Response.StatusCode,
+			Status:        fmt.Sprintf("%d %s", exchange.Response.StatusCode, http.StatusText(exchange.Response.StatusCode)),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader(exchange.Response.Body)),
+			ContentLength: int64(len(exchange.Response.Body)),
+			Request:       req,
+		}
+		for name, value := range exchange.Response.Headers {
+			resp.Header.Set(name, value)
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("no recorded exchange for %s %s", req.Method, req.URL)
+}
+
+// Unused returns the exchanges no request was answered with, e.g. to check that a test made every request recorded
+func (r *Replayer) Unused() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Exchange
+	for i, exchange := range r.exchanges {
+		if !r.used[i] {
+			unused = append(unused, exchange)
+		}
+	}
+	return unused
+}
+
+// readBody reads a request or response body and replaces it with one reading the same content again
+func readBody(body *io.ReadCloser) (string, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", nil
+	}
+
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return "", err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+
+	return string(data), nil
+}
+
+// recordedHeaders returns the headers worth recording, without credentials
+func recordedHeaders(header http.Header) map[string]string {
+	recorded := map[string]string{}
+	for name, values := range header {
+		if scrubbedHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		recorded[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+	}
+	if len(recorded) == 0 {
+		return nil
+	}
+	return recorded
+}
+
+func scrubTokens(text string) string {
+	return tokenRegex.ReplaceAllString(text, scrubbedToken)
+}
+
+// sameBody compares bodies as JSON when both are, so formatting differences do not matter
+func sameBody(recorded, actual string) bool {
+	if recorded == actual {
+		return true
+	}
+
+	var recordedValue, actualValue interface{}
+	if json.Unmarshal([]byte(recorded), &recordedValue) != nil || json.Unmarshal([]byte(actual), &actualValue) != nil {
+		return false
+	}
+	recordedJSON, _ := json.Marshal(recordedValue)
+	actualJSON, _ := json.Marshal(actualValue)
+	return bytes.Equal(recordedJSON, actualJSON)
+}
+
+// writeExchanges writes exchanges to a golden file as indented JSON, in request order
+func writeExchanges(path string, exchanges []Exchange) error {
+	data, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling recording: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing recording: %w", err)
+	}
+
+	return nil
+}
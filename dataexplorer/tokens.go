@@ -0,0 +1,69 @@
+package dataexplorer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenProvider supplies the Authorization header value sent with each request
+type TokenProvider interface {
+	AuthorizationHeader() (string, error)
+}
+
+// StaticToken is a TokenProvider returning a fixed Authorization header value, e.g. "Bearer <token>"
+type StaticToken string
+
+func (t StaticToken) AuthorizationHeader() (string, error) {
+	return string(t), nil
+}
+
+// AzureCLIToken is a TokenProvider acquiring tokens for Resource from the Azure CLI and caching them until shortly before they expire
+type AzureCLIToken struct {
+	Resource string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewAzureCLIToken creates a TokenProvider for the given resource using the logged in Azure CLI account
+func NewAzureCLIToken(resource string) *AzureCLIToken {
+	return &AzureCLIToken{Resource: strings.TrimSuffix(resource, "/")}
+}
+
+func (t *AzureCLIToken) AuthorizationHeader() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Now().Before(t.expires) {
+		return "Bearer " + t.token, nil
+	}
+
+	output, err := exec.Command("az", "account", "get-access-token", "--resource", t.Resource, "--output", "json").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("error getting access token from Azure CLI: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("error getting access token from Azure CLI: %w", err)
+	}
+
+	var response struct {
+		AccessToken string `json:"accessToken"`
+		ExpiresOn   int64  `json:"expires_on"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return "", fmt.Errorf("error parsing Azure CLI token: %w", err)
+	}
+
+	t.token = response.AccessToken
+	t.expires = time.Now().Add(30 * time.Minute)
+	if response.ExpiresOn > 0 {
+		t.expires = time.Unix(response.ExpiresOn, 0).Add(-5 * time.Minute)
+	}
+
+	return "Bearer " + t.token, nil
+}
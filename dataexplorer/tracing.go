@@ -0,0 +1,176 @@
+package dataexplorer
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ClientRequestIDHeader identifies a request to the service, Tracer sets it on requests without one so support can
+// find them in the logs of the service
+const ClientRequestIDHeader = "X-Ms-Client-Request-Id"
+
+// correlationHeaders are the headers tying an exchange to the logs of the service, logged by Tracer when present
+var correlationHeaders = []string{
+	ClientRequestIDHeader,
+	"X-Ms-Request-Id",
+	"X-Ms-Correlation-Request-Id",
+	"X-Ms-Activity-Id",
+	"Request-Id",
+	TraceparentHeader,
+}
+
+// Headers whose value is redacted in dumps, as they carry credentials
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+// Tracer is a RoundTripper passing requests on to Base and logging every exchange with its latency, sizes and
+// correlation headers. With DumpDir set the full exchange is also written there, credentials redacted.
+type Tracer struct {
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+	// DumpDir receives a JSON file per exchange when set, named by the time of the request so they sort in order
+	DumpDir string
+}
+
+// TracedExchange is an exchange as dumped by Tracer
+type TracedExchange struct {
+	Time      time.Time        `json:"time"`
+	LatencyMs int64            `json:"latencyMs"`
+	Request   RecordedRequest  `json:"request"`
+	Response  RecordedResponse `json:"response"`
+	// Error is why no response was received
+	Error string `json:"error,omitempty"`
+}
+
+// TraceClient installs a Tracer dumping exchanges to dumpDir, unless empty, between the Transport of client, which
+// adds the Authorization header, and the transport sending the requests
+func TraceClient(client *http.Client, dumpDir string) {
+	if transport, ok := client.Transport.(*Transport); ok {
+		transport.Base = &Tracer{Base: transport.Base, DumpDir: dumpDir}
+		return
+	}
+	client.Transport = &Tracer{Base: client.Transport, DumpDir: dumpDir}
+}
+
+func (t *Tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(ClientRequestIDHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(ClientRequestIDHeader, newRequestID())
+	}
+
+	requestBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, sendErr := base.RoundTrip(req)
+	latency := time.Since(start)
+
+	exchange := TracedExchange{
+		Time:      start.UTC(),
+		LatencyMs: latency.Milliseconds(),
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: tracedHeaders(req.Header),
+			Body:    scrubTokens(requestBody),
+		},
+	}
+	attrs := []any{"method", req.Method, "url", req.URL.String(), "latency", latency, "requestBytes", len(requestBody)}
+
+	if sendErr != nil {
+		exchange.Error = sendErr.Error()
+		attrs = append(attrs, "error", sendErr)
+	} else {
+		responseBody, err := readBody(&resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("error reading response body: %w", err)
+		}
+		exchange.Response = RecordedResponse{StatusCode: resp.StatusCode, Headers: tracedHeaders(resp.Header), Body: scrubTokens(responseBody)}
+		attrs = append(attrs, "status", resp.StatusCode, "responseBytes", len(responseBody))
+	}
+	for _, name := range correlationHeaders {
+		if value := req.Header.Get(name); value != "" {
+			attrs = append(attrs, strings.ToLower(name), value)
+		} else if resp != nil && resp.Header.Get(name) != "" {
+			attrs = append(attrs, strings.ToLower(name), resp.Header.Get(name))
+		}
+	}
+
+	if t.DumpDir != "" {
+		path, err := t.dump(exchange)
+		if err != nil {
+			slog.Warn("Failed to dump HTTP exchange", "error", err)
+		} else {
+			attrs = append(attrs, "dump", path)
+		}
+	}
+
+	if sendErr != nil {
+		slog.Error("HTTP request failed", attrs...)
+		return nil, sendErr
+	}
+	slog.Info("HTTP exchange", attrs...)
+	return resp, nil
+}
+
+// dump writes an exchange to DumpDir and returns its path
+func (t *Tracer) dump(exchange TracedExchange) (string, error) {
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshalling exchange: %w", err)
+	}
+
+	if err := os.MkdirAll(t.DumpDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %w", t.DumpDir, err)
+	}
+	// The client request id tells apart exchanges started at the same time
+	name := fmt.Sprintf("%s-%s-%.8s.json", exchange.Time.Format("20060102T150405.000"), strings.ToLower(exchange.Request.Method), exchange.Request.Headers[ClientRequestIDHeader])
+	path := filepath.Join(t.DumpDir, name)
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// tracedHeaders returns every header, with the value of credentials redacted
+func tracedHeaders(header http.Header) map[string]string {
+	traced := map[string]string{}
+	for name, values := range header {
+		name = http.CanonicalHeaderKey(name)
+		if redactedHeaders[name] {
+			traced[name] = scrubbedToken
+			continue
+		}
+		traced[name] = strings.Join(values, ", ")
+	}
+	if len(traced) == 0 {
+		return nil
+	}
+	return traced
+}
+
+// newRequestID returns a random UUID, the form of the request ids of the service
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// diffReport is the --output json form of diff
+type diffReport struct {
+	DashboardID string   `json:"dashboardId"`
+	Changed     bool     `json:"changed"`
+	Changes     []string `json:"changes"`
+	Diff        string   `json:"diff"`
+}
+
+// statusReport is the --output json form of status
+type statusReport struct {
+	DashboardID string `json:"dashboardId"`
+	Template    string `json:"template"`
+	RemoteETag  string `json:"remoteETag"`
+	LocalETag   string `json:"localETag"`
+	// RemoteChanged is set when the dashboard changed since the template was pulled
+	RemoteChanged bool `json:"remoteChanged"`
+	// Changes describe what pushing the template would change
+	Changes    []string            `json:"changes"`
+	LastBackup *workspace.Snapshot `json:"lastBackup"`
+}
+
+// DiffDashboard prints what pushing the local template to a dashboard would change, with --exit-code exiting with
+// Exit_Failure when it would change anything
+func DiffDashboard(args []string) {
+	flags := newFlagSet("diff")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	exitCode := flags.Bool("exit-code", false, "exit with 1 when pushing the template would change the dashboard")
+	parseFlags(flags, args)
+
+	dashboardID := dashboardIDArg(flags)
+	ws := openWorkspace(checkLayout(flags, *layout), newDashboardsAPI(dashboardsAccessToken()))
+	result, err := ws.Diff(dashboardID, *values)
+	if err != nil {
+		fatalf("Failed to diff dashboard: %v", err)
+	}
+
+	if jsonOutput() {
+		printJSON(diffReport{DashboardID: dashboardID, Changed: result.Text != "", Changes: nonNil(result.Changes), Diff: result.Text})
+	} else if result.Text == "" {
+		printInfo("%s is up to date with %s\n", dashboardID, layoutPath(checkLayout(flags, *layout)))
+	} else {
+		fmt.Print(result.Text)
+		for _, change := range result.Changes {
+			printInfo("  %s\n", change)
+		}
+	}
+
+	if *exitCode && result.Text != "" {
+		exit(Exit_Failure)
+	}
+}
+
+// ShowStatus prints whether a dashboard changed since its template was pulled, what pushing the template would
+// change and when the dashboard was last backed up
+func ShowStatus(args []string) {
+	flags := newFlagSet("status")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	parseFlags(flags, args)
+
+	dashboardID := dashboardIDArg(flags)
+	ws := openWorkspace(checkLayout(flags, *layout), newDashboardsAPI(dashboardsAccessToken()))
+	result, err := ws.Diff(dashboardID, *values)
+	if err != nil {
+		fatalf("Failed to compare dashboard: %v", err)
+	}
+	history, err := ws.History(dashboardID)
+	if err != nil {
+		fatalf("Failed to read backup history: %v", err)
+	}
+
+	report := statusReport{
+		DashboardID:   dashboardID,
+		Template:      layoutPath(checkLayout(flags, *layout)),
+		RemoteETag:    result.RemoteETag,
+		LocalETag:     result.LocalETag,
+		RemoteChanged: result.RemoteETag != result.LocalETag,
+		Changes:       nonNil(result.Changes),
+	}
+	if len(history) > 0 {
+		report.LastBackup = &history[0]
+	}
+
+	if jsonOutput() {
+		printJSON(report)
+		return
+	}
+
+	fmt.Printf("Dashboard:   %s\n", report.DashboardID)
+	fmt.Printf("Template:    %s\n", report.Template)
+	if report.RemoteChanged {
+		fmt.Printf("Remote:      changed since the template was pulled (eTag %s, template has %s)\n", report.RemoteETag, report.LocalETag)
+	} else {
+		fmt.Println("Remote:      unchanged since the template was pulled")
+	}
+	if len(report.Changes) == 0 {
+		fmt.Println("Local:       nothing to push")
+	} else {
+		fmt.Printf("Local:       %d changes to push\n", len(report.Changes))
+		for _, change := range report.Changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	if report.LastBackup == nil {
+		fmt.Println("Last backup: none")
+	} else {
+		fmt.Printf("Last backup: %s taken %s (%s)\n", report.LastBackup.ShortHash(), report.LastBackup.TakenAt.Local().Format(time.DateTime), report.LastBackup.Reason)
+	}
+}
+
+// nonNil returns an empty list for nil, so JSON output has [] rather than null
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// dryRunOptions configure push --dry-run
+type dryRunOptions struct {
+	// offline compares with the latest snapshot of the dashboard instead of retrieving it
+	offline bool
+	// requestOut is the file the request is saved to instead of printing it
+	requestOut string
+}
+
+// dryRunReport is the --output json form of the request push --dry-run prepares
+type dryRunReport struct {
+	Request *dataexplorer.RecordedRequest `json:"request"`
+	// RequestOut is the file the request was saved to
+	RequestOut string   `json:"requestOut,omitempty"`
+	Changed    bool     `json:"changed"`
+	Changes    []string `json:"changes"`
+	// Baseline is the snapshot compared with offline
+	Baseline *workspace.Snapshot `json:"baseline,omitempty"`
+	// Conflict is set when the service would refuse the push
+	Conflict bool `json:"conflict"`
+}
+
+// addDryRunFlags registers the flags configuring push --dry-run
+func addDryRunFlags(flags *flag.FlagSet) *dryRunOptions {
+	options := &dryRunOptions{}
+	flags.BoolVar(&options.offline, "offline", false, "compare with the snapshot taken when the dashboard was last pulled or backed up instead of retrieving it")
+	flags.StringVar(&options.requestOut, "request-out", "", "save the request as JSON to this file instead of printing it")
+	return options
+}
+
+// reportDryRun prints or saves the request a push would send and how it would change the dashboard, adding it to the
+// report, with an error when the service would refuse it
+func reportDryRun(report *pushReport, dryRun *workspace.DryRunResult, options dryRunOptions) {
+	dashboardId := report.DashboardID
+	report.DryRun = &dryRunReport{
+		Request:    dryRun.Request,
+		RequestOut: options.requestOut,
+		Changed:    dryRun.Diff.Text != "",
+		Changes:    nonNil(dryRun.Diff.Changes),
+		Baseline:   dryRun.Baseline,
+		Conflict:   dryRun.Conflict,
+	}
+
+	if options.requestOut != "" {
+		data, err := json.MarshalIndent(dryRun.Request, "", "  ")
+		if err != nil {
+			fatalf("Failed to marshal request: %v", err)
+		}
+		if err := os.WriteFile(options.requestOut, append(data, '\n'), 0644); err != nil {
+			fatalf("Failed to write request: %v", err)
+		}
+		printInfo("Request written to %s\n", options.requestOut)
+	} else if !jsonOutput() {
+		printRequest(os.Stdout, dryRun.Request)
+	}
+
+	if baseline := dryRun.Baseline; baseline != nil {
+		printInfo("Compared with snapshot %s of %s taken %s (%s)\n", baseline.ShortHash(), baseline.DashboardID, baseline.TakenAt.Local().Format(time.DateTime), baseline.Reason)
+	}
+	if dryRun.Diff.Text == "" {
+		printInfo("Pushing would not change %s\n", dashboardId)
+	} else {
+		printInfo("Pushing would change %s:\n", dashboardId)
+		for _, change := range dryRun.Diff.Changes {
+			printInfo("  %s\n", change)
+		}
+	}
+
+	if dryRun.Conflict {
+		report.Error = &errorDetail{
+			Code:    Error_Conflict,
+			Message: fmt.Sprintf("Dashboard %s has eTag %s but the template has %s, the service would refuse the push, pull it first", dashboardId, dryRun.Diff.RemoteETag, dryRun.Diff.LocalETag),
+		}
+		return
+	}
+	printInfo("Dry run, %s was not pushed\n", dashboardId)
+}
+
+// printRequest writes a request in HTTP/1.1 message form, headers sorted
+func printRequest(w io.Writer, request *dataexplorer.RecordedRequest) {
+	fmt.Fprintf(w, "%s %s HTTP/1.1\n", request.Method, request.URL)
+
+	names := make([]string, 0, len(request.Headers))
+	for name := range request.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %s\n", name, request.Headers[name])
+	}
+
+	fmt.Fprintf(w, "\n%s\n", request.Body)
+}
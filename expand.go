@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// expandReport is the --output json form of expand
+type expandReport struct {
+	Target string `json:"target"`
+	Query  string `json:"query"`
+}
+
+// ExpandQuery prints the query of a tile preceded by let statements declaring every variable it uses
+func ExpandQuery(args []string) {
+	flags := newFlagSet("expand")
+	layout := flags.String("layout", localLayout(), "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	offline := flags.Bool("offline", false, "do not run the queries of query backed parameters, binding them to an empty selection")
+	overrides := map[string]string{}
+	flags.Func("bind", "bind a variable to a KQL expression instead of its default, as variable=expression (repeatable)", func(value string) error {
+		variable, expression, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected variable=expression, got %q", value)
+		}
+		overrides[strings.TrimSpace(variable)] = strings.TrimSpace(expression)
+		return nil
+	})
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		usageError(flags, "Expected one tile id, tile title or query file")
+	}
+
+	dashboard, err := loadProcessedDashboard(*layout, values)
+	if err != nil {
+		fatalf("Failed to load dashboard: %v", err)
+	}
+
+	query, err := findTargetQuery(dashboard, flags.Arg(0))
+	if err != nil {
+		fatalf("Failed to find query: %v", err)
+	}
+
+	var clients *kustoClients
+	if !*offline {
+		clients = &kustoClients{}
+	}
+	resolver := newParameterResolver(dashboard, clients)
+	for variable, expression := range overrides {
+		resolver.Overrides[variable] = expression
+	}
+
+	text, err := resolver.Expand(query)
+	if err != nil {
+		fatalf("Failed to bind query variables: %v", err)
+	}
+
+	if jsonOutput() {
+		printJSON(expandReport{Target: flags.Arg(0), Query: text})
+		return
+	}
+	fmt.Println(text)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// formatReport is the --output json form of fmt
+type formatReport struct {
+	Template string `json:"template"`
+	// Formatted is set when the template was rewritten, unset when it was already formatted
+	Formatted bool `json:"formatted"`
+}
+
+// FormatDashboard rewrites the dashboard template in place in canonical form
+func FormatDashboard(args []string) {
+	flags := newFlagSet("fmt")
+	parseFlags(flags, args)
+
+	templatePath := Dashboard_Template_Path
+	if flags.NArg() > 0 {
+		templatePath = flags.Arg(0)
+	}
+
+	original, err := os.ReadFile(templatePath)
+	if err != nil {
+		fatalf("Failed to read template file: %v", err)
+	}
+
+	formatted, err := utils.FormatTemplate(original)
+	if err != nil {
+		fatalf("Failed to format %s: %v", templatePath, err)
+	}
+
+	if bytes.Equal(original, formatted) {
+		printInfo("%s is already formatted\n", templatePath)
+		if jsonOutput() {
+			printJSON(formatReport{Template: templatePath})
+		}
+		return
+	}
+
+	if err := os.WriteFile(templatePath, formatted, 0644); err != nil {
+		fatalf("Failed to write formatted template: %v", err)
+	}
+
+	printInfo("Formatted %s\n", templatePath)
+	if jsonOutput() {
+		printJSON(formatReport{Template: templatePath, Formatted: true})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+const Dashboard_Spec_Path = "dashboard.spec.yml"
+
+// generateReport is the --output json form of generate
+type generateReport struct {
+	Spec       string `json:"spec"`
+	Template   string `json:"template"`
+	Pages      int    `json:"pages"`
+	Tiles      int    `json:"tiles"`
+	Parameters int    `json:"parameters"`
+	// Content is the template generated with --out -, which prints it in the report
+	Content string `json:"content,omitempty"`
+}
+
+// GenerateFromSpec expands a dashboard spec into a dashboard template that can be pushed
+func GenerateFromSpec(args []string) {
+	flags := newFlagSet("generate")
+	outputPath := flags.String("out", Dashboard_Template_Path, "path the dashboard template is written to, - for stdout")
+	force := flags.Bool("force", false, "overwrite an existing template")
+	dashboardId := flags.String("id", localConfig().DashboardID, "id of the dashboard the template is pushed to")
+	parseFlags(flags, args)
+
+	specPath := Dashboard_Spec_Path
+	if flags.NArg() > 0 {
+		specPath = flags.Arg(0)
+	}
+
+	spec, err := utils.LoadDashboardSpec(utils.DirFS("."), specPath)
+	if err != nil {
+		fatalf("Failed to load spec: %v", err)
+	}
+
+	generated, err := utils.GenerateDashboard(utils.DirFS("."), spec, filepath.Dir(specPath), snippetPath())
+	if err != nil {
+		fatalf("Failed to generate dashboard from %s: %v", specPath, err)
+	}
+	generated.Dashboard.Id = *dashboardId
+
+	template, err := generated.Template()
+	if err != nil {
+		fatalf("Failed to render dashboard template: %v", err)
+	}
+
+	dashboard := generated.Dashboard
+	report := generateReport{Spec: specPath, Template: *outputPath, Pages: len(dashboard.Pages), Tiles: len(dashboard.Tiles), Parameters: len(dashboard.Parameters)}
+	if *outputPath == "-" {
+		if jsonOutput() {
+			report.Content = string(template)
+			printJSON(report)
+		} else {
+			os.Stdout.Write(template)
+		}
+		return
+	}
+
+	if _, err := os.Stat(*outputPath); err == nil && !*force {
+		fatalf("%s already exists, use --force to overwrite it", *outputPath)
+	}
+	if err := os.WriteFile(*outputPath, template, 0644); err != nil {
+		fatalf("Failed to write dashboard template: %v", err)
+	}
+
+	printInfo("Generated %s from %s: %d pages, %d tiles, %d parameters\n", *outputPath, specPath, report.Pages, report.Tiles, report.Parameters)
+	if jsonOutput() {
+		printJSON(report)
+	}
+}
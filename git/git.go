@@ -0,0 +1,241 @@
+// Package git runs the local git operations the command line tool commits pulled dashboards with. It shells out to
+// the git executable and never talks to a remote.
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// ErrNotRepository is returned when the directory is not inside a git work tree
+var ErrNotRepository = errors.New("not a git repository")
+
+// ErrNoCommits is returned when reading the files of HEAD in a repository without commits yet
+var ErrNoCommits = errors.New("no commits yet")
+
+// Repo is the git work tree a directory is in, commands run in the directory so paths are relative to it
+type Repo struct {
+	Dir string
+	// prefix is the path of Dir within the work tree
+	prefix string
+}
+
+// Open returns the repository the directory dir is in
+func Open(dir string) (*Repo, error) {
+	repo := &Repo{Dir: dir}
+	prefix, err := repo.run(nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, dir)
+	}
+	repo.prefix = strings.TrimSpace(prefix)
+	return repo, nil
+}
+
+// run runs git with the given arguments and input, returning its output or an error with what it printed to stderr
+func (r *Repo) run(input io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	cmd.Stdin = input
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// CurrentBranch returns the branch checked out, empty when HEAD is detached
+func (r *Repo) CurrentBranch() (string, error) {
+	output, err := r.run(nil, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if err != nil {
+		if _, detached := r.run(nil, "rev-parse", "--verify", "--quiet", "HEAD"); detached == nil {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// HasCommits reports whether HEAD points to a commit, which it does not in a repository just initialized
+func (r *Repo) HasCommits() bool {
+	_, err := r.run(nil, "rev-parse", "--verify", "--quiet", "HEAD")
+	return err == nil
+}
+
+// Changed returns the files under paths that differ from HEAD or are untracked, all files when no path is given
+func (r *Repo) Changed(paths ...string) ([]string, error) {
+	return r.status("--untracked-files=all", paths)
+}
+
+// Modified returns the tracked files that differ from HEAD, staged or not
+func (r *Repo) Modified() ([]string, error) {
+	return r.status("--untracked-files=no", nil)
+}
+
+// Untracked returns the files under paths git does not track, leaving out ignored files, all files when no path is given
+func (r *Repo) Untracked(paths ...string) ([]string, error) {
+	output, err := r.run(nil, append([]string{"ls-files", "--others", "--exclude-standard", "-z", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// ls-files names files relative to Dir already
+	var files []string
+	for _, name := range strings.Split(output, "\x00") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+func (r *Repo) status(untracked string, paths []string) ([]string, error) {
+	output, err := r.run(nil, append([]string{"status", "--porcelain", "-z", untracked, "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	entries := strings.Split(output, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		// Porcelain output names files relative to the top of the work tree
+		name, err := filepath.Rel(filepath.FromSlash(r.prefix), filepath.FromSlash(entry[3:]))
+		if err != nil {
+			return nil, fmt.Errorf("git status: %v", err)
+		}
+		files = append(files, filepath.ToSlash(name))
+		// Renames and copies are followed by the name they were renamed from
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return files, nil
+}
+
+// BranchExists reports whether a local branch of the given name exists
+func (r *Repo) BranchExists(branch string) bool {
+	_, err := r.run(nil, "show-ref", "--verify", "--quiet", "refs/heads/"+branch)
+	return err == nil
+}
+
+// Checkout switches to a branch, creating it at HEAD when create is set
+func (r *Repo) Checkout(branch string, create bool) error {
+	args := []string{"checkout", "--quiet"}
+	if create {
+		args = append(args, "-b")
+	}
+	_, err := r.run(nil, append(args, branch)...)
+	return err
+}
+
+// Commit records the current content of the files under paths, including files deleted, in a commit with the given
+// message, leaving anything else staged out of it. It returns the hash of the commit.
+func (r *Repo) Commit(message string, paths ...string) (string, error) {
+	if _, err := r.run(nil, append([]string{"add", "--all", "--"}, paths...)...); err != nil {
+		return "", err
+	}
+	if _, err := r.run(strings.NewReader(message), append([]string{"commit", "--quiet", "--file", "-", "--"}, paths...)...); err != nil {
+		return "", err
+	}
+	output, err := r.run(nil, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// Files returns the files of the directory as of the given revision, in memory, leaving out the files under the
+// directories skipped
+func (r *Repo) Files(revision string, skip ...string) (*utils.MemFS, error) {
+	if !r.HasCommits() {
+		return nil, ErrNoCommits
+	}
+
+	// Without --full-name the tree is listed relative to the directory, like the names of the workspace
+	output, err := r.run(nil, "ls-tree", "-r", "-z", revision)
+	if err != nil {
+		return nil, err
+	}
+
+	var names, objects []string
+	for _, entry := range strings.Split(output, "\x00") {
+		// <mode> SP <type> SP <object> TAB <name>
+		meta, name, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" || skipped(name, skip) {
+			continue
+		}
+		names = append(names, name)
+		objects = append(objects, fields[2])
+	}
+
+	files := utils.NewMemFS(nil)
+	if len(objects) == 0 {
+		return files, nil
+	}
+	contents, err := r.catFiles(objects)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		if err := files.WriteFile(name, contents[i], 0644); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// catFiles reads the content of the given objects with a single git process
+func (r *Repo) catFiles(objects []string) ([][]byte, error) {
+	output, err := r.run(strings.NewReader(strings.Join(objects, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(strings.NewReader(output))
+	contents := make([][]byte, 0, len(objects))
+	for range objects {
+		// <object> SP <type> SP <size> LF <content> LF
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("git cat-file: %v", err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("git cat-file: unexpected output %q", strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("git cat-file: unexpected size %q", fields[2])
+		}
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			return nil, fmt.Errorf("git cat-file: %v", err)
+		}
+		contents = append(contents, content[:size])
+	}
+	return contents, nil
+}
+
+func skipped(name string, skip []string) bool {
+	for _, dir := range skip {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
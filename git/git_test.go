@@ -0,0 +1,61 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChangedAndUntracked(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := &Repo{Dir: dir}
+	for _, args := range [][]string{{"init", "--quiet"}, {"config", "user.email", "sync@example.com"}, {"config", "user.name", "sync"}} {
+		if _, err := root.run(nil, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "bin/\n")
+	write("dashboard.yml", "title: A\n")
+	write("queries/a.kql", "T\n")
+	if _, err := root.Commit("Initial", "."); err != nil {
+		t.Fatal(err)
+	}
+
+	write("dashboard.yml", "title: B\n")
+	write("queries/b.kql", "U\n")
+	write("values.prod.yml", "x: 1\n")
+	write("bin/out.json", "{}\n")
+	write("notes.txt", "todo\n")
+
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified, err := repo.Modified()
+	if err != nil || !reflect.DeepEqual(modified, []string{"dashboard.yml"}) {
+		t.Errorf("Modified = %v, %v, want [dashboard.yml]", modified, err)
+	}
+	untracked, err := repo.Untracked("queries", "values.*.yml", "bin")
+	if err != nil || !reflect.DeepEqual(untracked, []string{"queries/b.kql", "values.prod.yml"}) {
+		t.Errorf("Untracked = %v, %v, want [queries/b.kql values.prod.yml]", untracked, err)
+	}
+	changed, err := repo.Changed("queries")
+	if err != nil || !reflect.DeepEqual(changed, []string{"queries/b.kql"}) {
+		t.Errorf("Changed(queries) = %v, %v, want [queries/b.kql]", changed, err)
+	}
+}
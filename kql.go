@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/kql"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// formatQueriesReport is the --output json form of kql fmt
+type formatQueriesReport struct {
+	Files int `json:"files"`
+	// Formatted are the files rewritten
+	Formatted []string `json:"formatted"`
+	// Unformatted are the files not formatted found with --check
+	Unformatted []string `json:"unformatted"`
+}
+
+// lintQueriesReport is the --output json form of kql lint
+type lintQueriesReport struct {
+	Files       int              `json:"files"`
+	Errors      int              `json:"errors"`
+	Warnings    int              `json:"warnings"`
+	Diagnostics []fileDiagnostic `json:"diagnostics"`
+}
+
+type fileDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule,omitempty"`
+	Message  string `json:"message"`
+}
+
+// FormatQueries pretty-prints the query files in place, or lists the files that are not formatted with --check
+func FormatQueries(args []string) {
+	flags := newFlagSet("kql fmt")
+	layout := flags.String("layout", localLayout(), "on-disk layout of the dashboard template: single or split")
+	check := flags.Bool("check", false, "list files that are not formatted and exit with an error instead of rewriting them")
+	parseFlags(flags, args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = defaultQueryPaths(*layout)
+		// Shared snippets are formatted along with the queries including them
+		for _, dir := range snippetPath() {
+			if _, err := os.Stat(dir); err == nil {
+				paths = append(paths, dir)
+			}
+		}
+	}
+
+	files, err := queryFiles(paths)
+	if err != nil {
+		fatalf("Failed to list query files: %v", err)
+	}
+
+	report := formatQueriesReport{Files: len(files), Formatted: []string{}, Unformatted: []string{}}
+	for _, file := range files {
+		original, err := os.ReadFile(file)
+		if err != nil {
+			fatalf("Failed to read query file: %v", err)
+		}
+
+		formatted, err := kql.Format(string(original))
+		if err != nil {
+			fatalf("Failed to format %s: %v", file, err)
+		}
+		if formatted == string(original) {
+			continue
+		}
+
+		if *check {
+			report.Unformatted = append(report.Unformatted, file)
+			if !jsonOutput() {
+				fmt.Println(file)
+			}
+			continue
+		}
+		if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+			fatalf("Failed to write formatted query: %v", err)
+		}
+		report.Formatted = append(report.Formatted, file)
+		printInfo("Formatted %s\n", file)
+	}
+
+	if jsonOutput() {
+		printJSON(report)
+	}
+	if len(report.Unformatted) > 0 {
+		if !jsonOutput() {
+			fmt.Printf("%d of %d query files are not formatted\n", len(report.Unformatted), len(files))
+		}
+		exit(Exit_Failure)
+	}
+}
+
+// LintQueries lints the query files, with their snippets included, against the parameters of the local dashboard and
+// exits with an error on lint errors
+func LintQueries(args []string) {
+	flags := newFlagSet("kql lint")
+	layout := flags.String("layout", localLayout(), "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	parseFlags(flags, args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = defaultQueryPaths(*layout)
+	}
+
+	files, err := queryFiles(paths)
+	if err != nil {
+		fatalf("Failed to list query files: %v", err)
+	}
+
+	largeTables := localConfig().LargeTables
+	dashboard, err := loadProcessedDashboard(*layout, values)
+	if err != nil {
+		slog.Warn("Failed to load dashboard, not checking parameters", "error", err)
+	}
+
+	report := lintQueriesReport{Files: len(files), Diagnostics: []fileDiagnostic{}}
+	for _, file := range files {
+		text, sources, err := utils.ExpandSnippets(utils.DirFS("."), file, snippetPath())
+		if err != nil {
+			report.add(fileDiagnostic{File: file, Severity: kql.SeverityError.String(), Message: err.Error()})
+			continue
+		}
+
+		options := kql.LintOptions{LargeTables: largeTables}
+		if dashboard != nil {
+			options = utils.QueryLintOptions(dashboard, utils.QueryForText(dashboard, text), largeTables)
+		}
+
+		diagnostics, err := kql.Lint(text, options)
+		if err != nil {
+			report.add(fileDiagnostic{File: file, Severity: kql.SeverityError.String(), Message: err.Error()})
+			continue
+		}
+		for _, diagnostic := range diagnostics {
+			source := utils.SourceLine{File: file, Line: diagnostic.Line}
+			if diagnostic.Line-1 < len(sources) {
+				source = sources[diagnostic.Line-1]
+			}
+			report.add(fileDiagnostic{
+				File:     source.File,
+				Line:     source.Line,
+				Severity: diagnostic.Severity.String(),
+				Rule:     diagnostic.Rule,
+				Message:  diagnostic.Message,
+			})
+		}
+	}
+
+	if jsonOutput() {
+		printJSON(report)
+	} else {
+		fmt.Printf("%d errors, %d warnings in %d query files\n", report.Errors, report.Warnings, len(files))
+	}
+	if report.Errors > 0 {
+		exit(Exit_Failure)
+	}
+}
+
+// add records a diagnostic, printing it unless the report is printed as JSON
+func (r *lintQueriesReport) add(diagnostic fileDiagnostic) {
+	r.Diagnostics = append(r.Diagnostics, diagnostic)
+	if diagnostic.Severity == kql.SeverityError.String() {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+	if jsonOutput() {
+		return
+	}
+	if diagnostic.Line == 0 {
+		fmt.Printf("%s: %s: %s\n", diagnostic.File, diagnostic.Severity, diagnostic.Message)
+	} else {
+		fmt.Printf("%s:%d: %s: %s (%s)\n", diagnostic.File, diagnostic.Line, diagnostic.Severity, diagnostic.Message, diagnostic.Rule)
+	}
+}
+
+// printLintDiagnostics prints the lint findings of a dashboard, logged with --output json, and returns the number of
+// errors
+func printLintDiagnostics(diagnostics []utils.QueryDiagnostic) int {
+	errors := 0
+	for _, diagnostic := range diagnostics {
+		level, label := slog.LevelWarn, "Lint warning"
+		if diagnostic.Severity == kql.SeverityError {
+			errors++
+			level, label = slog.LevelError, "Lint error"
+		}
+		if jsonOutput() {
+			slog.Log(context.Background(), level, label, "query", diagnostic.Query, "line", diagnostic.Line, "rule", diagnostic.Rule, "message", diagnostic.Message)
+		} else {
+			fmt.Printf("%s: %v\n", label, diagnostic)
+		}
+	}
+	return errors
+}
+
+// printValidationProblems prints the broken references found in a dashboard, logged with --output json
+func printValidationProblems(problems []error) {
+	for _, problem := range problems {
+		if jsonOutput() {
+			slog.Error("Validation error", "error", problem)
+		} else {
+			fmt.Printf("Validation error: %v\n", problem)
+		}
+	}
+}
+
+// defaultQueryPaths returns the directory holding the query files of the layout
+func defaultQueryPaths(layout string) []string {
+	if layout == Layout_Split {
+		return []string{Dashboard_Split_Path}
+	}
+	return []string{utils.QueriesDir}
+}
+
+// queryFiles lists the .kql files under the given paths
+func queryFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(path), ".kql") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,296 @@
+package kql
+
+import (
+	"fmt"
+	"strings"
+)
+
+const indentWidth = 4
+
+// tabularOperators are the operator keywords that may follow a pipe
+var tabularOperators = map[string]bool{
+	"as": true, "consume": true, "count": true, "distinct": true, "evaluate": true, "extend": true, "facet": true,
+	"filter": true, "find": true, "fork": true, "getschema": true, "invoke": true, "join": true, "limit": true,
+	"lookup": true, "make-series": true, "mv-apply": true, "mv-expand": true, "order": true, "parse": true,
+	"parse-kv": true, "parse-where": true, "partition": true, "project": true, "project-away": true,
+	"project-keep": true, "project-rename": true, "project-reorder": true, "range": true, "reduce": true,
+	"render": true, "sample": true, "sample-distinct": true, "scan": true, "search": true, "serialize": true,
+	"sort": true, "summarize": true, "take": true, "top": true, "top-hitters": true, "top-nested": true,
+	"union": true, "where": true,
+}
+
+// keywords are written in lower case where they are used as keywords, elsewhere they may name a column
+var keywords = map[string]bool{"and": true, "or": true, "by": true, "asc": true, "desc": true}
+
+// byOperators are the operators the by keyword may directly follow, as in order by
+var byOperators = map[string]bool{"order": true, "sort": true, "partition": true, "summarize": true}
+
+// formatFrame is an open bracket while formatting
+type formatFrame struct {
+	block bool // the brackets hold a subquery and their content starts on a line of its own
+}
+
+// Format pretty-prints a query: one pipe per line, indentation by nesting depth, normalized spacing and lower case keywords.
+// Line breaks within a pipe stage are kept as continuation lines.
+func Format(text string) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		return text, nil
+	}
+
+	tokens, err := Tokenize(text)
+	if err != nil {
+		return "", err
+	}
+
+	blocks := subqueryBlocks(tokens)
+	// recased are the tokens written in lower case, the only ones whose case may change
+	recased := map[int]bool{}
+	var sb strings.Builder
+	var stack []formatFrame
+	statementStart := true
+
+	newline := func(indent int, blank bool) {
+		if blank {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n" + strings.Repeat(" ", indent))
+	}
+
+	for i, tok := range tokens {
+		depth := len(stack)
+		var prev Token
+		if i > 0 {
+			prev = tokens[i-1]
+		}
+
+		switch {
+		case i == 0:
+		case tok.Kind == Pipe:
+			newline(depth*indentWidth, false)
+		case isClosing(tok) && depth > 0 && stack[depth-1].block:
+			newline((depth-1)*indentWidth, false)
+		case isOpening(prev) && stack[depth-1].block:
+			newline(depth*indentWidth, false)
+		case prev.Kind == Comment || prev.Kind == Directive || tok.Kind == Directive || tok.Newlines > 0 || (prev.Text == ";" && depth == 0):
+			indent := depth * indentWidth
+			if !statementStart {
+				indent += indentWidth
+			}
+			newline(indent, depth == 0 && tok.Newlines > 1)
+		default:
+			if needsSpace(tokens, i) {
+				sb.WriteString(" ")
+			}
+		}
+
+		formattedText := formatText(tokens, i, statementStart)
+		recased[i] = formattedText != tok.Text
+		sb.WriteString(formattedText)
+
+		switch {
+		case isOpening(tok):
+			stack = append(stack, formatFrame{block: blocks[i]})
+			statementStart = true
+		case isClosing(tok):
+			if depth > 0 {
+				stack = stack[:depth-1]
+			}
+			statementStart = false
+		case tok.Text == ";":
+			statementStart = true
+		case tok.Kind != Comment && tok.Kind != Directive:
+			statementStart = false
+		}
+	}
+
+	formatted := sb.String()
+	if strings.HasSuffix(strings.TrimRight(text, " \t"), "\n") {
+		formatted += "\n"
+	}
+
+	// Formatting only changes whitespace and keyword case, anything else is a bug
+	if err := sameTokens(tokens, recased, formatted); err != nil {
+		return "", err
+	}
+
+	return formatted, nil
+}
+
+// subqueryBlocks finds the opening brackets whose content is laid out as a block: brackets holding a pipe or
+// statements, and braces spanning several lines
+func subqueryBlocks(tokens []Token) map[int]bool {
+	blocks := map[int]bool{}
+	var open []int
+	for i, tok := range tokens {
+		switch {
+		case isOpening(tok):
+			open = append(open, i)
+		case isClosing(tok) && len(open) > 0:
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+			if blocks[start] && len(open) > 0 {
+				blocks[open[len(open)-1]] = true
+			}
+		case len(open) > 0:
+			start := open[len(open)-1]
+			if tok.Kind == Pipe || tok.Text == ";" || (tokens[start].Text == "{" && (tok.Newlines > 0 || tok.Kind == Comment)) {
+				blocks[start] = true
+			}
+		}
+	}
+	return blocks
+}
+
+// formatText returns the text of the token at i, with keywords in lower case
+func formatText(tokens []Token, i int, statementStart bool) string {
+	tok := tokens[i]
+	if tok.Kind != Identifier {
+		return tok.Text
+	}
+
+	lower := strings.ToLower(tok.Text)
+	switch {
+	case isStageOperator(tokens, i):
+		return lower
+	case statementStart && lower == "let":
+		return lower
+	case keywords[lower] && isKeyword(tokens, i):
+		return lower
+	}
+	return tok.Text
+}
+
+// isKeyword reports whether the word at i, one of keywords, is used as a keyword rather than naming a column, as Desc
+// does in order by Desc asc
+func isKeyword(tokens []Token, i int) bool {
+	prev, next := codeNeighbor(tokens, i, -1), codeNeighbor(tokens, i, 1)
+	if prev < 0 {
+		return false
+	}
+	afterOperand := endsOperand(tokens, prev)
+
+	switch strings.ToLower(tokens[i].Text) {
+	case "asc", "desc":
+		return afterOperand && (next < 0 || tokens[next].Kind == Pipe || strings.Contains(",);", tokens[next].Text) || strings.EqualFold(tokens[next].Text, "nulls"))
+	case "by":
+		afterOperator := tokens[prev].Kind == Identifier && isStageOperator(tokens, prev) && byOperators[strings.ToLower(tokens[prev].Text)]
+		if !afterOperand && !afterOperator {
+			return false
+		}
+	default:
+		if !afterOperand {
+			return false
+		}
+	}
+	return next >= 0 && startsOperand(tokens[next])
+}
+
+// codeNeighbor returns the index of the token before (step -1) or after (step 1) the token at i, skipping comments,
+// or -1 when there is none
+func codeNeighbor(tokens []Token, i int, step int) int {
+	for j := i + step; j >= 0 && j < len(tokens); j += step {
+		if tokens[j].Kind != Comment {
+			return j
+		}
+	}
+	return -1
+}
+
+// endsOperand reports whether the token at i can end an operand, such as a column, a literal or a call
+func endsOperand(tokens []Token, i int) bool {
+	tok := tokens[i]
+	switch tok.Kind {
+	case Number, String:
+		return true
+	case Punctuation:
+		return tok.Text == ")" || tok.Text == "]"
+	case Identifier:
+		lower := strings.ToLower(tok.Text)
+		return !isStageOperator(tokens, i) && lower != "let" && !(keywords[lower] && isKeyword(tokens, i))
+	}
+	return false
+}
+
+// startsOperand reports whether a token can start an operand
+func startsOperand(tok Token) bool {
+	switch tok.Kind {
+	case Identifier, Number, String:
+		return true
+	case Punctuation:
+		return tok.Text == "(" || tok.Text == "["
+	case Operator:
+		return tok.Text == "-" || tok.Text == "+" || tok.Text == "!"
+	}
+	return false
+}
+
+// isStageOperator reports whether the token at i is the operator of a pipe stage, such as where in | where
+func isStageOperator(tokens []Token, i int) bool {
+	return i > 0 && tokens[i-1].Kind == Pipe && tabularOperators[strings.ToLower(tokens[i].Text)]
+}
+
+// needsSpace reports whether a space separates the token at i from the previous one on the same line
+func needsSpace(tokens []Token, i int) bool {
+	prev, tok := tokens[i-1], tokens[i]
+
+	switch {
+	case prev.Kind == Punctuation && strings.Contains("([{.", prev.Text):
+		return false
+	case tok.Kind == Punctuation && strings.Contains(")]},;.:", tok.Text):
+		return false
+	case prev.Text == ":" || tok.Text == "[":
+		return tok.Space
+	case tok.Text == "(":
+		// Function calls keep the parenthesis next to the function name
+		return tok.Space || (prev.Kind != Identifier && prev.Text != ")" && prev.Text != "]")
+	case isSpacingSensitive(prev) || isSpacingSensitive(tok):
+		return tok.Space
+	case prev.Kind == Operator && isUnary(tokens, i-1):
+		return tok.Space
+	}
+	return true
+}
+
+// isSpacingSensitive reports whether spacing around the operator is left as written, e.g. kind=inner or Table*
+func isSpacingSensitive(tok Token) bool {
+	return tok.Kind == Operator && (tok.Text == "=" || tok.Text == "*" || tok.Text == "..")
+}
+
+// isUnary reports whether the operator at i is a sign rather than a binary operator
+func isUnary(tokens []Token, i int) bool {
+	if tokens[i].Text != "-" && tokens[i].Text != "+" {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	prev := tokens[i-1]
+	return prev.Kind == Operator || prev.Kind == Pipe || (prev.Kind == Punctuation && !isClosing(prev))
+}
+
+func isOpening(tok Token) bool {
+	return tok.Kind == Punctuation && (tok.Text == "(" || tok.Text == "[" || tok.Text == "{")
+}
+
+func isClosing(tok Token) bool {
+	return tok.Kind == Punctuation && (tok.Text == ")" || tok.Text == "]" || tok.Text == "}")
+}
+
+// sameTokens checks that the formatted query has the tokens of the original query, the same but for the case of the
+// recased tokens
+func sameTokens(original []Token, recased map[int]bool, formatted string) error {
+	tokens, err := Tokenize(formatted)
+	if err != nil {
+		return fmt.Errorf("error re-reading formatted query: %w", err)
+	}
+	if len(tokens) != len(original) {
+		return fmt.Errorf("formatting changed the query")
+	}
+	for i := range tokens {
+		same := tokens[i].Text == original[i].Text || recased[i] && strings.EqualFold(tokens[i].Text, original[i].Text)
+		if !same {
+			return fmt.Errorf("formatting changed the query at line %d: %q became %q", original[i].Line, original[i].Text, tokens[i].Text)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package kql
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "one pipe per line",
+			query: "StormEvents | where State == 'TEXAS' | take 10",
+			want:  "StormEvents\n| where State == 'TEXAS'\n| take 10",
+		},
+		{
+			name:  "operators and keywords in lower case",
+			query: "T | WHERE a > 1 AND b < 2 | ORDER BY Count DESC",
+			want:  "T\n| where a > 1 and b < 2\n| order by Count desc",
+		},
+		{
+			name:  "columns named like keywords keep their case",
+			query: "T | summarize count() by Desc | order by Desc asc",
+			want:  "T\n| summarize count() by Desc\n| order by Desc asc",
+		},
+		{
+			name:  "columns named By and Or",
+			query: "T | project By, Or = x",
+			want:  "T\n| project By, Or = x",
+		},
+		{
+			name:  "mixed-case identifiers",
+			query: "StormEvents | extend DamageTotal=DamageProperty+DamageCrops | Project StartTime, DamageTotal",
+			want:  "StormEvents\n| extend DamageTotal=DamageProperty + DamageCrops\n| project StartTime, DamageTotal",
+		},
+		{
+			name:  "keywords in strings and comments",
+			query: "T | where Name == 'WHERE x OR y' // ORDER BY Desc\n| take 1",
+			want:  "T\n| where Name == 'WHERE x OR y' // ORDER BY Desc\n| take 1",
+		},
+		{
+			name:  "underscore columns",
+			query: "T | where _ResourceId has _Resource | project _ResourceId",
+			want:  "T\n| where _ResourceId has _Resource\n| project _ResourceId",
+		},
+		{
+			name:  "subqueries are indented",
+			query: "T | join kind=inner (U | where x == 1) on Id",
+			want:  "T\n| join kind=inner (\n    U\n    | where x == 1\n) on Id",
+		},
+		{
+			name:  "let statements",
+			query: "LET x = 1;\nT | where a == x",
+			want:  "let x = 1;\nT\n| where a == x",
+		},
+		{
+			name:  "blank",
+			query: "  \n",
+			want:  "  \n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.query)
+			if err != nil {
+				t.Fatalf("Format returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Format(%q) =\n%s\nwant\n%s", tt.query, got, tt.want)
+			}
+
+			again, err := Format(got)
+			if err != nil {
+				t.Fatalf("Format of formatted query returned error: %v", err)
+			}
+			if again != got {
+				t.Errorf("Format is not idempotent:\n%s\nbecame\n%s", got, again)
+			}
+		})
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	queries := []string{
+		"T | top 10 by Count Desc nulls last",
+		"T | where By and Or",
+		"T\n// where x\n| summarize by bin(TimeGenerated, 1h), Asc\n| sort by Asc",
+		"let f = (_x:int) { T | where a == _x };\nf(1)",
+		"T | where A==1 AND B | extend Or = By or Desc",
+	}
+	for _, query := range queries {
+		once, err := Format(query)
+		if err != nil {
+			t.Fatalf("Format(%q) returned error: %v", query, err)
+		}
+		twice, err := Format(once)
+		if err != nil {
+			t.Fatalf("Format(%q) returned error: %v", once, err)
+		}
+		if twice != once {
+			t.Errorf("Format is not idempotent for %q:\n%s\nbecame\n%s", query, once, twice)
+		}
+	}
+}
+
+func TestSameTokens(t *testing.T) {
+	original, err := Tokenize("T | where Desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sameTokens(original, map[int]bool{}, "T | where desc"); err == nil {
+		t.Error("sameTokens accepted a column whose case changed")
+	}
+	if err := sameTokens(original, map[int]bool{3: true}, "T | where desc"); err != nil {
+		t.Errorf("sameTokens refused a recased token: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package kql
+
+import (
+	"strings"
+)
+
+// LetStatement is a let statement leading a query
+type LetStatement struct {
+	Name       string
+	Expression string
+	Tabular    bool // the expression is a pipeline rather than a scalar or a function
+}
+
+// LetBlock is a query split into the let statements it starts with and the rest
+type LetBlock struct {
+	Statements []LetStatement
+	Text       string // the let statements, with the comments among and before them
+	Separator  string // whitespace between the let statements and the rest
+	Rest       string
+}
+
+// LeadingLets splits a query into the let statements it starts with and the rest of the query
+func LeadingLets(text string) (LetBlock, error) {
+	tokens, err := Tokenize(text)
+	if err != nil {
+		return LetBlock{}, err
+	}
+
+	runes := []rune(text)
+	depths := tokenDepths(tokens)
+	block := LetBlock{Rest: text}
+	blockEnd := 0
+
+	for i := 0; i < len(tokens); {
+		if tokens[i].Kind == Comment {
+			i++
+			continue
+		}
+		if !strings.EqualFold(tokens[i].Text, "let") || i+3 >= len(tokens) || tokens[i+1].Kind != Identifier || tokens[i+2].Text != "=" {
+			break
+		}
+
+		end := i + 3
+		for end < len(tokens) && !(depths[end] == 0 && tokens[end].Text == ";") {
+			end++
+		}
+		if end == len(tokens) {
+			break
+		}
+
+		statement := LetStatement{
+			Name:       tokens[i+1].Text,
+			Expression: strings.TrimSpace(string(runes[tokens[i+3].Offset:tokens[end].Offset])),
+		}
+		for j := i + 3; j < end; j++ {
+			if tokens[j].Kind == Pipe && depths[j] == 0 {
+				statement.Tabular = tokens[i+3].Text != "("
+			}
+		}
+		block.Statements = append(block.Statements, statement)
+		blockEnd = tokens[end].Offset + 1
+		i = end + 1
+	}
+
+	if len(block.Statements) == 0 {
+		return block, nil
+	}
+
+	rest := strings.TrimLeft(string(runes[blockEnd:]), " \t\r\n")
+	block.Text = string(runes[:blockEnd])
+	block.Separator = string(runes[blockEnd : len(runes)-len([]rune(rest))])
+	block.Rest = rest
+	return block, nil
+}
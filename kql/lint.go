@@ -0,0 +1,337 @@
+package kql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severity tells whether a lint finding blocks pushing the dashboard
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Lint rules
+const (
+	RuleUnusedLet           = "unused-let"
+	RuleTimeFilter          = "time-filter"
+	RuleStarProjection      = "star-projection"
+	RuleUndeclaredParameter = "undeclared-parameter"
+)
+
+// Diagnostic is a finding of the linter
+type Diagnostic struct {
+	Line     int
+	Severity Severity
+	Rule     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s: %s (%s)", d.Line, d.Severity, d.Message, d.Rule)
+}
+
+// ParameterScope lists the dashboard variables visible to a query
+type ParameterScope struct {
+	Declared []string // variables declared by the dashboard parameters and base queries
+	Used     []string // variables the query lists in usedVariables
+}
+
+// LintOptions configures the lint rules
+type LintOptions struct {
+	// LargeTables are tables that must be filtered by time
+	LargeTables []string
+	// TimeVariables are the variables bound to the dashboard time range
+	TimeVariables []string
+	// Parameters enables the parameter checks when set
+	Parameters *ParameterScope
+}
+
+// parameterNameRegex matches the naming convention of dashboard variables
+var parameterNameRegex = regexp.MustCompile(`^_[A-Za-z]\w*$`)
+
+// standardColumns are columns of Log Analytics and Application Insights tables following the naming convention of
+// dashboard variables
+var standardColumns = map[string]bool{
+	"_ResourceId": true, "_SubscriptionId": true, "_ItemId": true, "_IsBillable": true, "_BilledSize": true,
+	"_TimeReceived": true, "_Internal_WorkspaceResourceId": true,
+}
+
+// timeFilterHints are functions and operators that restrict a query to a time range
+var timeFilterHints = map[string]bool{
+	"ago": true, "now": true, "between": true, "datetime": true, "startofday": true, "startofweek": true,
+	"startofmonth": true, "startofyear": true, "endofday": true,
+}
+
+// starOperators are the operators for which * selects every column
+var starOperators = map[string]bool{"project": true, "project-keep": true, "distinct": true}
+
+// letBinding is a let statement of a query
+type letBinding struct {
+	name  string
+	index int // index of the name token
+	end   int // index of the token ending the statement
+}
+
+// Lint checks a query for common mistakes and returns the findings ordered by line
+func Lint(text string, options LintOptions) ([]Diagnostic, error) {
+	all, err := Tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []Token
+	for _, tok := range all {
+		if tok.Kind != Comment && tok.Kind != Directive {
+			tokens = append(tokens, tok)
+		}
+	}
+
+	depths := tokenDepths(tokens)
+	lets := letBindings(tokens, depths)
+
+	var diagnostics []Diagnostic
+	diagnostics = append(diagnostics, lintUnusedLets(tokens, lets)...)
+	diagnostics = append(diagnostics, lintStarProjections(tokens)...)
+	diagnostics = append(diagnostics, lintTimeFilters(tokens, depths, lets, options)...)
+	if options.Parameters != nil {
+		diagnostics = append(diagnostics, lintParameters(tokens, lets, *options.Parameters)...)
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+	return diagnostics, nil
+}
+
+// tokenDepths returns the bracket depth of every token, brackets count as inside their parent
+func tokenDepths(tokens []Token) []int {
+	depths := make([]int, len(tokens))
+	depth := 0
+	for i, tok := range tokens {
+		if isClosing(tok) && depth > 0 {
+			depth--
+		}
+		depths[i] = depth
+		if isOpening(tok) {
+			depth++
+		}
+	}
+	return depths
+}
+
+// letBindings finds the let statements of the query and of function bodies
+func letBindings(tokens []Token, depths []int) []letBinding {
+	var lets []letBinding
+	for i := 0; i+2 < len(tokens); i++ {
+		startsStatement := i == 0 || tokens[i-1].Text == ";" || tokens[i-1].Text == "{"
+		if !startsStatement || !strings.EqualFold(tokens[i].Text, "let") || tokens[i+1].Kind != Identifier || tokens[i+2].Text != "=" {
+			continue
+		}
+		end := i + 2
+		for end < len(tokens) && !(depths[end] == depths[i] && (tokens[end].Text == ";" || isClosing(tokens[end]))) {
+			end++
+		}
+		lets = append(lets, letBinding{name: tokens[i+1].Text, index: i + 1, end: end})
+	}
+	return lets
+}
+
+// isReference reports whether the token at i refers to name, rather than being a member or a parameter name
+func isReference(tokens []Token, i int, name string) bool {
+	return tokens[i].Kind == Identifier && tokens[i].Text == name && (i == 0 || tokens[i-1].Text != ".")
+}
+
+func lintUnusedLets(tokens []Token, lets []letBinding) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, let := range lets {
+		used := false
+		for i := let.index + 2; i < len(tokens) && !used; i++ {
+			used = isReference(tokens, i, let.name)
+		}
+		if !used {
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     tokens[let.index].Line,
+				Severity: SeverityWarning,
+				Rule:     RuleUnusedLet,
+				Message:  fmt.Sprintf("%s is declared but never used", let.name),
+			})
+		}
+	}
+	return diagnostics
+}
+
+func lintStarProjections(tokens []Token) []Diagnostic {
+	var diagnostics []Diagnostic
+	operator := ""
+	for i, tok := range tokens {
+		if i > 0 && tokens[i-1].Kind == Pipe {
+			operator = strings.ToLower(tok.Text)
+		}
+		if tok.Text != "*" || !starOperators[operator] {
+			continue
+		}
+		before, after := tokens[i-1].Text, ""
+		if i+1 < len(tokens) {
+			after = tokens[i+1].Text
+		}
+		if (starOperators[strings.ToLower(before)] || before == ",") && (after == "" || after == "," || after == "|" || after == ";" || after == ")") {
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     tok.Line,
+				Severity: SeverityWarning,
+				Rule:     RuleStarProjection,
+				Message:  fmt.Sprintf("%s * returns every column, list the columns needed instead", operator),
+			})
+		}
+	}
+	return diagnostics
+}
+
+// lintTimeFilters warns about large tables whose pipeline has no where clause restricting the time range
+func lintTimeFilters(tokens []Token, depths []int, lets []letBinding, options LintOptions) []Diagnostic {
+	if len(options.LargeTables) == 0 {
+		return nil
+	}
+
+	hints := map[string]bool{}
+	for _, variable := range options.TimeVariables {
+		hints[variable] = true
+	}
+	// Scalars bound to a time expression by let statements count as time filters as well
+	for _, let := range lets {
+		for i := let.index + 2; i < let.end; i++ {
+			if timeFilterHints[strings.ToLower(tokens[i].Text)] || hints[tokens[i].Text] {
+				hints[let.name] = true
+				break
+			}
+		}
+	}
+
+	largeTables := map[string]bool{}
+	for _, table := range options.LargeTables {
+		largeTables[table] = true
+	}
+
+	var diagnostics []Diagnostic
+	reported := map[string]bool{}
+	for i, tok := range tokens {
+		if !largeTables[tok.Text] || !isReference(tokens, i, tok.Text) || reported[tok.Text] {
+			continue
+		}
+		if !pipelineFiltersTime(tokens, depths, i, hints) {
+			reported[tok.Text] = true
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     tok.Line,
+				Severity: SeverityWarning,
+				Rule:     RuleTimeFilter,
+				Message:  fmt.Sprintf("%s is a large table but is not filtered by time", tok.Text),
+			})
+		}
+	}
+	return diagnostics
+}
+
+// pipelineFiltersTime reports whether a where stage of the pipeline starting at the token at i refers to time
+func pipelineFiltersTime(tokens []Token, depths []int, start int, hints map[string]bool) bool {
+	depth := depths[start]
+	inWhere := false
+	for i := start + 1; i < len(tokens); i++ {
+		if depths[i] < depth || (depths[i] == depth && tokens[i].Text == ";") {
+			break
+		}
+		if depths[i] == depth && tokens[i-1].Kind == Pipe {
+			operator := strings.ToLower(tokens[i].Text)
+			inWhere = operator == "where" || operator == "filter"
+			continue
+		}
+		if inWhere && (timeFilterHints[strings.ToLower(tokens[i].Text)] || hints[tokens[i].Text]) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintParameters reports references to dashboard variables that are not declared or not listed in usedVariables
+func lintParameters(tokens []Token, lets []letBinding, scope ParameterScope) []Diagnostic {
+	declared := map[string]bool{}
+	for _, variable := range scope.Declared {
+		declared[variable] = true
+	}
+	used := map[string]bool{}
+	for _, variable := range scope.Used {
+		used[variable] = true
+	}
+	local := map[string]bool{}
+	for _, let := range lets {
+		local[let.name] = true
+	}
+	// Columns the query creates, as in extend _total = a + b, and parameters of the functions it declares, as in
+	// (_x:int), named arguments such as with (_x=1) counting as well
+	for i, tok := range tokens {
+		isLocal := i+1 < len(tokens) && tokens[i+1].Text == "=" || isArgumentName(tokens, i)
+		if isLocal && tok.Kind == Identifier && parameterNameRegex.MatchString(tok.Text) {
+			local[tok.Text] = true
+		}
+	}
+
+	var diagnostics []Diagnostic
+	reported := map[string]bool{}
+	for i, tok := range tokens {
+		name := tok.Text
+		if !parameterNameRegex.MatchString(name) || !isReference(tokens, i, name) || local[name] || reported[name] {
+			continue
+		}
+		if !declared[name] && !used[name] && standardColumns[name] {
+			continue
+		}
+
+		switch {
+		case !declared[name]:
+			// Tables may have columns named like dashboard variables, so this may not be a mistake
+			reported[name] = true
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     tok.Line,
+				Severity: SeverityWarning,
+				Rule:     RuleUndeclaredParameter,
+				Message:  fmt.Sprintf("%s is not declared by any parameter or base query of the dashboard, nor a column the query creates", name),
+			})
+		case !used[name]:
+			reported[name] = true
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     tok.Line,
+				Severity: SeverityError,
+				Rule:     RuleUndeclaredParameter,
+				Message:  fmt.Sprintf("%s is used but missing from the usedVariables of the query", name),
+			})
+		}
+	}
+
+	for _, variable := range scope.Used {
+		if !declared[variable] {
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     1,
+				Severity: SeverityError,
+				Rule:     RuleUndeclaredParameter,
+				Message:  fmt.Sprintf("usedVariables lists %s, which is not declared by any parameter or base query of the dashboard", variable),
+			})
+		}
+	}
+	return diagnostics
+}
+
+// isArgumentName reports whether the identifier at i names an argument or a function parameter rather than referring
+// to a variable, as in with (_x=1) or (_x:int)
+func isArgumentName(tokens []Token, i int) bool {
+	return i > 0 && i+1 < len(tokens) && (tokens[i-1].Text == "(" || tokens[i-1].Text == ",") && (tokens[i+1].Text == ":" || tokens[i+1].Text == "=")
+}
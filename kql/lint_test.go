@@ -0,0 +1,106 @@
+package kql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		options LintOptions
+		want    []string // rule and severity of each diagnostic, in order
+	}{
+		{
+			name:  "clean query",
+			query: "T | where Timestamp > ago(1h) | project a, b",
+		},
+		{
+			name:  "unused let",
+			query: "let x = 1;\nT | take 1",
+			want:  []string{"unused-let warning"},
+		},
+		{
+			name:  "star projection",
+			query: "T | project *",
+			want:  []string{"star-projection warning"},
+		},
+		{
+			name:    "large table without time filter",
+			query:   "Heartbeat | take 1",
+			options: LintOptions{LargeTables: []string{"Heartbeat"}},
+			want:    []string{"time-filter warning"},
+		},
+		{
+			name:    "large table filtered by time",
+			query:   "Heartbeat | where TimeGenerated > ago(1d)",
+			options: LintOptions{LargeTables: []string{"Heartbeat"}},
+		},
+		{
+			name:    "keywords in strings and comments",
+			query:   "T // project *\n| where Name == 'project *'",
+			options: LintOptions{Parameters: &ParameterScope{}},
+		},
+		{
+			name:    "declared and used variable",
+			query:   "T | where Cluster == _cluster",
+			options: LintOptions{Parameters: &ParameterScope{Declared: []string{"_cluster"}, Used: []string{"_cluster"}}},
+		},
+		{
+			name:    "declared variable missing from usedVariables",
+			query:   "T | where Cluster == _cluster",
+			options: LintOptions{Parameters: &ParameterScope{Declared: []string{"_cluster"}}},
+			want:    []string{"undeclared-parameter error"},
+		},
+		{
+			name:    "usedVariables lists an undeclared variable",
+			query:   "T | take 1",
+			options: LintOptions{Parameters: &ParameterScope{Used: []string{"_cluster"}}},
+			want:    []string{"undeclared-parameter error"},
+		},
+		{
+			name:    "undeclared name may be a column",
+			query:   "T | where _Region == 'west'",
+			options: LintOptions{Parameters: &ParameterScope{}},
+			want:    []string{"undeclared-parameter warning"},
+		},
+		{
+			name:    "standard underscore columns",
+			query:   "AzureDiagnostics | where _ResourceId has 'vm' | summarize sum(_BilledSize) by _SubscriptionId",
+			options: LintOptions{Parameters: &ParameterScope{}},
+		},
+		{
+			name:    "columns the query creates",
+			query:   "T | extend _total = a + b | where _total > 1",
+			options: LintOptions{Parameters: &ParameterScope{}},
+		},
+		{
+			name:    "function parameters",
+			query:   "let f = (_x:int) { T | where a == _x };\nf(1)",
+			options: LintOptions{Parameters: &ParameterScope{}},
+		},
+		{
+			name:    "mixed-case variables are distinct",
+			query:   "T | where a == _Cluster",
+			options: LintOptions{Parameters: &ParameterScope{Declared: []string{"_cluster"}, Used: []string{"_cluster"}}},
+			want:    []string{"undeclared-parameter warning"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics, err := Lint(tt.query, tt.options)
+			if err != nil {
+				t.Fatalf("Lint returned error: %v", err)
+			}
+			var got []string
+			for _, diagnostic := range diagnostics {
+				got = append(got, diagnostic.Rule+" "+diagnostic.Severity.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("diagnostics = %v, want %v\n%v", got, tt.want, diagnostics)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package kql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// TokenKind classifies a token of a KQL query
+type TokenKind int
+
+const (
+	Identifier TokenKind = iota
+	Number
+	String
+	Operator
+	Punctuation
+	Pipe
+	Comment
+	Directive // a template include, e.g. {{ include "filters.kql"}}
+)
+
+// Token is a lexical element of a KQL query
+type Token struct {
+	Kind     TokenKind
+	Text     string
+	Line     int
+	Offset   int  // offset of the token in runes
+	Space    bool // whitespace precedes the token
+	Newlines int  // number of line breaks preceding the token
+}
+
+// dashedOperators are operators whose names contain a dash and must not be split into a subtraction
+var dashedOperators = map[string]bool{
+	"project-away": true, "project-keep": true, "project-rename": true, "project-reorder": true,
+	"mv-expand": true, "mv-apply": true, "make-series": true, "parse-where": true, "parse-kv": true,
+	"top-nested": true, "top-hitters": true,
+}
+
+// literalConstructors take a literal value in parentheses
+var literalConstructors = map[string]bool{"datetime": true, "timespan": true, "time": true, "guid": true}
+
+// includeDirectiveRegex matches the start of a template include
+var includeDirectiveRegex = regexp.MustCompile(`^\{\{\s*include\s`)
+
+// multiCharOperators are matched before single character operators
+var multiCharOperators = []string{"==", "!=", "<>", "<=", ">=", "=~", "!~", "=>", "..", "<|"}
+
+// Tokenize splits a KQL query into tokens, without whitespace
+func Tokenize(text string) ([]Token, error) {
+	var tokens []Token
+	runes := []rune(text)
+	line := 1
+	space, newlines := false, 0
+
+	emit := func(kind TokenKind, start, end int) {
+		tokens = append(tokens, Token{Kind: kind, Text: string(runes[start:end]), Line: line, Offset: start, Space: space, Newlines: newlines})
+		line += strings.Count(string(runes[start:end]), "\n")
+		space, newlines = false, 0
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line++
+			space = true
+			newlines++
+			i++
+
+		case unicode.IsSpace(r):
+			space = true
+			i++
+
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			emit(Comment, i, end)
+			i = end
+
+		case r == '{' && includeDirectiveRegex.MatchString(string(runes[i:min(len(runes), i+64)])):
+			end := indexFrom(runes, i, "}}")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated include", line)
+			}
+			emit(Directive, i, end+2)
+			i = end + 2
+
+		case r == '`' && hasPrefix(runes, i, "```"):
+			end := indexFrom(runes, i+3, "```")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated multi-line string", line)
+			}
+			emit(String, i, end+3)
+			i = end + 3
+
+		case r == '"' || r == '\'' || ((r == '@' || r == 'h' || r == 'H') && startsString(runes, i)):
+			end, err := scanString(runes, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			emit(String, i, end)
+			i = end
+
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (isIdentifierRune(runes[end]) || (runes[end] == '.' && end+1 < len(runes) && unicode.IsDigit(runes[end+1]))) {
+				end++
+			}
+			emit(Number, i, end)
+			i = end
+
+		case isIdentifierStart(r) || (r == '!' && i+1 < len(runes) && unicode.IsLetter(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && isIdentifierRune(runes[end]) {
+				end++
+			}
+			// Dashed operator names such as project-away
+			for end < len(runes) && runes[end] == '-' {
+				next := end + 1
+				for next < len(runes) && isIdentifierRune(runes[next]) {
+					next++
+				}
+				if next == end+1 || !dashedOperators[strings.ToLower(string(runes[i:next]))] {
+					break
+				}
+				end = next
+			}
+			// Case insensitive operator variants such as in~
+			if end < len(runes) && runes[end] == '~' {
+				end++
+			}
+			emit(Identifier, i, end)
+			i = end
+
+			// Literals such as datetime(2024-01-01) are kept verbatim rather than read as arithmetic
+			if literalConstructors[strings.ToLower(tokens[len(tokens)-1].Text)] && i < len(runes) && runes[i] == '(' {
+				closing := i + 1
+				for closing < len(runes) && runes[closing] != ')' && runes[closing] != '(' && runes[closing] != '\n' {
+					closing++
+				}
+				if closing < len(runes) && runes[closing] == ')' && strings.TrimSpace(string(runes[i+1:closing])) != "" {
+					emit(Punctuation, i, i+1)
+					emit(Number, i+1, closing)
+					emit(Punctuation, closing, closing+1)
+					i = closing + 1
+				}
+			}
+
+		case r == '|':
+			emit(Pipe, i, i+1)
+			i++
+
+		case strings.ContainsRune("()[]{},;.:", r) && !hasPrefix(runes, i, ".."):
+			emit(Punctuation, i, i+1)
+			i++
+
+		default:
+			length := 1
+			for _, op := range multiCharOperators {
+				if hasPrefix(runes, i, op) {
+					length = len(op)
+					break
+				}
+			}
+			emit(Operator, i, i+length)
+			i += length
+		}
+	}
+
+	return tokens, nil
+}
+
+// startsString reports whether an @ or h prefix at i starts a string literal
+func startsString(runes []rune, i int) bool {
+	j := i
+	if runes[j] == 'h' || runes[j] == 'H' {
+		j++
+		if j < len(runes) && runes[j] == '@' {
+			j++
+		}
+	} else {
+		j++
+	}
+	if i > 0 && isIdentifierRune(runes[i-1]) {
+		return false
+	}
+	return j < len(runes) && (runes[j] == '"' || runes[j] == '\'')
+}
+
+// scanString returns the index after the string literal starting at i
+func scanString(runes []rune, i int) (int, error) {
+	verbatim := false
+	for runes[i] != '"' && runes[i] != '\'' {
+		if runes[i] == '@' {
+			verbatim = true
+		}
+		i++
+	}
+
+	quote := runes[i]
+	for j := i + 1; j < len(runes); j++ {
+		switch {
+		case runes[j] == '\n':
+			return 0, fmt.Errorf("unterminated string")
+		case !verbatim && runes[j] == '\\':
+			j++
+		case runes[j] == quote:
+			// Verbatim strings escape quotes by doubling them
+			if verbatim && j+1 < len(runes) && runes[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func hasPrefix(runes []rune, i int, prefix string) bool {
+	return strings.HasPrefix(string(runes[i:min(len(runes), i+len(prefix))]), prefix)
+}
+
+func indexFrom(runes []rune, i int, substr string) int {
+	index := strings.Index(string(runes[i:]), substr)
+	if index < 0 {
+		return -1
+	}
+	return i + len([]rune(string(runes[i:])[:index]))
+}
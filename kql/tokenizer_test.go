@@ -0,0 +1,108 @@
+package kql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+		kinds []TokenKind
+	}{
+		{
+			name:  "pipeline",
+			query: "StormEvents | where State == 'TEXAS'",
+			want:  []string{"StormEvents", "|", "where", "State", "==", "'TEXAS'"},
+			kinds: []TokenKind{Identifier, Pipe, Identifier, Identifier, Operator, String},
+		},
+		{
+			name:  "keywords in strings stay strings",
+			query: `T | where Name == "a | and by desc" or Name == @'c:\by'`,
+			want:  []string{"T", "|", "where", "Name", "==", `"a | and by desc"`, "or", "Name", "==", `@'c:\by'`},
+			kinds: []TokenKind{Identifier, Pipe, Identifier, Identifier, Operator, String, Identifier, Identifier, Operator, String},
+		},
+		{
+			name:  "comments run to the end of the line",
+			query: "T // where x | and desc\n| take 1",
+			want:  []string{"T", "// where x | and desc", "|", "take", "1"},
+			kinds: []TokenKind{Identifier, Comment, Pipe, Identifier, Number},
+		},
+		{
+			name:  "dashed operators",
+			query: "T | project-away _ResourceId | extend x = a-b",
+			want:  []string{"T", "|", "project-away", "_ResourceId", "|", "extend", "x", "=", "a", "-", "b"},
+		},
+		{
+			name:  "literals are kept whole",
+			query: "T | where t > datetime(2024-01-01 10:00) and d < 1.5h",
+			want:  []string{"T", "|", "where", "t", ">", "datetime", "(", "2024-01-01 10:00", ")", "and", "d", "<", "1.5h"},
+		},
+		{
+			name:  "case insensitive operators",
+			query: "T | where a in~ ('x') and b !has 'y'",
+			want:  []string{"T", "|", "where", "a", "in~", "(", "'x'", ")", "and", "b", "!has", "'y'"},
+		},
+		{
+			name:  "multi-line strings",
+			query: "print ```a\nb```",
+			want:  []string{"print", "```a\nb```"},
+			kinds: []TokenKind{Identifier, String},
+		},
+		{
+			name:  "template includes",
+			query: "T\n{{ include \"filters.kql\" }}\n| take 1",
+			want:  []string{"T", "{{ include \"filters.kql\" }}", "|", "take", "1"},
+			kinds: []TokenKind{Identifier, Directive, Pipe, Identifier, Number},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := Tokenize(tt.query)
+			if err != nil {
+				t.Fatalf("Tokenize returned error: %v", err)
+			}
+			var texts []string
+			var kinds []TokenKind
+			for _, tok := range tokens {
+				texts = append(texts, tok.Text)
+				kinds = append(kinds, tok.Kind)
+			}
+			if !reflect.DeepEqual(texts, tt.want) {
+				t.Errorf("tokens = %q, want %q", texts, tt.want)
+			}
+			if tt.kinds != nil && !reflect.DeepEqual(kinds, tt.kinds) {
+				t.Errorf("kinds = %v, want %v", kinds, tt.kinds)
+			}
+		})
+	}
+}
+
+func TestTokenizeLines(t *testing.T) {
+	tokens, err := Tokenize("T\n| where x == ```a\nb```\n| take 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := tokens[len(tokens)-1]
+	if last.Text != "1" || last.Line != 4 || last.Newlines != 0 {
+		t.Errorf("last token = %+v, want 1 on line 4", last)
+	}
+	if pipe := tokens[len(tokens)-3]; pipe.Kind != Pipe || pipe.Newlines != 1 {
+		t.Errorf("token %+v, want a pipe after a line break", pipe)
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	for _, query := range []string{
+		`T | where x == "unterminated`,
+		"print ```unterminated",
+		`T {{ include "a.kql"`,
+	} {
+		if _, err := Tokenize(query); err == nil {
+			t.Errorf("Tokenize(%q) succeeded, want an error", query)
+		}
+	}
+}
@@ -1,219 +1,416 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/joho/godotenv"
 	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
-	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/git"
 	"github.com/omeshp/kusto-dashboards-sync/utils"
-	"gopkg.in/yaml.v2"
-	"log"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+	"log/slog"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 )
 
-const Dashboard_Template_Path = "dashboard.yml"
+const Dashboard_Template_Path = workspace.TemplatePath
 const Dashboard_Output_Path = "bin/dashboard_processed.yml"
 const Dashboard_JSON_Output_Path = "bin/dashboard.json"
+const Dashboard_Split_Path = workspace.SplitPath
+const Dashboards_Base_URL = "https://dashboards.kusto.windows.net/dashboards/"
 
-func main() {
+// Dashboards_Resource is the resource tokens for the dashboards service are requested for from the Azure CLI
+const Dashboards_Resource = "35e917a9-4d95-4062-9d97-5781291353b9"
 
-	// Define the command-line arguments
-	var command string
-	var dashboardID = ""
+// Layouts of the dashboard template on disk
+const (
+	Layout_Single = workspace.LayoutSingle
+	Layout_Split  = workspace.LayoutSplit
+)
 
-	// Customize the usage message
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		fmt.Println("  pull: Pull the data for dashboard set in config.yml")
-		fmt.Println("  push: Push the data to dashboard set in config.yml")
-		fmt.Println("  pull [dashboard id]")
-		fmt.Println("  push [dashboard id]")
-	}
+// pullReport is the --output json form of pull and sync
+type pullReport struct {
+	DashboardID string `json:"dashboardId"`
+	Title       string `json:"title"`
+	// Branch is the branch sync committed on
+	Branch  string   `json:"branch,omitempty"`
+	Files   []string `json:"files"`
+	Removed []string `json:"removed"`
+	Kept    []string `json:"kept"`
+	// Overwritten are the files modified locally a forced pull overwrote
+	Overwritten []string            `json:"overwritten"`
+	Factored    []string            `json:"factored"`
+	Spliced     []string            `json:"spliced"`
+	Snapshot    *workspace.Snapshot `json:"snapshot,omitempty"`
+	Recovered   bool                `json:"recovered"`
+	// Commit is the hash of the commit of the pulled files, empty when there was nothing to commit
+	Commit string `json:"commit,omitempty"`
+}
 
-	// Parse the command-line arguments
-	flag.Parse()
+// pushReport is the --output json form of push
+type pushReport struct {
+	DashboardID string `json:"dashboardId"`
+	Template    string `json:"template"`
+	// Pushed is unset for dry runs
+	Pushed  bool     `json:"pushed"`
+	Spliced []string `json:"spliced"`
+	// Changes summarize what push --watch changed in the dashboard
+	Changes []string            `json:"changes,omitempty"`
+	Backup  *workspace.Snapshot `json:"backup,omitempty"`
+	DryRun  *dryRunReport       `json:"dryRun,omitempty"`
+	Error   *errorDetail        `json:"error,omitempty"`
+}
 
-	// Check for remaining arguments (non-flag arguments)
-	if flag.NArg() > 0 {
-		command = flag.Arg(0)
+// PullCommand pulls a dashboard, the one configured in config.yml by default, into the workspace
+func PullCommand(args []string) {
+	flags := newFlagSet("pull")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	factorLets := flags.String("factor-lets", "", "move let statements shared by several queries into snippets or base-queries")
+	force := flags.Bool("force", false, "overwrite and delete files modified locally since the last pull")
+	commit := flags.Bool("commit", false, "commit the files changed by the pull, with a message summarizing the changes to the dashboard")
+	selection := addSelectionFlags(flags, "refresh", "leaving the rest of the template as last pulled")
+	parseFlags(flags, args)
+
+	dashboardID := dashboardIDArg(flags)
+	var repo *git.Repo
+	if *commit {
+		// Checked before pulling, so the pull is not left uncommitted
+		repo = openRepo()
 	}
 
-	if flag.NArg() > 1 {
-		dashboardID = flag.Arg(1)
+	options := workspace.PullOptions{FactorLets: *factorLets, Force: *force, Selection: *selection}
+	result := PullDashboard(localConfig().DashboardID, dashboardID, dashboardsAccessToken(), checkLayout(flags, *layout), options)
+	report := newPullReport(dashboardID, result, *force)
+	if *commit {
+		hash, err := commitPull(repo, checkLayout(flags, *layout), dashboardID, result)
+		if err != nil {
+			fatalf("Failed to commit the pulled files: %v", err)
+		}
+		if hash == "" {
+			printInfo("Nothing to commit, the pulled files are as committed\n")
+		} else {
+			printInfo("Committed %s\n", shortHash(hash))
+		}
+		report.Commit = hash
 	}
 
-	fmt.Printf("Command: %s\n", command)
-	fmt.Printf("Dashboard ID: %s\n", dashboardID)
-
-	// If no flags are provided, print the usage message and exit
-	if flag.NArg() == 0 {
-		flag.Usage()
-		os.Exit(1)
-	} else {
-		command = flag.Arg(0)
+	if jsonOutput() {
+		printJSON(report)
 	}
+}
 
-	config, err := getDashboardConfig()
-	if err != nil {
-		log.Fatalf("Error loading dashboard config from config.yml file")
+// newPullReport describes a pull for --output json
+func newPullReport(dashboardID string, result *workspace.PullResult, force bool) *pullReport {
+	report := &pullReport{
+		DashboardID: dashboardID,
+		Title:       result.Dashboard.Title,
+		Files:       nonNil(result.Files),
+		Removed:     nonNil(result.Removed),
+		Kept:        nonNil(result.Kept),
+		Overwritten: []string{},
+		Factored:    nonNil(result.Factored),
+		Spliced:     nonNil(result.Spliced),
+		Snapshot:    result.Snapshot,
+		Recovered:   result.Recovered,
 	}
-
-	// Load environment variables from .env file
-	err = godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file")
+	if force {
+		report.Overwritten = nonNil(result.Conflicts)
 	}
+	return report
+}
 
-	masterDashboardId := config.DashboardID
-
-	// Get the access token from the environment variables
-	accessToken := os.Getenv("ACCESS_TOKEN")
-	if accessToken == "" {
-		log.Fatalf("ACCESS_TOKEN not set in .env file")
+// PushCommand renders the template and pushes it to a dashboard, the one configured in config.yml by default
+func PushCommand(args []string) {
+	flags := newFlagSet("push")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	watch := flags.Bool("watch", false, "keep watching the template and queries, pushing every time the rendered dashboard changes")
+	verify := flags.Bool("verify", false, "run every tile and base query before pushing and do not push if any fails")
+	noBackup := flags.Bool("no-backup", false, "push without snapshotting the dashboard into the backup archive first")
+	dryRun := flags.Bool("dry-run", false, "do everything but back up and send the request, printing the request instead")
+	dryRunOpts := addDryRunFlags(flags)
+	selection := addSelectionFlags(flags, "push", "leaving the rest of the dashboard as it is")
+	verifyOpts := addVerifyFlags(flags)
+	values := addTemplateFlags(flags)
+	parseFlags(flags, args)
+
+	dashboardID := dashboardIDArg(flags)
+	if !*verify {
+		verifyOpts = nil
+	}
+	if !*dryRun {
+		if dryRunOpts.offline || dryRunOpts.requestOut != "" {
+			usageError(flags, "--offline and --request-out only apply to --dry-run")
+		}
+		dryRunOpts = nil
 	}
 
-	accessToken = "Bearer " + accessToken
-
-	// Create queries directory
-	err = os.MkdirAll("bin", 0755)
-	if err != nil {
-		fmt.Errorf("error creating queries directory: %v", err)
+	if *watch && !selection.IsEmpty() {
+		usageError(flags, "--tile and --page cannot be combined with --watch")
 	}
 
-	if command == "pull" {
-		if dashboardID == "" {
-			dashboardID = masterDashboardId
+	if *dryRun {
+		if *watch {
+			usageError(flags, "--dry-run cannot be combined with --watch")
 		}
-
-		PullDashboard(masterDashboardId, dashboardID, accessToken, err)
+		// Offline dry runs make no request, so they need no token
+		accessToken := ""
+		if !dryRunOpts.offline {
+			accessToken = dashboardsAccessToken()
+		}
+		PushDashboard(accessToken, dashboardID, pushOptions{layout: checkLayout(flags, *layout), values: values, verify: verifyOpts, selection: *selection, dryRun: dryRunOpts})
+		return
 	}
 
-	if command == "push" {
-		if dashboardID != "" {
-			PushDashboard(err, accessToken, dashboardID)
-		} else {
-			PushDashboard(err, accessToken, masterDashboardId)
-		}
+	accessToken := dashboardsAccessToken()
+	if *watch {
+		WatchDashboard(accessToken, dashboardID, checkLayout(flags, *layout), values, verifyOpts, !*noBackup)
+	} else {
+		PushDashboard(accessToken, dashboardID, pushOptions{layout: checkLayout(flags, *layout), values: values, verify: verifyOpts, backup: !*noBackup, selection: *selection})
 	}
+}
 
+// interruptChannel returns a channel closed on the first Ctrl-C or SIGTERM, so the command can stop cleanly. The
+// signal is handled only once, a second one kills the process as usual.
+func interruptChannel() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	interrupted := make(chan struct{})
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(interrupted)
+	}()
+	return interrupted
 }
 
-type Config struct {
-	DashboardID string `yaml:"dashboard_id"`
+// addSelectionFlags adds the repeatable --tile and --page flags selecting the tiles a command applies to
+func addSelectionFlags(flags *flag.FlagSet, verb string, rest string) *workspace.Selection {
+	selection := &workspace.Selection{}
+	flags.Func("tile", fmt.Sprintf("%s only this tile, by id or title, with its query, %s (repeatable)", verb, rest), func(value string) error {
+		selection.Tiles = append(selection.Tiles, value)
+		return nil
+	})
+	flags.Func("page", fmt.Sprintf("%s only the tiles of this page, by name, %s (repeatable)", verb, rest), func(value string) error {
+		selection.Pages = append(selection.Pages, value)
+		return nil
+	})
+	return selection
 }
 
-func getDashboardConfig() (*Config, error) {
-	data, err := os.ReadFile("config.yml")
-	if err != nil {
-		return &Config{}, err
+// checkLayout returns the layout given by the --layout flag, single when empty
+func checkLayout(flags *flag.FlagSet, layout string) string {
+	if layout == "" {
+		return Layout_Single
 	}
-
-	var cfg Config
-	err = yaml.Unmarshal(data, &cfg)
-	if err != nil {
-		return &Config{}, err
+	if layout != Layout_Single && layout != Layout_Split {
+		usageError(flags, "Unknown layout %q, expected %s or %s", layout, Layout_Single, Layout_Split)
 	}
+	return layout
+}
+
+type Config = workspace.Config
 
-	return &cfg, nil
+// newDashboardsAPI returns the client of the dashboards service. Exchanges with the service are recorded to the file
+// named by DASHBOARDS_RECORD, or answered from the recording named by DASHBOARDS_REPLAY without network access.
+func newDashboardsAPI(accessToken string) dataexplorer.DashboardsAPI {
+	return newDashboardsClient(dataexplorer.StaticToken(accessToken))
 }
 
-func PushDashboard(err error, accessToken string, dashboardId string) {
-	err = utils.ProcessTemplate(Dashboard_Template_Path, Dashboard_Output_Path)
-	if err != nil {
-		fmt.Println("Failed to process template file: %v\n", err)
-		return
+// newDashboardsClient is newDashboardsAPI authorizing requests with tokens of tokenProvider
+func newDashboardsClient(tokenProvider dataexplorer.TokenProvider) dataexplorer.DashboardsAPI {
+	var client *dataexplorer.DataExplorerClient
+	if path := os.Getenv("DASHBOARDS_REPLAY"); path != "" {
+		var err error
+		if client, err = dataexplorer.NewReplayClient(Dashboards_Base_URL, path); err != nil {
+			fatalf("Failed to load recorded exchanges: %v", err)
+		}
+	} else if path := os.Getenv("DASHBOARDS_RECORD"); path != "" {
+		client = dataexplorer.NewRecordingClient(Dashboards_Base_URL, tokenProvider, path)
+	} else {
+		client = dataexplorer.NewTokenProviderClient(Dashboards_Base_URL, tokenProvider)
 	}
+	traceClient(client.Client)
+	client.Context = commandContext
+	return client
+}
 
-	fmt.Printf("Succeeded in processing template file: %s, with output: %s\n", Dashboard_Template_Path, Dashboard_Output_Path)
-
-	// Convert YAML to JSON
-	jsonData, err := utils.ConvertYAMLToJSON(Dashboard_Output_Path)
-	if err != nil {
-		log.Fatalf("Failed to convert YAML to JSON: %v", err)
+// traceClient logs the exchanges of a client with --trace, dumping them to the --trace-dir directory
+func traceClient(client *http.Client) {
+	if globalOptions.trace {
+		dataexplorer.TraceClient(client, globalOptions.traceDir)
 	}
+}
 
-	jsonString := string(jsonData)
-	jsonString = strings.ReplaceAll(jsonString, "\\\\n", "\\n")
+func getDashboardConfig() (*Config, error) {
+	return workspace.LoadConfigFile(utils.DirFS("."), configPath())
+}
 
-	// Write JSON to output.json file
-	err = os.WriteFile(Dashboard_JSON_Output_Path, []byte(jsonString), 0644)
+// pushOptions configure PushDashboard
+type pushOptions struct {
+	layout string
+	values *templateValues
+	// verify runs the queries of the dashboard before pushing it, unless nil
+	verify *verifyOptions
+	// backup snapshots the dashboard into the backup archive before pushing
+	backup    bool
+	selection workspace.Selection
+	// dryRun prepares the request without sending it, unless nil
+	dryRun *dryRunOptions
+}
+
+// PushDashboard renders the template of the layout and pushes it, or the tiles and pages selected, to a dashboard
+func PushDashboard(accessToken string, dashboardId string, options pushOptions) {
+	layout, verify, dryRun := options.layout, options.verify, options.dryRun
+	ws := openWorkspace(layout, newDashboardsAPI(accessToken))
+
+	result, err := ws.Push(dashboardId, workspace.PushOptions{
+		Values:     *options.values,
+		SkipBackup: !options.backup,
+		Selection:  options.selection,
+		DryRun:     dryRun != nil,
+		Offline:    dryRun != nil && dryRun.offline,
+		Check: func(result *workspace.PushResult) error {
+			if err := writeRenderedDashboard(result.Rendered); err != nil {
+				return err
+			}
+			printLintDiagnostics(result.Validation.Diagnostics)
+
+			if verify != nil {
+				if failed := verifyDashboard(result.Rendered.Dashboard, *verify); failed > 0 {
+					return fmt.Errorf("%d queries failed, not pushing", failed)
+				}
+			}
+			return nil
+		},
+	})
+	if errors.Is(err, workspace.ErrInvalid) {
+		// The rendered dashboard helps finding the problems, but they are reported without it too
+		if err := writeRenderedDashboard(result.Rendered); err != nil {
+			slog.Warn("Failed to write the rendered dashboard", "error", err)
+		}
+		printValidationProblems(result.Validation.Problems)
+		lintErrors := printLintDiagnostics(result.Validation.Diagnostics)
+		if len(result.Validation.Problems) > 0 {
+			fatalCodef(Error_Invalid, "Dashboard %s is not valid, not pushing", layoutPath(layout))
+		}
+		fatalCodef(Error_Invalid, "%d lint errors in dashboard queries, not pushing", lintErrors)
+	}
 	if err != nil {
-		fmt.Printf("Error writing JSON to file: %v\n", err)
-		return
+		fatalf("Failed to push dashboard: %v", err)
 	}
-
-	fmt.Printf("Dashboard json written to %s\n", Dashboard_JSON_Output_Path)
-	// Unmarshal the response body into a Dashboard struct
-	var dashboard interface{}
-	if err := json.Unmarshal([]byte(jsonString), &dashboard); err != nil {
-		log.Fatalf("Failed to convert YAML to JSON: %v", err)
+	for _, change := range result.Spliced {
+		printInfo("Spliced: %s\n", change)
 	}
 
-	dataExplorerClient := dataexplorer.NewDataExplorerClient("https://dashboards.kusto.windows.net/dashboards/", accessToken)
-
-	//currentDashboard, err := dataExplorerClient.GetDashboard(dashboardId)
-	//if err != nil {
-	//	log.Fatalf("error updating dashboard: %v", err)
-	//}
-	//
-	//dashboardMap := dashboard.(map[string]interface{})
-	//dashboardMap["eTag"] = currentDashboard.ETag
+	report := &pushReport{DashboardID: dashboardId, Template: layoutPath(layout), Spliced: nonNil(result.Spliced), Backup: result.Backup}
+	if result.DryRun != nil {
+		reportDryRun(report, result.DryRun, *dryRun)
+	} else {
+		if result.Backup != nil {
+			printInfo("Previous version of %s is snapshot %s, restore it with: restore %s\n", dashboardId, result.Backup.ShortHash(), result.Backup.ShortHash())
+		}
+		printInfo("Pushed %s to %s\n", layoutPath(layout), dashboardId)
+		report.Pushed = true
+	}
 
-	// Call the function to update the dashboard
-	err = dataExplorerClient.UpdateDashboardRaw(dashboardId, &dashboard)
-	if err != nil {
-		log.Fatalf("error updating dashboard: %v", err)
+	if jsonOutput() {
+		printJSON(report)
+	}
+	if report.Error != nil {
+		failf("%s", report.Error.Message)
 	}
 }
 
-func PullDashboard(masterDashboardId string, dashboardID string, accessToken string, err error) {
-	dataExplorerClient := dataexplorer.NewDataExplorerClient("https://dashboards.kusto.windows.net/dashboards/", accessToken)
-
-	// Get dashboard
-	rawDashboard, err := dataExplorerClient.GetDashboardRaw(dashboardID)
-	if err != nil {
-		fmt.Printf("Error retrieving dashboard: %v\n", err)
-		return
+// writeRenderedDashboard writes the processed template and the JSON document pushed to the bin directory
+func writeRenderedDashboard(rendered *workspace.Rendered) error {
+	if err := os.MkdirAll("bin", 0755); err != nil {
+		return fmt.Errorf("error creating bin directory: %v", err)
 	}
-
-	// Convert raw dashboard to concrete one
-	dashboard, err := ConvertRawDashboardToConcrete(rawDashboard)
-	if err != nil {
-		fmt.Printf("Error converting raw dashboard to concrete one: %v\n", err)
-		return
+	if err := os.WriteFile(Dashboard_Output_Path, rendered.YAML, 0644); err != nil {
+		return fmt.Errorf("error writing processed template: %v", err)
+	}
+	if err := os.WriteFile(Dashboard_JSON_Output_Path, rendered.JSON, 0644); err != nil {
+		return fmt.Errorf("error writing JSON to file: %v", err)
 	}
 
-	fmt.Printf("Retrieved Dashboard ID: %s, Title: %s\n", dashboardID, dashboard.Title)
+	slog.Debug("Dashboard JSON written", "path", Dashboard_JSON_Output_Path)
+	return nil
+}
 
-	masterDashboard, err := dataExplorerClient.GetDashboard(masterDashboardId)
-	if err != nil {
-		fmt.Printf("Error retrieving dashboard: %v\n", err)
-		return
+// layoutPath returns the template file or directory of the layout
+func layoutPath(layout string) string {
+	if layout == Layout_Split {
+		return Dashboard_Split_Path
 	}
+	return Dashboard_Template_Path
+}
 
-	// Save queries to files
-	err = utils.PersistDashboardData(rawDashboard, masterDashboard, Dashboard_Template_Path)
+// PullDashboard pulls a dashboard into the workspace and prints what changed, exiting on failure
+func PullDashboard(masterDashboardId string, dashboardID string, accessToken string, layout string, options workspace.PullOptions) *workspace.PullResult {
+	result, err := pullDashboard(masterDashboardId, dashboardID, accessToken, layout, options)
 	if err != nil {
-		log.Fatalf("error saving queries to files: %v", err)
+		exitPullFailed(result, err)
 	}
+	return result
 }
 
-// ConvertRawDashboardToConcrete takes a raw dashboard and converts it into a models.Dashboard
-func ConvertRawDashboardToConcrete(rawDashboard *interface{}) (*models.Dashboard, error) {
-	// Marshal the raw dashboard into JSON
-	rawDashboardJSON, err := json.Marshal(rawDashboard)
-	if err != nil {
-		return nil, fmt.Errorf("error marshalling raw dashboard: %v", err)
+// exitPullFailed reports why a pull failed and exits
+func exitPullFailed(result *workspace.PullResult, err error) {
+	if errors.Is(err, workspace.ErrInterrupted) {
+		fatalCodef(Error_Interrupted, "Pull interrupted, the workspace was left as it was")
 	}
+	if errors.Is(err, workspace.ErrLocalChanges) {
+		if !jsonOutput() {
+			fmt.Fprintln(os.Stderr, "Pulling would overwrite files modified locally since the last pull:")
+			for _, file := range result.Conflicts {
+				fmt.Fprintf(os.Stderr, "  %s\n", file)
+			}
+		}
+		exitError(errorDetail{
+			Code:    Error_LocalChanges,
+			Message: "Pulling would overwrite files modified locally since the last pull, commit or move the changes, or pull again with --force to discard them",
+			Files:   result.Conflicts,
+		})
+	}
+	fatalf("Failed to pull dashboard: %v", err)
+}
 
-	// Unmarshal the JSON into a models.Dashboard
-	var dashboard models.Dashboard
-	if err := json.Unmarshal(rawDashboardJSON, &dashboard); err != nil {
-		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+// pullDashboard pulls a dashboard into the workspace and prints what changed, returning the errors PullDashboard exits on
+func pullDashboard(masterDashboardId string, dashboardID string, accessToken string, layout string, options workspace.PullOptions) (*workspace.PullResult, error) {
+	ws := openWorkspace(layout, newDashboardsAPI(accessToken))
+	ws.Config.DashboardID = masterDashboardId
+
+	options.Cancel = interruptChannel()
+	result, err := ws.Pull(dashboardID, options)
+	if err != nil {
+		return result, err
 	}
 
-	return &dashboard, nil
+	if result.Recovered {
+		printInfo("Rolled back an earlier pull that was killed before completing\n")
+	}
+	printInfo("Retrieved Dashboard ID: %s, Title: %s\n", dashboardID, result.Dashboard.Title)
+	for _, factored := range result.Factored {
+		printInfo("Factored %s\n", factored)
+	}
+	for _, spliced := range result.Spliced {
+		printInfo("Refreshed: %s\n", spliced)
+	}
+	for _, file := range result.Files {
+		slog.Debug("Saved file", "path", file)
+	}
+	for _, file := range result.Removed {
+		printInfo("Removed %s\n", file)
+	}
+	for _, file := range result.Kept {
+		printInfo("Kept local changes to %s\n", file)
+	}
+	if options.Force {
+		for _, file := range result.Conflicts {
+			printInfo("Overwrote local changes to %s\n", file)
+		}
+	}
+	return result, nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// migrateReport is the --output json form of migrate
+type migrateReport struct {
+	Template    string             `json:"template"`
+	FromVersion string             `json:"fromVersion"`
+	ToVersion   string             `json:"toVersion"`
+	Applied     []migrationApplied `json:"applied"`
+	// Written is unset when the template was already up to date or with --dry-run
+	Written bool `json:"written"`
+	// Diff is the change --dry-run would make
+	Diff string `json:"diff,omitempty"`
+}
+
+type migrationApplied struct {
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+// MigrateDashboard upgrades the dashboard template to the latest known schema version
+func MigrateDashboard(args []string) {
+	flags := newFlagSet("migrate")
+	dryRun := flags.Bool("dry-run", false, "print the diff without writing the template")
+	parseFlags(flags, args)
+
+	templatePath := Dashboard_Template_Path
+	if flags.NArg() > 0 {
+		templatePath = flags.Arg(0)
+	}
+
+	original, err := os.ReadFile(templatePath)
+	if err != nil {
+		fatalf("Failed to read template file: %v", err)
+	}
+
+	doc, err := utils.ParseTemplateDocument(original)
+	if err != nil {
+		fatalf("Failed to parse template file: %v", err)
+	}
+
+	fromVersion := models.SchemaVersionOf(doc)
+	applied, err := models.Migrate(doc)
+	if err != nil {
+		fatalf("Failed to migrate %s: %v", templatePath, err)
+	}
+
+	report := migrateReport{Template: templatePath, FromVersion: fromVersion, ToVersion: fromVersion, Applied: []migrationApplied{}}
+	if models.IsNewerSchemaVersion(fromVersion) {
+		slog.Warn("The template is at a schema version newer than the latest this version knows, leaving it as it is", "template", templatePath, "version", fromVersion, "latest", models.LatestSchemaVersion())
+	}
+	if len(applied) == 0 {
+		printInfo("%s is already at schema version %s\n", templatePath, fromVersion)
+		if jsonOutput() {
+			printJSON(report)
+		}
+		return
+	}
+
+	report.ToVersion = models.LatestSchemaVersion()
+	for _, migration := range applied {
+		printInfo("Schema version %s: %s\n", migration.Version, migration.Description)
+		report.Applied = append(report.Applied, migrationApplied{Version: migration.Version, Description: migration.Description})
+	}
+
+	migrated, err := utils.RenderTemplateDocument(doc, utils.DashboardSchema)
+	if err != nil {
+		fatalf("Failed to render migrated template: %v", err)
+	}
+
+	if *dryRun {
+		diff := utils.UnifiedDiff(templatePath, templatePath+" (migrated)", string(original), string(migrated))
+		if jsonOutput() {
+			report.Diff = diff
+			printJSON(report)
+		} else {
+			fmt.Print(diff)
+		}
+		return
+	}
+
+	if err := os.WriteFile(templatePath, migrated, 0644); err != nil {
+		fatalf("Failed to write migrated template: %v", err)
+	}
+
+	printInfo("Migrated %s from schema version %s to %s\n", templatePath, fromVersion, report.ToVersion)
+	if jsonOutput() {
+		report.Written = true
+		printJSON(report)
+	}
+}
@@ -83,80 +83,88 @@ type VisualOptions struct {
 	} `json:"multiStat__slot,omitempty"`
 }
 
-type Dashboard struct {
-	Schema            string `json:"$schema"`
+type Tile struct {
+	Title      string `json:"title"`
+	PageId     string `json:"pageId,omitempty"`
+	Id         string `json:"id"`
+	VisualType string `json:"visualType"`
+	Layout     struct {
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"layout"`
+	QueryRef struct {
+		Kind    string `json:"kind,omitempty"`
+		QueryId string `json:"queryId,omitempty"`
+	} `json:"queryRef,omitempty"`
+	Query         Query         `json:"query,omitempty"`
+	VisualOptions VisualOptions `json:"visualOptions"`
+	MarkdownText  string        `json:"markdownText,omitempty"`
+}
+
+type Parameter struct {
+	Kind              string `json:"kind"`
 	Id                string `json:"id"`
-	IsDashboardEditor bool   `json:"isDashboardEditor"`
-	ETag              string `json:"eTag"`
-	SchemaVersion     string `json:"schema_version"`
-	Title             string `json:"title"`
-	Tiles             []struct {
-		Title      string `json:"title"`
-		PageId     string `json:"pageId,omitempty"`
-		Id         string `json:"id"`
-		VisualType string `json:"visualType"`
-		Layout     struct {
-			X      int `json:"x"`
-			Y      int `json:"y"`
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"layout"`
+	DisplayName       string `json:"displayName"`
+	Description       string `json:"description"`
+	BeginVariableName string `json:"beginVariableName"`
+	EndVariableName   string `json:"endVariableName"`
+	VariableName      string `json:"variableName,omitempty"`
+	SelectionType     string `json:"selectionType,omitempty"`
+	IncludeAllOption  bool   `json:"includeAllOption,omitempty"`
+	DefaultValue      struct {
+		Kind   string   `json:"kind"`
+		Count  int      `json:"count,omitempty"`
+		Unit   string   `json:"unit,omitempty"`
+		Value  string   `json:"value,omitempty"`
+		Values []string `json:"values,omitempty"`
+	} `json:"defaultValue"`
+	DataSource struct {
+		Kind   string `json:"kind,omitempty"`
+		Values []struct {
+			DisplayText string `json:"displayText,omitempty"`
+			Value       string `json:"value,omitempty"`
+		} `json:"values,omitempty"`
+		Columns struct {
+			Value string `json:"value,omitempty"`
+		} `json:"columns,omitempty"`
 		QueryRef struct {
 			Kind    string `json:"kind,omitempty"`
 			QueryId string `json:"queryId,omitempty"`
 		} `json:"queryRef,omitempty"`
-		Query         Query         `json:"query,omitempty"`
-		VisualOptions VisualOptions `json:"visualOptions"`
-		MarkdownText  string        `json:"markdownText,omitempty"`
-	} `json:"tiles"`
-	BaseQueries []interface{} `json:"baseQueries"`
-	Parameters  []struct {
-		Kind              string `json:"kind"`
-		Id                string `json:"id"`
-		DisplayName       string `json:"displayName"`
-		Description       string `json:"description"`
-		BeginVariableName string `json:"beginVariableName"`
-		EndVariableName   string `json:"endVariableName"`
-		VariableName      string `json:"variableName,omitempty"`
-		SelectionType     string `json:"selectionType,omitempty"`
-		IncludeAllOption  bool   `json:"includeAllOption,omitempty"`
-		DefaultValue      struct {
-			Kind   string   `json:"kind"`
-			Count  int      `json:"count,omitempty"`
-			Unit   string   `json:"unit,omitempty"`
-			Value  string   `json:"value,omitempty"`
-			Values []string `json:"values,omitempty"`
-		} `json:"defaultValue"`
-		DataSource struct {
-			Kind   string `json:"kind,omitempty"`
-			Values []struct {
-				DisplayText string `json:"displayText,omitempty"`
-				Value       string `json:"value,omitempty"`
-			} `json:"values,omitempty"`
-			Columns struct {
-				Value string `json:"value,omitempty"`
-			} `json:"columns,omitempty"`
-			QueryRef struct {
-				Kind    string `json:"kind,omitempty"`
-				QueryId string `json:"queryId,omitempty"`
-			} `json:"queryRef,omitempty"`
-		} `json:"dataSource,omitempty"`
-		ShowOnPages struct {
-			Kind    string   `json:"kind"`
-			PageIds []string `json:"pageIds,omitempty"`
-		} `json:"showOnPages"`
-	} `json:"parameters"`
-	DataSources []struct {
-		Id         string `json:"id"`
-		Kind       string `json:"kind"`
-		ScopeId    string `json:"scopeId"`
-		Name       string `json:"name"`
-		ClusterUri string `json:"clusterUri"`
-		Database   string `json:"database"`
-	} `json:"dataSources"`
-	Pages []struct {
-		Id   string `json:"id"`
-		Name string `json:"name"`
-	} `json:"pages"`
-	Queries []Query `json:"queries"`
+	} `json:"dataSource,omitempty"`
+	ShowOnPages struct {
+		Kind    string   `json:"kind"`
+		PageIds []string `json:"pageIds,omitempty"`
+	} `json:"showOnPages"`
+}
+
+type DataSource struct {
+	Id         string `json:"id"`
+	Kind       string `json:"kind"`
+	ScopeId    string `json:"scopeId"`
+	Name       string `json:"name"`
+	ClusterUri string `json:"clusterUri"`
+	Database   string `json:"database"`
+}
+
+type Page struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type Dashboard struct {
+	Schema            string        `json:"$schema"`
+	Id                string        `json:"id"`
+	IsDashboardEditor bool          `json:"isDashboardEditor"`
+	ETag              string        `json:"eTag"`
+	SchemaVersion     string        `json:"schema_version"`
+	Title             string        `json:"title"`
+	Tiles             []Tile        `json:"tiles"`
+	BaseQueries       []interface{} `json:"baseQueries"`
+	Parameters        []Parameter   `json:"parameters"`
+	DataSources       []DataSource  `json:"dataSources"`
+	Pages             []Page        `json:"pages"`
+	Queries           []Query       `json:"queries"`
 }
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration upgrades a raw dashboard document from any older schema version to Version
+type Migration struct {
+	Version     string
+	Description string
+	Apply       func(doc map[string]interface{}) error
+}
+
+// migrations lists the known upgrade steps, each one bringing a document up to its Version. Steps only change what
+// lacks the shape they produce, so a document whose schema_version is behind its content still migrates correctly.
+var migrations = []Migration{
+	{
+		Version:     "44",
+		Description: "move inline tile queries into the shared queries list",
+		Apply:       hoistTileQueries,
+	},
+	{
+		Version:     "52",
+		Description: "ensure baseQueries, parameters, dataSources, pages and queries lists are present",
+		Apply:       ensureTopLevelLists,
+	},
+}
+
+var schemaURLVersionRegex = regexp.MustCompile(`/schema/[^/]+/`)
+
+func init() {
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return CompareSchemaVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+}
+
+// LatestSchemaVersion returns the newest schema version the migrations know about
+func LatestSchemaVersion() string {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersionOf returns the schema version of a raw dashboard document
+func SchemaVersionOf(doc map[string]interface{}) string {
+	switch v := doc["schema_version"].(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.Itoa(int(v))
+	}
+	return ""
+}
+
+// Migrate upgrades the document in place step by step to the latest schema version and returns the applied steps. A
+// document at a version newer than the latest known one is left as it is, the service having migrated it already.
+func Migrate(doc map[string]interface{}) ([]Migration, error) {
+	version := SchemaVersionOf(doc)
+	if version == "" {
+		return nil, fmt.Errorf("document has no schema_version")
+	}
+	if !validSchemaVersion(version) {
+		return nil, fmt.Errorf("unrecognized schema_version %q", version)
+	}
+
+	var applied []Migration
+	for _, migration := range migrations {
+		if CompareSchemaVersions(migration.Version, version) <= 0 {
+			continue
+		}
+
+		if err := migration.Apply(doc); err != nil {
+			return applied, fmt.Errorf("error migrating to schema version %s: %w", migration.Version, err)
+		}
+
+		setSchemaVersion(doc, migration.Version)
+		version = migration.Version
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// IsNewerSchemaVersion reports whether version is newer than the latest version the migrations know about
+func IsNewerSchemaVersion(version string) bool {
+	return validSchemaVersion(version) && CompareSchemaVersions(version, LatestSchemaVersion()) > 0
+}
+
+// CompareSchemaVersions compares two schema versions numerically, by dot separated parts as in 52 or 52.1, and returns
+// -1, 0 or 1 when a is older than, the same as or newer than b. Missing parts count as 0.
+func CompareSchemaVersions(a, b string) int {
+	aParts, bParts := schemaVersionParts(a), schemaVersionParts(b)
+	for i := 0; i < max(len(aParts), len(bParts)); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// setSchemaVersion updates both schema_version and the version segment of the $schema URL
+func setSchemaVersion(doc map[string]interface{}, version string) {
+	doc["schema_version"] = version
+	if schema, ok := doc["$schema"].(string); ok {
+		doc["$schema"] = schemaURLVersionRegex.ReplaceAllString(schema, "/schema/"+version+"/")
+	}
+}
+
+// schemaVersionParts returns the numbers of a schema version, nil when it is not made of numbers
+func schemaVersionParts(version string) []int {
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func validSchemaVersion(version string) bool {
+	return schemaVersionParts(version) != nil
+}
+
+// hoistTileQueries replaces inline tile queries with queryRef entries pointing into the queries list
+func hoistTileQueries(doc map[string]interface{}) error {
+	tiles, _ := doc["tiles"].([]interface{})
+	queries, _ := doc["queries"].([]interface{})
+
+	for i, rawTile := range tiles {
+		tile, ok := rawTile.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("tile %d is not an object", i)
+		}
+
+		query, ok := tile["query"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		tileId, _ := tile["id"].(string)
+		if tileId == "" {
+			return fmt.Errorf("tile %d has an inline query but no id", i)
+		}
+
+		queryId, _ := query["id"].(string)
+		if queryId == "" {
+			queryId = tileId + "-query"
+			query["id"] = queryId
+		}
+
+		queries = append(queries, query)
+		delete(tile, "query")
+		tile["queryRef"] = map[string]interface{}{
+			"kind":    "query",
+			"queryId": queryId,
+		}
+	}
+
+	doc["queries"] = queries
+	return nil
+}
+
+// ensureTopLevelLists adds empty lists for top level collections missing from older documents
+func ensureTopLevelLists(doc map[string]interface{}) error {
+	for _, key := range []string{"tiles", "baseQueries", "parameters", "dataSources", "pages", "queries"} {
+		if doc[key] == nil {
+			doc[key] = []interface{}{}
+		}
+	}
+	return nil
+}
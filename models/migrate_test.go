@@ -0,0 +1,166 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompareSchemaVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"44", "52", -1},
+		{"52", "52", 0},
+		{"60", "52", 1},
+		{"9", "10", -1},
+		{"52.1", "52", 1},
+		{"52.0", "52", 0},
+		{"52", "52.2", -1},
+	}
+	for _, tt := range tests {
+		if got := CompareSchemaVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareSchemaVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsNewerSchemaVersion(t *testing.T) {
+	for version, want := range map[string]bool{"20": false, LatestSchemaVersion(): false, "53": true, "100": true, "v53": false} {
+		if got := IsNewerSchemaVersion(version); got != want {
+			t.Errorf("IsNewerSchemaVersion(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestMigrationSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(map[string]interface{}) error
+		doc   string
+		want  string
+	}{
+		{
+			name:  "inline tile query",
+			apply: hoistTileQueries,
+			doc:   `{"tiles": [{"id": "t1", "query": {"text": "T"}}], "queries": []}`,
+			want:  `{"tiles": [{"id": "t1", "queryRef": {"kind": "query", "queryId": "t1-query"}}], "queries": [{"id": "t1-query", "text": "T"}]}`,
+		},
+		{
+			name:  "inline tile query with an id",
+			apply: hoistTileQueries,
+			doc:   `{"tiles": [{"id": "t1", "query": {"id": "q1", "text": "T"}}, {"id": "t2", "markdownText": "x"}]}`,
+			want:  `{"tiles": [{"id": "t1", "queryRef": {"kind": "query", "queryId": "q1"}}, {"id": "t2", "markdownText": "x"}], "queries": [{"id": "q1", "text": "T"}]}`,
+		},
+		{
+			name:  "tile queries already shared",
+			apply: hoistTileQueries,
+			doc:   `{"tiles": [{"id": "t1", "queryRef": {"kind": "query", "queryId": "q1"}}], "queries": [{"id": "q1"}]}`,
+			want:  `{"tiles": [{"id": "t1", "queryRef": {"kind": "query", "queryId": "q1"}}], "queries": [{"id": "q1"}]}`,
+		},
+		{
+			name:  "missing lists",
+			apply: ensureTopLevelLists,
+			doc:   `{"tiles": [{"id": "t1"}]}`,
+			want:  `{"tiles": [{"id": "t1"}], "baseQueries": [], "parameters": [], "dataSources": [], "pages": [], "queries": []}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := parseDoc(t, tt.doc)
+			if err := tt.apply(doc); err != nil {
+				t.Fatalf("migration returned error: %v", err)
+			}
+			if want := parseDoc(t, tt.want); !reflect.DeepEqual(doc, want) {
+				t.Errorf("migrated = %v, want %v", doc, want)
+			}
+		})
+	}
+}
+
+func TestMigrationStepErrors(t *testing.T) {
+	for _, doc := range []string{
+		`{"tiles": ["t1"]}`,
+		`{"tiles": [{"query": {"text": "T"}}]}`,
+	} {
+		if err := hoistTileQueries(parseDoc(t, doc)); err == nil {
+			t.Errorf("hoistTileQueries(%s) succeeded, want an error", doc)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		doc         string
+		wantApplied []string
+		wantVersion string
+	}{
+		{
+			name:        "oldest",
+			doc:         `{"$schema": "https://dataexplorer.azure.com/static/d/schema/20/dashboard.json", "schema_version": "20", "tiles": [{"id": "t1", "query": {"text": "T"}}]}`,
+			wantApplied: []string{"44", "52"},
+			wantVersion: "52",
+		},
+		{
+			name:        "between steps",
+			doc:         `{"schema_version": 44, "tiles": []}`,
+			wantApplied: []string{"52"},
+			wantVersion: "52",
+		},
+		{
+			name:        "latest",
+			doc:         `{"schema_version": "52", "tiles": []}`,
+			wantVersion: "52",
+		},
+		{
+			name:        "newer than known",
+			doc:         `{"schema_version": "57", "tiles": [{"id": "t1", "query": {"text": "T"}}]}`,
+			wantVersion: "57",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := parseDoc(t, tt.doc)
+			before := parseDoc(t, tt.doc)
+			applied, err := Migrate(doc)
+			if err != nil {
+				t.Fatalf("Migrate returned error: %v", err)
+			}
+			var versions []string
+			for _, migration := range applied {
+				versions = append(versions, migration.Version)
+			}
+			if !reflect.DeepEqual(versions, tt.wantApplied) {
+				t.Errorf("applied = %v, want %v", versions, tt.wantApplied)
+			}
+			if got := SchemaVersionOf(doc); got != tt.wantVersion {
+				t.Errorf("schema_version = %s, want %s", got, tt.wantVersion)
+			}
+			if len(applied) == 0 && !reflect.DeepEqual(doc, before) {
+				t.Errorf("Migrate changed a document it applied nothing to: %v", doc)
+			}
+			if schema, ok := doc["$schema"].(string); ok && schema != "https://dataexplorer.azure.com/static/d/schema/"+tt.wantVersion+"/dashboard.json" {
+				t.Errorf("$schema = %s, want the URL of version %s", schema, tt.wantVersion)
+			}
+		})
+	}
+
+	for _, doc := range []string{`{"tiles": []}`, `{"schema_version": "latest"}`} {
+		if _, err := Migrate(parseDoc(t, doc)); err == nil {
+			t.Errorf("Migrate(%s) succeeded, want an error", doc)
+		}
+	}
+}
+
+func parseDoc(t *testing.T, text string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/git"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// Error codes of the errors printed with --output json, for scripts to tell failures apart
+const (
+	Error_Usage         = "usage"
+	Error_Config        = "config"
+	Error_Invalid       = "invalid"
+	Error_NotFound      = "not_found"
+	Error_Ambiguous     = "ambiguous"
+	Error_Unauthorized  = "unauthorized"
+	Error_Conflict      = "conflict"
+	Error_RequestFailed = "request_failed"
+	Error_LocalChanges  = "local_changes"
+	Error_NoPullState   = "no_pull_state"
+	Error_NotRepository = "not_repository"
+	Error_QueryFailed   = "query_failed"
+	Error_Interrupted   = "interrupted"
+	Error_Failed        = "failed"
+)
+
+// logLevel is the level of the log, set from --verbose and --quiet
+var logLevel = new(slog.LevelVar)
+
+// configureLogging sets up the default logger writing to stderr, as text or as JSON lines
+func configureLogging() {
+	switch {
+	case globalOptions.verbose:
+		logLevel.Set(slog.LevelDebug)
+	case globalOptions.quiet:
+		logLevel.Set(slog.LevelWarn)
+	default:
+		logLevel.Set(slog.LevelInfo)
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if globalOptions.logFormat == Output_JSON || globalOptions.logFormat == "" && jsonOutput() {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// errorReport is what a command failing prints with --output json
+type errorReport struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Files are the files the error is about, such as the files modified locally a pull would overwrite
+	Files []string `json:"files,omitempty"`
+}
+
+// fatalf logs the failure of a command and exits, the code of the error being the one of the first error in args
+func fatalf(format string, args ...interface{}) {
+	var cause error
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			cause = err
+			break
+		}
+	}
+	fatalCodef(errorCode(cause), format, args...)
+}
+
+// fatalCodef logs the failure of a command with the given error code and exits
+func fatalCodef(code string, format string, args ...interface{}) {
+	exitError(errorDetail{Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// exitError logs an error, prints it to stdout as well with --output json, and exits with the exit code of its code
+func exitError(detail errorDetail) {
+	slog.Error(detail.Message, "code", detail.Code)
+	if jsonOutput() {
+		printJSON(errorReport{Error: detail})
+	}
+
+	switch detail.Code {
+	case Error_Usage:
+		exitWithError(Exit_Usage, detail)
+	case Error_Interrupted:
+		exitWithError(Exit_Interrupted, detail)
+	default:
+		exitWithError(Exit_Failure, detail)
+	}
+}
+
+// failf logs that a command failed once it printed its result, and exits with Exit_Failure
+func failf(format string, args ...interface{}) {
+	message := logMessage(format, args...)
+	slog.Error(message)
+	exitWithError(Exit_Failure, errorDetail{Code: Error_Failed, Message: message})
+}
+
+// errorCode returns the code of an error for --output json, from the status code of the request that failed or the
+// error it wraps
+func errorCode(err error) string {
+	var statusError *dataexplorer.StatusError
+	switch {
+	case err == nil:
+		return Error_Failed
+	case errors.As(err, &statusError):
+		switch statusError.StatusCode {
+		case 401, 403:
+			return Error_Unauthorized
+		case 404:
+			return Error_NotFound
+		case 409, 412:
+			return Error_Conflict
+		default:
+			return Error_RequestFailed
+		}
+	case errors.Is(err, workspace.ErrInterrupted):
+		return Error_Interrupted
+	case errors.Is(err, workspace.ErrLocalChanges):
+		return Error_LocalChanges
+	case errors.Is(err, workspace.ErrInvalid):
+		return Error_Invalid
+	case errors.Is(err, workspace.ErrNoPullState):
+		return Error_NoPullState
+	case errors.Is(err, workspace.ErrSnapshotAmbiguous):
+		return Error_Ambiguous
+	case errors.Is(err, workspace.ErrSnapshotNotFound), errors.Is(err, workspace.ErrSelectionNotFound), errors.Is(err, fs.ErrNotExist):
+		return Error_NotFound
+	case errors.Is(err, workspace.ErrUnknownLayout):
+		return Error_Config
+	case errors.Is(err, git.ErrNotRepository):
+		return Error_NotRepository
+	default:
+		return Error_Failed
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+const Preview_Output_Path = "bin/preview"
+
+// previewReport is the --output json form of preview
+type previewReport struct {
+	Pages []previewPage `json:"pages"`
+}
+
+type previewPage struct {
+	Page string `json:"page"`
+	Path string `json:"path"`
+}
+
+// PreviewDashboard renders the markdown tiles of every page of the local template to HTML files
+func PreviewDashboard(args []string) {
+	flags := newFlagSet("preview")
+	layout := flags.String("layout", localLayout(), "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	outputDir := flags.String("out", Preview_Output_Path, "directory the HTML pages are written to")
+	parseFlags(flags, args)
+
+	dashboard, err := loadProcessedDashboard(*layout, values)
+	if err != nil {
+		fatalf("Failed to load dashboard: %v", err)
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fatalf("Failed to create preview directory: %v", err)
+	}
+
+	report := previewReport{Pages: []previewPage{}}
+	for pageIndex, page := range dashboard.Pages {
+		var tiles []models.Tile
+		for _, tile := range dashboard.Tiles {
+			if tile.PageId == page.Id && tile.VisualType == "markdownCard" {
+				tiles = append(tiles, tile)
+			}
+		}
+		if len(tiles) == 0 {
+			continue
+		}
+
+		// Render tiles in reading order of the dashboard grid
+		sort.SliceStable(tiles, func(i, j int) bool {
+			if tiles[i].Layout.Y != tiles[j].Layout.Y {
+				return tiles[i].Layout.Y < tiles[j].Layout.Y
+			}
+			return tiles[i].Layout.X < tiles[j].Layout.X
+		})
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s - %s</title>\n", html.EscapeString(dashboard.Title), html.EscapeString(page.Name))
+		sb.WriteString("<style>body{font-family:sans-serif;margin:2em}section{border:1px solid #ddd;border-radius:4px;padding:1em;margin-bottom:1em}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px}</style>\n")
+		fmt.Fprintf(&sb, "</head>\n<body>\n<h1>%s</h1>\n", html.EscapeString(page.Name))
+		for _, tile := range tiles {
+			fmt.Fprintf(&sb, "<section id=\"%s\">\n", html.EscapeString(tile.Id))
+			if tile.Title != "" {
+				fmt.Fprintf(&sb, "<h2>%s</h2>\n", html.EscapeString(tile.Title))
+			}
+			sb.WriteString(utils.RenderMarkdown(tile.MarkdownText))
+			sb.WriteString("</section>\n")
+		}
+		sb.WriteString("</body>\n</html>\n")
+
+		outputPath := filepath.Join(*outputDir, fmt.Sprintf("%02d_%s.html", pageIndex+1, strings.ReplaceAll(page.Name, " ", "_")))
+		if err := os.WriteFile(outputPath, []byte(sb.String()), 0644); err != nil {
+			fatalf("Failed to write preview: %v", err)
+		}
+
+		printInfo("Preview of page %s written to %s\n", page.Name, outputPath)
+		report.Pages = append(report.Pages, previewPage{Page: page.Name, Path: outputPath})
+	}
+
+	if jsonOutput() {
+		printJSON(report)
+	}
+}
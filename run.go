@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+const Table_Max_Cell_Width = 60
+
+// RunQuery runs the query of a tile, or of a query file, against the tile's data source and prints the result
+func RunQuery(args []string) {
+	flags := newFlagSet("run", Output_Table, Output_CSV, Output_JSON)
+	layout := flags.String("layout", localLayout(), "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	dataSourceName := flags.String("datasource", "", "id or name of the data source to run a query file against")
+	showQuery := flags.Bool("show-query", false, "print the query sent to the cluster before the result")
+	parseFlags(flags, args)
+
+	if flags.NArg() != 1 {
+		usageError(flags, "Expected one tile id, tile title or query file")
+	}
+	target := flags.Arg(0)
+
+	dashboard, err := loadProcessedDashboard(*layout, values)
+	if err != nil {
+		fatalf("Failed to load dashboard: %v", err)
+	}
+
+	query, err := findTargetQuery(dashboard, target)
+	if err != nil {
+		fatalf("Failed to find query: %v", err)
+	}
+
+	if *dataSourceName != "" {
+		query.DataSource.DataSourceId = *dataSourceName
+		for _, dataSource := range dashboard.DataSources {
+			if dataSource.Name == *dataSourceName {
+				query.DataSource.DataSourceId = dataSource.Id
+			}
+		}
+	}
+
+	dataSource, err := utils.QueryDataSource(dashboard, query)
+	if err != nil {
+		fatalf("Failed to find data source: %v", err)
+	}
+
+	clients := &kustoClients{}
+	text, err := newParameterResolver(dashboard, clients).Expand(query)
+	if err != nil {
+		fatalf("Failed to bind query variables: %v", err)
+	}
+
+	if *showQuery {
+		fmt.Fprintf(os.Stderr, "%s\n\n", text)
+	}
+
+	result, err := clients.get(dataSource.ClusterUri).QueryContext(commandContext, dataSource.ClusterUri, dataSource.Database, text)
+	if err != nil {
+		fatalf("Query failed on %s/%s: %v", strings.TrimSuffix(dataSource.ClusterUri, "/"), dataSource.Database, err)
+	}
+
+	if err := printQueryResult(result, globalOptions.output); err != nil {
+		fatalf("Failed to print result: %v", err)
+	}
+}
+
+// findTargetQuery returns the query of a tile given by id or title, or of a query file
+func findTargetQuery(dashboard *models.Dashboard, target string) (*models.Query, error) {
+	if content, err := os.ReadFile(target); err == nil {
+		return utils.QueryForText(dashboard, string(content)), nil
+	}
+
+	tile, err := utils.FindTile(dashboard, target)
+	if err != nil {
+		return nil, err
+	}
+	return utils.TileQuery(dashboard, tile)
+}
+
+// kustoClients lazily creates one Kusto client per cluster
+type kustoClients struct {
+	mu      sync.Mutex
+	clients map[string]*dataexplorer.KustoClient
+}
+
+func (k *kustoClients) get(clusterUri string) *dataexplorer.KustoClient {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.clients == nil {
+		k.clients = map[string]*dataexplorer.KustoClient{}
+	}
+	if k.clients[clusterUri] == nil {
+		k.clients[clusterUri] = dataexplorer.NewKustoClient(kustoTokenProvider(clusterUri))
+		traceClient(k.clients[clusterUri].Client)
+	}
+	return k.clients[clusterUri]
+}
+
+// newParameterResolver creates a resolver for the dashboard parameters, running the queries of query backed
+// parameters through the clients unless they are nil
+func newParameterResolver(dashboard *models.Dashboard, clients *kustoClients) *utils.ParameterResolver {
+	resolver := utils.NewParameterResolver(dashboard)
+	if clients != nil {
+		resolver.RunQuery = func(query *models.Query, text string) (*dataexplorer.QueryResult, error) {
+			dataSource, err := utils.QueryDataSource(dashboard, query)
+			if err != nil {
+				return nil, err
+			}
+			return clients.get(dataSource.ClusterUri).QueryContext(commandContext, dataSource.ClusterUri, dataSource.Database, text)
+		}
+	}
+	return resolver
+}
+
+// kustoTokenProvider returns KUSTO_ACCESS_TOKEN when set, or else tokens for the cluster from the Azure CLI
+func kustoTokenProvider(clusterUri string) dataexplorer.TokenProvider {
+	if token := os.Getenv("KUSTO_ACCESS_TOKEN"); token != "" {
+		return dataexplorer.StaticToken("Bearer " + token)
+	}
+	return dataexplorer.NewAzureCLIToken(clusterUri)
+}
+
+// printQueryResult writes a query result to stdout in the given format
+func printQueryResult(result *dataexplorer.QueryResult, format string) error {
+	switch format {
+	case Output_Table:
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		var header, separator []string
+		for _, column := range result.Columns {
+			header = append(header, column.Name)
+			separator = append(separator, strings.Repeat("-", len(column.Name)))
+		}
+		fmt.Fprintln(writer, strings.Join(header, "\t"))
+		fmt.Fprintln(writer, strings.Join(separator, "\t"))
+		for _, row := range result.Rows {
+			cells := make([]string, len(row))
+			for i, value := range row {
+				cells[i] = truncateCell(formatCell(value))
+			}
+			fmt.Fprintln(writer, strings.Join(cells, "\t"))
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("\n%d rows\n", len(result.Rows))
+		return nil
+
+	case Output_CSV:
+		writer := csv.NewWriter(os.Stdout)
+		var header []string
+		for _, column := range result.Columns {
+			header = append(header, column.Name)
+		}
+		writer.Write(header)
+		for _, row := range result.Rows {
+			cells := make([]string, len(row))
+			for i, value := range row {
+				cells[i] = formatCell(value)
+			}
+			writer.Write(cells)
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case Output_JSON:
+		records := make([]map[string]interface{}, 0, len(result.Rows))
+		for _, row := range result.Rows {
+			record := map[string]interface{}{}
+			for i, column := range result.Columns {
+				if i < len(row) {
+					record[column.Name] = row[i]
+				}
+			}
+			records = append(records, record)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+
+	return fmt.Errorf("unknown output format %q, expected %s, %s or %s", format, Output_Table, Output_CSV, Output_JSON)
+}
+
+// formatCell formats a result value for table and CSV output
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func truncateCell(cell string) string {
+	cell = strings.ReplaceAll(cell, "\n", " ")
+	if runes := []rune(cell); len(runes) > Table_Max_Cell_Width {
+		return string(runes[:Table_Max_Cell_Width-1]) + "…"
+	}
+	return cell
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/omeshp/kusto-dashboards-sync/telemetry"
+)
+
+var (
+	// commandSpan is the span of the command running, nil without telemetry
+	commandSpan *telemetry.Span
+	// commandContext carries commandSpan, the parent of the spans of the command
+	commandContext = context.Background()
+)
+
+// setupTelemetry exports the traces and metrics of the command with --otlp-endpoint or OTEL_EXPORTER_OTLP_ENDPOINT,
+// and with --otlp-file, and starts the span of the command. Without either nothing is recorded.
+func setupTelemetry(cmd *command) {
+	endpoint := globalOptions.otlpEndpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" && globalOptions.otlpFile == "" {
+		return
+	}
+
+	headers, err := telemetry.ParseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		fatalCodef(Error_Config, "Invalid OTEL_EXPORTER_OTLP_HEADERS: %v", err)
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = Program_Name
+	}
+	var version string
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+
+	err = telemetry.Setup(telemetry.Options{
+		ServiceName:    serviceName,
+		ServiceVersion: version,
+		Endpoint:       endpoint,
+		Headers:        headers,
+		File:           globalOptions.otlpFile,
+	})
+	if err != nil {
+		fatalCodef(Error_Config, "Failed to set up telemetry: %v", err)
+	}
+	commandContext, commandSpan = telemetry.Start(context.Background(), Program_Name+" "+cmd.name, "command", cmd.name, "workspace", workingDirectory())
+}
+
+// exit ends the span of the command, exports the telemetry recorded and exits with code
+func exit(code int) {
+	var err error
+	if code != 0 {
+		err = fmt.Errorf("exit status %d", code)
+	}
+	endCommandSpan(code, err)
+	telemetry.Shutdown()
+	os.Exit(code)
+}
+
+// exitWithError ends the span of the command failed with the error reported and exits with code
+func exitWithError(code int, detail errorDetail) {
+	commandSpan.SetAttributes("error.type", detail.Code)
+	endCommandSpan(code, errors.New(detail.Message))
+	exit(code)
+}
+
+// endCommandSpan ends the span of the command with the code it exits with, failed with err unless nil
+func endCommandSpan(code int, err error) {
+	commandSpan.SetAttributes("process.exit.code", code)
+	commandSpan.End(err)
+}
@@ -0,0 +1,127 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// Duration_Buckets are the bucket bounds of histograms of durations in seconds, the ones OpenTelemetry recommends for
+// HTTP requests
+var Duration_Buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// metric is a counter or a histogram, aggregated per set of attributes since the start of the process
+type metric struct {
+	name        string
+	description string
+	unit        string
+	// bounds are the bucket bounds of a histogram, nil for a counter
+	bounds []float64
+
+	mu     sync.Mutex
+	points map[string]*point
+}
+
+// point is the aggregate of the measurements of a metric sharing the same attributes
+type point struct {
+	attributes []keyValue
+	count      int64
+	sum        float64
+	min, max   float64
+	buckets    []int64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*metric
+)
+
+// Counter counts occurrences, such as dashboards synced
+type Counter struct {
+	metric *metric
+}
+
+// Histogram records the distribution of values, such as the duration of requests
+type Histogram struct {
+	metric *metric
+}
+
+// NewCounter registers a counter, meant to be called when initialising package variables
+func NewCounter(name, description, unit string) *Counter {
+	return &Counter{metric: register(name, description, unit, nil)}
+}
+
+// NewHistogram registers a histogram with the given bucket bounds, meant to be called when initialising package
+// variables
+func NewHistogram(name, description, unit string, bounds []float64) *Histogram {
+	return &Histogram{metric: register(name, description, unit, bounds)}
+}
+
+func register(name, description, unit string, bounds []float64) *metric {
+	m := &metric{name: name, description: description, unit: unit, bounds: bounds, points: map[string]*point{}}
+	registryMu.Lock()
+	registry = append(registry, m)
+	registryMu.Unlock()
+	return m
+}
+
+// Add adds value to the counter for the attributes attrs, key value pairs as with slog
+func (c *Counter) Add(value int64, attrs ...any) {
+	if current() == nil {
+		return
+	}
+	c.metric.record(float64(value), attrs)
+}
+
+// Record records value in the histogram for the attributes attrs, key value pairs as with slog
+func (h *Histogram) Record(value float64, attrs ...any) {
+	if current() == nil {
+		return
+	}
+	h.metric.record(value, attrs)
+}
+
+func (m *metric) record(value float64, attrs []any) {
+	kvs := attributes(attrs)
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	key, _ := json.Marshal(kvs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.points[string(key)]
+	if !ok {
+		p = &point{attributes: kvs, min: value, max: value}
+		if m.bounds != nil {
+			p.buckets = make([]int64, len(m.bounds)+1)
+		}
+		m.points[string(key)] = p
+	}
+
+	p.count++
+	p.sum += value
+	p.min = min(p.min, value)
+	p.max = max(p.max, value)
+	if m.bounds != nil {
+		// A value lands in the first bucket whose upper bound it does not exceed, the last bucket being unbounded
+		bucket := sort.SearchFloat64s(m.bounds, value)
+		p.buckets[bucket]++
+	}
+}
+
+// snapshot returns a copy of the points of the metric, so it can be encoded while measurements go on
+func (m *metric) snapshot() []point {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	points := make([]point, 0, len(m.points))
+	for _, p := range m.points {
+		copied := *p
+		copied.buckets = append([]int64(nil), p.buckets...)
+		points = append(points, copied)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		a, _ := json.Marshal(points[i].attributes)
+		b, _ := json.Marshal(points[j].attributes)
+		return string(a) < string(b)
+	})
+	return points
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	m := &metric{name: "test.duration", bounds: []float64{0.1, 1}, points: map[string]*point{}}
+	// Bounds are inclusive upper bounds, values above the last one land in the unbounded bucket
+	for _, value := range []float64{0.05, 0.1, 0.5, 1, 3} {
+		m.record(value, []any{"server.address", "example.com"})
+	}
+
+	points := m.snapshot()
+	if len(points) != 1 {
+		t.Fatalf("points = %+v, want one for the attributes recorded", points)
+	}
+	p := points[0]
+	if p.count != 5 || math.Abs(p.sum-4.65) > 1e-9 || p.min != 0.05 || p.max != 3 {
+		t.Errorf("count, sum, min, max = %d, %g, %g, %g, want 5, 4.65, 0.05, 3", p.count, p.sum, p.min, p.max)
+	}
+	if want := []int64{2, 2, 1}; !reflect.DeepEqual(p.buckets, want) {
+		t.Errorf("buckets = %v, want %v", p.buckets, want)
+	}
+}
+
+func TestCounterPoints(t *testing.T) {
+	m := &metric{name: "test.synced", points: map[string]*point{}}
+	// Attributes given in another order are the same point
+	m.record(1, []any{"operation", "pull", "mode", "full"})
+	m.record(2, []any{"mode", "full", "operation", "pull"})
+	m.record(1, []any{"operation", "push"})
+
+	points := m.snapshot()
+	if len(points) != 2 {
+		t.Fatalf("points = %+v, want one per set of attributes", points)
+	}
+	sums := map[string]float64{}
+	for _, p := range points {
+		if p.buckets != nil {
+			t.Errorf("counter point has buckets %v", p.buckets)
+		}
+		for _, kv := range p.attributes {
+			if kv.Key == "operation" {
+				sums[*kv.Value.StringValue] = p.sum
+			}
+		}
+	}
+	if want := map[string]float64{"pull": 3, "push": 1}; !reflect.DeepEqual(sums, want) {
+		t.Errorf("sums = %v, want %v", sums, want)
+	}
+}
+
+func TestSnapshotCopiesBuckets(t *testing.T) {
+	m := &metric{name: "test.duration", bounds: []float64{1}, points: map[string]*point{}}
+	m.record(0.5, nil)
+	points := m.snapshot()
+	m.record(0.5, nil)
+
+	if points[0].count != 1 || points[0].buckets[0] != 1 {
+		t.Errorf("snapshot = %+v changed by a later measurement", points[0])
+	}
+}
+
+func TestMeasurementsWithoutSetup(t *testing.T) {
+	counter := &Counter{metric: &metric{name: "test.counter", points: map[string]*point{}}}
+	counter.Add(1)
+	if points := counter.metric.snapshot(); len(points) != 0 {
+		t.Errorf("points = %+v recorded without Setup, want none", points)
+	}
+}
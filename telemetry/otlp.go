@@ -0,0 +1,292 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signals exported, named as in the paths of OTLP/HTTP
+const (
+	signalTraces  = "traces"
+	signalMetrics = "metrics"
+)
+
+// scopeName is the instrumentation scope of the spans and metrics
+const scopeName = "github.com/omeshp/kusto-dashboards-sync"
+
+// OTLP status code of a span that failed
+const statusError = 2
+
+// OTLP aggregation temporality of metrics aggregated since the start of the process
+const temporalityCumulative = 2
+
+// keyValue is an attribute in OTLP JSON
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue is the value of an attribute in OTLP JSON, where 64 bit integers are strings
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// attributes converts key value pairs, as given to slog, to OTLP attributes. Values other than strings, numbers and
+// booleans are formatted as strings.
+func attributes(kvs []any) []keyValue {
+	var converted []keyValue
+	for i := 0; i+1 < len(kvs); i += 2 {
+		key, ok := kvs[i].(string)
+		if !ok {
+			key = fmt.Sprint(kvs[i])
+		}
+
+		var value anyValue
+		switch v := kvs[i+1].(type) {
+		case string:
+			value.StringValue = &v
+		case bool:
+			value.BoolValue = &v
+		case int:
+			value.IntValue = integer(int64(v))
+		case int64:
+			value.IntValue = integer(v)
+		case float64:
+			value.DoubleValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		converted = append(converted, keyValue{Key: key, Value: value})
+	}
+	return converted
+}
+
+func integer(v int64) *string {
+	s := strconv.FormatInt(v, 10)
+	return &s
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// encodeTraces returns the OTLP JSON export request of spans
+func encodeTraces(res []keyValue, spans []*Span) []byte {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.traceID[:]),
+			SpanID:            hex.EncodeToString(span.spanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: unixNano(span.start),
+			EndTimeUnixNano:   unixNano(span.end),
+			Attributes:        span.attributes,
+		}
+		if span.parentID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		if span.err != nil {
+			s.Status = otlpStatus{Code: statusError, Message: span.err.Error()}
+		}
+		span.mu.Unlock()
+		encoded = append(encoded, s)
+	}
+
+	request := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource":   resource{Attributes: res},
+			"scopeSpans": []any{map[string]any{"scope": scope{Name: scopeName}, "spans": encoded}},
+		}},
+	}
+	data, _ := json.Marshal(request)
+	return data
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             string     `json:"asInt"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+	Min               float64    `json:"min"`
+	Max               float64    `json:"max"`
+}
+
+// encodeMetrics returns the OTLP JSON export request of the metrics measured since start, or nil when none was
+func encodeMetrics(res []keyValue, start, now time.Time) []byte {
+	registryMu.Lock()
+	metrics := append([]*metric(nil), registry...)
+	registryMu.Unlock()
+
+	var encoded []any
+	for _, m := range metrics {
+		points := m.snapshot()
+		if len(points) == 0 {
+			continue
+		}
+
+		entry := map[string]any{"name": m.name, "description": m.description, "unit": m.unit}
+		if m.bounds == nil {
+			dataPoints := make([]numberDataPoint, 0, len(points))
+			for _, p := range points {
+				dataPoints = append(dataPoints, numberDataPoint{
+					Attributes:        p.attributes,
+					StartTimeUnixNano: unixNano(start),
+					TimeUnixNano:      unixNano(now),
+					AsInt:             strconv.FormatInt(int64(p.sum), 10),
+				})
+			}
+			entry["sum"] = map[string]any{"dataPoints": dataPoints, "aggregationTemporality": temporalityCumulative, "isMonotonic": true}
+		} else {
+			dataPoints := make([]histogramDataPoint, 0, len(points))
+			for _, p := range points {
+				buckets := make([]string, len(p.buckets))
+				for i, count := range p.buckets {
+					buckets[i] = strconv.FormatInt(count, 10)
+				}
+				dataPoints = append(dataPoints, histogramDataPoint{
+					Attributes:        p.attributes,
+					StartTimeUnixNano: unixNano(start),
+					TimeUnixNano:      unixNano(now),
+					Count:             strconv.FormatInt(p.count, 10),
+					Sum:               p.sum,
+					BucketCounts:      buckets,
+					ExplicitBounds:    m.bounds,
+					Min:               p.min,
+					Max:               p.max,
+				})
+			}
+			entry["histogram"] = map[string]any{"dataPoints": dataPoints, "aggregationTemporality": temporalityCumulative}
+		}
+		encoded = append(encoded, entry)
+	}
+	if encoded == nil {
+		return nil
+	}
+
+	request := map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource":     resource{Attributes: res},
+			"scopeMetrics": []any{map[string]any{"scope": scope{Name: scopeName}, "metrics": encoded}},
+		}},
+	}
+	data, _ := json.Marshal(request)
+	return data
+}
+
+// httpExporter posts to an OTLP/HTTP collector
+type httpExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+func (e *httpExporter) export(signal string, payload []byte) error {
+	target := strings.TrimSuffix(e.endpoint, "/") + "/v1/" + signal
+	req, err := http.NewRequest("POST", target, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating request to %s: %w", target, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting to %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("error posting to %s: status code %d: %s", target, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// fileExporter appends the export requests to a file, one JSON object per line as the file exporter of the
+// OpenTelemetry collector writes them, so the collector can read them back
+type fileExporter struct {
+	path string
+}
+
+func (e *fileExporter) export(signal string, payload []byte) error {
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", e.path, err)
+	}
+	if _, err := file.Write(append(payload, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing %s: %w", e.path, err)
+	}
+	return file.Close()
+}
+
+// ParseHeaders parses headers in the form of OTEL_EXPORTER_OTLP_HEADERS, comma separated key=value pairs with URL
+// encoded values
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected key=value", pair)
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
@@ -0,0 +1,229 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupFile sets up telemetry exporting to a file of a temporary directory, shut down at the end of the test
+func setupFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "telemetry.jsonl")
+	if err := Setup(Options{ServiceName: "test", ServiceVersion: "v1.2.3", File: path}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Shutdown)
+	return path
+}
+
+// exportedSpan is a span of an OTLP JSON traces export request
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Start        string `json:"startTimeUnixNano"`
+	End          string `json:"endTimeUnixNano"`
+	Attributes   []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type tracesRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []exportedSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// decodeSpans returns the spans of a traces export request, by name
+func decodeSpans(t *testing.T, data []byte) map[string]exportedSpan {
+	t.Helper()
+	var request tracesRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("invalid traces request %s: %v", data, err)
+	}
+	spans := map[string]exportedSpan{}
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			if scopeSpans.Scope.Name != scopeName {
+				t.Errorf("scope = %q, want %q", scopeSpans.Scope.Name, scopeName)
+			}
+			for _, span := range scopeSpans.Spans {
+				spans[span.Name] = span
+			}
+		}
+	}
+	return spans
+}
+
+func TestEncodeTraces(t *testing.T) {
+	start := time.Unix(1700000000, 5)
+	parent := &Span{name: "push", kind: kindInternal, traceID: [16]byte{1}, spanID: [8]byte{2}, start: start, end: start.Add(time.Second),
+		attributes: attributes([]any{"dashboard.id", "d1", "files", 3, "dry_run", true, "ratio", 0.5, "duration", time.Second})}
+	child := &Span{name: "PUT", kind: kindClient, traceID: parent.traceID, spanID: [8]byte{3}, parentID: parent.spanID, start: start, end: start,
+		err: errors.New("412 Precondition Failed")}
+	res := attributes([]any{"service.name", "test"})
+
+	spans := decodeSpans(t, encodeTraces(res, []*Span{parent, child}))
+
+	push := spans["push"]
+	if push.TraceID != "01000000000000000000000000000000" || push.SpanID != "0200000000000000" || push.ParentSpanID != "" {
+		t.Errorf("push ids = %s %s %q, want a root span", push.TraceID, push.SpanID, push.ParentSpanID)
+	}
+	if push.Kind != kindInternal || push.Start != "1700000000000000005" || push.End != "1700000001000000005" || push.Status.Code != 0 {
+		t.Errorf("push = %+v, want an internal span of a second, not failed", push)
+	}
+	values := map[string]any{}
+	for _, attribute := range push.Attributes {
+		for _, value := range attribute.Value {
+			values[attribute.Key] = value
+		}
+	}
+	// 64 bit integers are strings in OTLP JSON
+	want := map[string]any{"dashboard.id": "d1", "files": "3", "dry_run": true, "ratio": 0.5, "duration": "1s"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("attributes = %v, want %v", values, want)
+	}
+
+	put := spans["PUT"]
+	if put.TraceID != push.TraceID || put.ParentSpanID != push.SpanID || put.Kind != kindClient {
+		t.Errorf("PUT = %+v, want a client span child of push", put)
+	}
+	if put.Status.Code != statusError || put.Status.Message != "412 Precondition Failed" {
+		t.Errorf("PUT status = %+v, want the error", put.Status)
+	}
+}
+
+type metricsRequest struct {
+	ResourceMetrics []struct {
+		ScopeMetrics []struct {
+			Metrics []struct {
+				Name string `json:"name"`
+				Unit string `json:"unit"`
+				Sum  *struct {
+					DataPoints             []numberDataPoint `json:"dataPoints"`
+					AggregationTemporality int               `json:"aggregationTemporality"`
+					IsMonotonic            bool              `json:"isMonotonic"`
+				} `json:"sum"`
+				Histogram *struct {
+					DataPoints             []histogramDataPoint `json:"dataPoints"`
+					AggregationTemporality int                  `json:"aggregationTemporality"`
+				} `json:"histogram"`
+			} `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+func TestEncodeMetrics(t *testing.T) {
+	setupFile(t)
+	counter := NewCounter("test.encode.counter", "Counted", "{thing}")
+	histogram := NewHistogram("test.encode.histogram", "Timed", "s", []float64{1, 2})
+	NewCounter("test.encode.unused", "Never measured", "{thing}")
+	counter.Add(2, "operation", "pull")
+	counter.Add(3, "operation", "pull")
+	histogram.Record(0.5)
+	histogram.Record(1.5)
+	histogram.Record(4)
+
+	start, now := time.Unix(100, 0), time.Unix(160, 0)
+	var request metricsRequest
+	if err := json.Unmarshal(encodeMetrics(nil, start, now), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		switch metric.Name {
+		case "test.encode.counter":
+			found[metric.Name] = true
+			if metric.Sum == nil || metric.Histogram != nil || !metric.Sum.IsMonotonic || metric.Sum.AggregationTemporality != temporalityCumulative {
+				t.Fatalf("counter = %+v, want a cumulative monotonic sum", metric)
+			}
+			point := metric.Sum.DataPoints[0]
+			if len(metric.Sum.DataPoints) != 1 || point.AsInt != "5" || point.StartTimeUnixNano != "100000000000" || point.TimeUnixNano != "160000000000" {
+				t.Errorf("counter points = %+v, want 5 since start", metric.Sum.DataPoints)
+			}
+		case "test.encode.histogram":
+			found[metric.Name] = true
+			if metric.Histogram == nil || metric.Sum != nil || metric.Unit != "s" {
+				t.Fatalf("histogram = %+v, want a histogram in seconds", metric)
+			}
+			point := metric.Histogram.DataPoints[0]
+			if point.Count != "3" || math.Abs(point.Sum-6) > 1e-9 || point.Min != 0.5 || point.Max != 4 {
+				t.Errorf("histogram point = %+v, want 3 values summing to 6", point)
+			}
+			if !reflect.DeepEqual(point.BucketCounts, []string{"1", "1", "1"}) || !reflect.DeepEqual(point.ExplicitBounds, []float64{1, 2}) {
+				t.Errorf("buckets = %v %v, want one value per bucket", point.BucketCounts, point.ExplicitBounds)
+			}
+		case "test.encode.unused":
+			t.Error("metric never measured was encoded")
+		}
+	}
+	if len(found) != 2 {
+		t.Errorf("metrics found = %v, want the counter and the histogram", found)
+	}
+}
+
+func TestFileExport(t *testing.T) {
+	path := setupFile(t)
+	_, span := Start(context.Background(), "pull")
+	span.End(nil)
+	// Left open, ended by Shutdown
+	Start(context.Background(), "watch")
+	Shutdown()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	spans := decodeSpans(t, []byte(lines[0]))
+	if _, ok := spans["pull"]; !ok {
+		t.Errorf("spans = %v, want pull", spans)
+	}
+	if watch, ok := spans["watch"]; !ok || watch.End == "0" {
+		t.Errorf("spans = %v, want watch ended by Shutdown", spans)
+	}
+
+	var request tracesRequest
+	json.Unmarshal([]byte(lines[0]), &request)
+	resource := request.ResourceSpans[0].Resource.Attributes
+	if len(resource) != 2 || *resource[0].Value.StringValue != "test" || *resource[1].Value.StringValue != "v1.2.3" {
+		t.Errorf("resource = %+v, want the service name and version", resource)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("api-key=secret%3D1, x-team = sync ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"api-key": "secret=1", "x-team": "sync"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("headers = %v, want %v", headers, want)
+	}
+	if _, err := ParseHeaders("no-value"); err == nil {
+		t.Error("ParseHeaders(no-value) returned no error")
+	}
+}
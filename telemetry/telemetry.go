@@ -0,0 +1,158 @@
+// Package telemetry records OpenTelemetry traces and metrics of the sync and exports them with OTLP, as JSON over HTTP
+// to a collector or as JSON lines to a file for offline use. Nothing is recorded until Setup is called, so the
+// library costs nothing to the callers not exporting telemetry.
+package telemetry
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Export_Interval is how often telemetry is exported while running, so long running commands export as they go
+const Export_Interval = 30 * time.Second
+
+// Options configure where telemetry is exported to, at least one of Endpoint and File is set
+type Options struct {
+	ServiceName    string
+	ServiceVersion string
+	// Endpoint is the base URL of an OTLP/HTTP collector, traces are posted to /v1/traces and metrics to /v1/metrics
+	Endpoint string
+	// Headers are added to the requests to Endpoint, e.g. for authentication
+	Headers map[string]string
+	// File receives the exported traces and metrics as OTLP JSON lines, appended
+	File string
+}
+
+// exporter sends OTLP JSON payloads somewhere
+type exporter interface {
+	export(signal string, payload []byte) error
+}
+
+// provider collects the spans ended and exports them with the metrics
+type provider struct {
+	resource  []keyValue
+	exporters []exporter
+	started   time.Time
+
+	mu    sync.Mutex
+	spans []*Span
+	// exportMu keeps exports in order, they run outside of mu so recording never waits for the network
+	exportMu sync.Mutex
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+var (
+	defaultMu       sync.RWMutex
+	defaultProvider *provider
+)
+
+func current() *provider {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultProvider
+}
+
+// Setup starts recording telemetry and exporting it every Export_Interval, until Shutdown
+func Setup(options Options) error {
+	if options.Endpoint == "" && options.File == "" {
+		return fmt.Errorf("no OTLP endpoint or file to export telemetry to")
+	}
+
+	resource := []any{"service.name", options.ServiceName}
+	if options.ServiceVersion != "" {
+		resource = append(resource, "service.version", options.ServiceVersion)
+	}
+	p := &provider{
+		resource: attributes(resource),
+		started:  time.Now(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if options.Endpoint != "" {
+		p.exporters = append(p.exporters, &httpExporter{endpoint: options.Endpoint, headers: options.Headers, client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if options.File != "" {
+		p.exporters = append(p.exporters, &fileExporter{path: options.File})
+	}
+
+	defaultMu.Lock()
+	defaultProvider = p
+	defaultMu.Unlock()
+
+	go p.run()
+	return nil
+}
+
+// Shutdown ends the spans still open, exports what was recorded and stops recording
+func Shutdown() {
+	p := current()
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+
+	for _, span := range openSpans() {
+		span.End(nil)
+	}
+	p.export()
+
+	defaultMu.Lock()
+	defaultProvider = nil
+	defaultMu.Unlock()
+}
+
+func (p *provider) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(Export_Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.export()
+		}
+	}
+}
+
+// export sends the spans ended since the last export and the current value of every metric
+func (p *provider) export() {
+	p.exportMu.Lock()
+	defer p.exportMu.Unlock()
+
+	p.mu.Lock()
+	spans := p.spans
+	p.spans = nil
+	p.mu.Unlock()
+
+	payloads := map[string][]byte{}
+	if len(spans) > 0 {
+		payloads[signalTraces] = encodeTraces(p.resource, spans)
+	}
+	if metrics := encodeMetrics(p.resource, p.started, time.Now()); metrics != nil {
+		payloads[signalMetrics] = metrics
+	}
+
+	for _, signal := range []string{signalTraces, signalMetrics} {
+		if payloads[signal] == nil {
+			continue
+		}
+		for _, exporter := range p.exporters {
+			// Telemetry failing to export never fails the sync
+			if err := exporter.export(signal, payloads[signal]); err != nil {
+				slog.Warn("Failed to export telemetry", "signal", signal, "error", err)
+			}
+		}
+	}
+}
+
+func (p *provider) ended(span *Span) {
+	p.mu.Lock()
+	p.spans = append(p.spans, span)
+	p.mu.Unlock()
+}
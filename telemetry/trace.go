@@ -0,0 +1,151 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of span, as numbered by OTLP
+const (
+	kindInternal = 1
+	kindClient   = 3
+)
+
+// Span is an operation of the sync, timed from Start to End. Methods of a nil Span do nothing, it is what Start
+// returns when telemetry is not set up.
+type Span struct {
+	name     string
+	kind     int
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []keyValue
+	err        error
+}
+
+var (
+	// openMu guards open, the spans started and not ended yet, ended by Shutdown
+	openMu sync.Mutex
+	open   []*Span
+)
+
+// spanKey is the key of the span carried by a context
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, the parent of the spans started with the context
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, nil when it carries none
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start starts a span, child of the span carried by ctx or the root of a new trace, and returns a copy of ctx carrying
+// it for the spans started by the operation. attrs are key value pairs, as with slog.
+func Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := newSpan(SpanFromContext(ctx), name, kindInternal, attrs)
+	if span == nil {
+		return ctx, nil
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartClient starts a span for a request to a service, child of the span carried by ctx
+func StartClient(ctx context.Context, name string, attrs ...any) *Span {
+	return newSpan(SpanFromContext(ctx), name, kindClient, attrs)
+}
+
+func newSpan(parent *Span, name string, kind int, attrs []any) *Span {
+	if current() == nil {
+		return nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now(), attributes: attributes(attrs)}
+	rand.Read(span.spanID[:])
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	openMu.Lock()
+	open = append(open, span)
+	openMu.Unlock()
+	return span
+}
+
+// SetAttributes adds key value pairs to the attributes of the span, unless it ended already
+func (s *Span) SetAttributes(attrs ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.end.IsZero() {
+		s.attributes = append(s.attributes, attributes(attrs)...)
+	}
+	s.mu.Unlock()
+}
+
+// End ends the span, failed with err unless nil, and queues it for export. Ending a span twice does nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+
+	openMu.Lock()
+	for i, span := range open {
+		if span == s {
+			open = append(open[:i], open[i+1:]...)
+			break
+		}
+	}
+	openMu.Unlock()
+
+	if p := current(); p != nil {
+		p.ended(s)
+	}
+}
+
+// Traceparent returns the W3C trace context header of the span, for the service to tie its logs to the span, or an
+// empty string for a nil span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// openSpans returns the spans started and not ended, the last started first
+func openSpans() []*Span {
+	openMu.Lock()
+	defer openMu.Unlock()
+	spans := make([]*Span, 0, len(open))
+	for i := len(open) - 1; i >= 0; i-- {
+		spans = append(spans, open[i])
+	}
+	return spans
+}
@@ -0,0 +1,74 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSpansWithoutSetup(t *testing.T) {
+	ctx, span := Start(context.Background(), "push")
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Fatalf("Start returned %v without Setup, want no span", span)
+	}
+	// Methods of a nil span do nothing
+	span.SetAttributes("files", 1)
+	span.End(nil)
+	if traceparent := span.Traceparent(); traceparent != "" {
+		t.Errorf("Traceparent() = %q, want empty", traceparent)
+	}
+}
+
+func TestConcurrentSpans(t *testing.T) {
+	setupFile(t)
+	ctx, command := Start(context.Background(), "watch-remote")
+
+	// Spans started by goroutines running at the same time are children of the span of their own context
+	type started struct{ check, request *Span }
+	spans := make([]started, 8)
+	var wg sync.WaitGroup
+	ready := make(chan struct{})
+	for i := range spans {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			checkCtx, check := Start(ctx, "drift", "dashboard.id", fmt.Sprint(i))
+			<-ready
+			spans[i] = started{check, StartClient(checkCtx, "GET")}
+			spans[i].request.End(nil)
+			check.End(nil)
+		}(i)
+	}
+	close(ready)
+	wg.Wait()
+	command.End(nil)
+
+	for i, s := range spans {
+		if s.check.parentID != command.spanID || s.check.traceID != command.traceID {
+			t.Errorf("drift %d is not a child of the command", i)
+		}
+		if s.request.parentID != s.check.spanID || s.request.traceID != command.traceID {
+			t.Errorf("request %d is not a child of its drift", i)
+		}
+	}
+	if open := openSpans(); len(open) != 0 {
+		t.Errorf("%d spans left open", len(open))
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	setupFile(t)
+	ctx, parent := Start(context.Background(), "push")
+	defer parent.End(nil)
+	if SpanFromContext(ctx) != parent {
+		t.Fatal("context does not carry the span started")
+	}
+	request := StartClient(ctx, "PUT")
+	defer request.End(nil)
+
+	want := fmt.Sprintf("00-%x-%x-01", parent.traceID, request.spanID)
+	if traceparent := request.Traceparent(); traceparent != want {
+		t.Errorf("Traceparent() = %s, want %s", traceparent, want)
+	}
+}
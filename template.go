@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// templateValues selects the values the dashboard template is rendered with
+type templateValues = workspace.TemplateValues
+
+// addTemplateFlags registers the flags selecting template values, --env and the repeatable --set key=value
+func addTemplateFlags(flags *flag.FlagSet) *templateValues {
+	values := &templateValues{}
+	flags.StringVar(&values.Environment, "env", "", "environment whose values.<env>.yml overlays values.yml")
+	flags.Func("set", "template value as key=value, overriding the values files (repeatable)", func(value string) error {
+		values.Overrides = append(values.Overrides, value)
+		return nil
+	})
+	return values
+}
+
+// openWorkspace returns the workspace in the working directory with the given layout, configured by config.yml
+func openWorkspace(layout string, client dataexplorer.DashboardsAPI) *workspace.Workspace {
+	ws := &workspace.Workspace{FS: utils.DirFS("."), Client: client, Config: *localConfig(), Context: commandContext}
+	if layout != "" {
+		ws.Config.Layout = layout
+	}
+	return ws
+}
+
+// renderDashboard renders the local template of the given layout, values.yml only when values is nil, and writes
+// the processed template to Dashboard_Output_Path
+func renderDashboard(layout string, values *templateValues) (*workspace.Rendered, error) {
+	if values == nil {
+		values = &templateValues{}
+	}
+
+	rendered, err := openWorkspace(layout, nil).Render(*values)
+	if err != nil {
+		return nil, fmt.Errorf("error processing template: %v", err)
+	}
+
+	if err := os.MkdirAll("bin", 0755); err != nil {
+		return nil, fmt.Errorf("error creating bin directory: %v", err)
+	}
+	if err := os.WriteFile(Dashboard_Output_Path, rendered.YAML, 0644); err != nil {
+		return nil, fmt.Errorf("error creating output file: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// loadProcessedDashboard renders the local template of the given layout and returns it as a dashboard
+func loadProcessedDashboard(layout string, values *templateValues) (*models.Dashboard, error) {
+	rendered, err := renderDashboard(layout, values)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.Dashboard, nil
+}
+
+// localConfig returns config.yml for commands that may run without one, or else an empty config. A configuration
+// file given with --config has to exist.
+func localConfig() *Config {
+	config, err := getDashboardConfig()
+	if err != nil && globalOptions.config != "" {
+		fatalf("Failed to load %s: %v", globalOptions.config, err)
+	}
+	if err != nil {
+		return &Config{}
+	}
+	return config
+}
+
+// localLayout returns the layout configured in config.yml for commands that may run without one
+func localLayout() string {
+	if layout := localConfig().Layout; layout != "" {
+		return layout
+	}
+	return Layout_Single
+}
+
+// snippetPath returns the directories snippets included by query files are looked up in
+func snippetPath() []string {
+	return openWorkspace("", nil).SnippetPath()
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"gopkg.in/yaml.v3"
+)
+
+// DashboardSchema is the type describing key order of a whole dashboard document
+var DashboardSchema = reflect.TypeOf(models.Dashboard{})
+
+const canonicalIndent = 2
+
+// unorderedLists names lists whose order carries no meaning, mapped to the key their entries are sorted by ("" sorts scalars)
+var unorderedLists = map[string]string{
+	"queries":       "id",
+	"usedVariables": "",
+	"pageIds":       "",
+}
+
+// CanonicalYAML renders a document as YAML with keys in the order of the schema type, unordered lists sorted
+// and consistent indentation, so that serializing the same content always produces the same bytes.
+// Unordered lists are sorted in place.
+func CanonicalYAML(value interface{}, schema reflect.Type) ([]byte, error) {
+	sortUnorderedLists(value)
+
+	node, err := canonicalNode(value, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(canonicalIndent)
+	if err := encoder.Encode(node); err != nil {
+		return nil, fmt.Errorf("error marshaling YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("error marshaling YAML: %w", err)
+	}
+
+	return []byte(quotedActionRegex.ReplaceAllString(buf.String(), `$1`)), nil
+}
+
+// FormatTemplate canonicalizes dashboard template content, keeping includes intact
+func FormatTemplate(content []byte) ([]byte, error) {
+	doc, err := ParseTemplateDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return CanonicalYAML(doc, DashboardSchema)
+}
+
+// canonicalNode builds a YAML node for value, ordering mapping keys by the json tags of schema
+func canonicalNode(value interface{}, schema reflect.Type) (*yaml.Node, error) {
+	schema = derefType(schema)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range orderedKeys(v, schema) {
+			keyNode := &yaml.Node{}
+			if err := keyNode.Encode(key); err != nil {
+				return nil, err
+			}
+			child, err := canonicalNode(v[key], fieldType(schema, key))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, keyNode, child)
+		}
+		return node, nil
+	case []interface{}:
+		var elemType reflect.Type
+		if schema != nil && (schema.Kind() == reflect.Slice || schema.Kind() == reflect.Array) {
+			elemType = schema.Elem()
+		}
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v {
+			child, err := canonicalNode(item, elemType)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case string:
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return nil, err
+		}
+		// Includes and values are single quoted so they can be unquoted after encoding
+		if actionValueRegex.MatchString(v) {
+			node.Style = yaml.SingleQuotedStyle
+		}
+		return node, nil
+	default:
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+}
+
+// sortUnorderedLists sorts, in place, lists of the document whose order has no meaning
+func sortUnorderedLists(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if list, ok := child.([]interface{}); ok {
+				if sortKey, unordered := unorderedLists[key]; unordered {
+					sort.SliceStable(list, func(i, j int) bool {
+						return sortableValue(list[i], sortKey) < sortableValue(list[j], sortKey)
+					})
+				}
+			}
+			sortUnorderedLists(child)
+		}
+	case []interface{}:
+		for _, item := range v {
+			sortUnorderedLists(item)
+		}
+	}
+}
+
+func sortableValue(item interface{}, key string) string {
+	if key != "" {
+		if m, ok := item.(map[string]interface{}); ok {
+			item = m[key]
+		}
+	}
+	return fmt.Sprint(item)
+}
+
+// orderedKeys returns the keys of m with schema fields first in declaration order, followed by unknown keys sorted
+func orderedKeys(m map[string]interface{}, schema reflect.Type) []string {
+	positions := map[string]int{}
+	if schema != nil && schema.Kind() == reflect.Struct {
+		for i := 0; i < schema.NumField(); i++ {
+			positions[jsonName(schema.Field(i))] = i
+		}
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		pi, knownI := positions[keys[i]]
+		pj, knownJ := positions[keys[j]]
+		switch {
+		case knownI && knownJ:
+			return pi < pj
+		case knownI != knownJ:
+			return knownI
+		default:
+			return keys[i] < keys[j]
+		}
+	})
+
+	return keys
+}
+
+// fieldType returns the type of the schema field tagged with key, or nil if unknown
+func fieldType(schema reflect.Type, key string) reflect.Type {
+	if schema == nil || schema.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < schema.NumField(); i++ {
+		if jsonName(schema.Field(i)) == key {
+			return schema.Field(i).Type
+		}
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+)
+
+// SummarizeChanges describes, one line per change, how the tiles, queries and parameters of a dashboard changed
+func SummarizeChanges(previous, current *models.Dashboard) []string {
+	var changes []string
+
+	if previous.Title != current.Title {
+		changes = append(changes, fmt.Sprintf("title changed from %q to %q", previous.Title, current.Title))
+	}
+
+	previousTiles := map[string]models.Tile{}
+	for _, tile := range previous.Tiles {
+		previousTiles[tile.Id] = tile
+	}
+	currentTiles := map[string]bool{}
+	for _, tile := range current.Tiles {
+		currentTiles[tile.Id] = true
+		old, ok := previousTiles[tile.Id]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("tile %q added", tile.Title))
+		case old.Query.Text != tile.Query.Text:
+			changes = append(changes, fmt.Sprintf("tile %q query changed", tile.Title))
+		case old.MarkdownText != tile.MarkdownText:
+			changes = append(changes, fmt.Sprintf("tile %q markdown changed", tile.Title))
+		case !reflect.DeepEqual(old, tile):
+			changes = append(changes, fmt.Sprintf("tile %q changed", tile.Title))
+		}
+	}
+	for _, tile := range previous.Tiles {
+		if !currentTiles[tile.Id] {
+			changes = append(changes, fmt.Sprintf("tile %q removed", tile.Title))
+		}
+	}
+
+	previousQueries := map[string]models.Query{}
+	for _, query := range previous.Queries {
+		previousQueries[query.Id] = query
+	}
+	currentQueries := map[string]bool{}
+	for _, query := range current.Queries {
+		currentQueries[query.Id] = true
+		old, ok := previousQueries[query.Id]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("query %s added%s", query.Id, queryUsers(current, query.Id)))
+		case !reflect.DeepEqual(old, query):
+			changes = append(changes, fmt.Sprintf("query %s changed%s", query.Id, queryUsers(current, query.Id)))
+		}
+	}
+	for _, query := range previous.Queries {
+		if !currentQueries[query.Id] {
+			changes = append(changes, fmt.Sprintf("query %s removed%s", query.Id, queryUsers(previous, query.Id)))
+		}
+	}
+
+	previousParameters := map[string]models.Parameter{}
+	for _, parameter := range previous.Parameters {
+		previousParameters[parameter.Id] = parameter
+	}
+	currentParameters := map[string]bool{}
+	for _, parameter := range current.Parameters {
+		currentParameters[parameter.Id] = true
+		old, ok := previousParameters[parameter.Id]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("parameter %q added", parameter.DisplayName))
+		case !reflect.DeepEqual(old, parameter):
+			changes = append(changes, fmt.Sprintf("parameter %q changed", parameter.DisplayName))
+		}
+	}
+	for _, parameter := range previous.Parameters {
+		if !currentParameters[parameter.Id] {
+			changes = append(changes, fmt.Sprintf("parameter %q removed", parameter.DisplayName))
+		}
+	}
+
+	if !reflect.DeepEqual(previous.Pages, current.Pages) {
+		changes = append(changes, "pages changed")
+	}
+	if !reflect.DeepEqual(previous.DataSources, current.DataSources) {
+		changes = append(changes, "data sources changed")
+	}
+	if !reflect.DeepEqual(previous.BaseQueries, current.BaseQueries) {
+		changes = append(changes, "base queries changed")
+	}
+
+	return changes
+}
+
+// queryUsers names the tiles using a query, for change summaries
+func queryUsers(dashboard *models.Dashboard, queryId string) string {
+	for _, tile := range dashboard.Tiles {
+		if tile.QueryRef.QueryId == queryId {
+			return fmt.Sprintf(" (tile %q)", tile.Title)
+		}
+	}
+	for _, parameter := range dashboard.Parameters {
+		if parameter.DataSource.QueryRef.QueryId == queryId {
+			return fmt.Sprintf(" (parameter %q)", parameter.DisplayName)
+		}
+	}
+	return ""
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
 
 	"gopkg.in/yaml.v3"
@@ -21,7 +22,7 @@ func ConvertYAMLtoJSON(yamlFile string, jsonFile string) error {
 		return fmt.Errorf("Error writing JSON to file: %v\n", err)
 	}
 
-	fmt.Printf("JSON data written to %s\n", yamlFile)
+	slog.Info("JSON data written", "path", jsonFile)
 	return nil
 }
 
@@ -33,9 +34,14 @@ func ConvertYAMLToJSON(yamlFile string) ([]byte, error) {
 		return nil, fmt.Errorf("error reading YAML file: %v", err)
 	}
 
+	return YAMLToJSON(yamlData)
+}
+
+// YAMLToJSON converts a YAML document to indented JSON
+func YAMLToJSON(yamlData []byte) ([]byte, error) {
 	// Unmarshal the YAML into an interface{}
 	var yamlObj interface{}
-	err = yaml.Unmarshal(yamlData, &yamlObj)
+	err := yaml.Unmarshal(yamlData, &yamlObj)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling YAML: %v", err)
 	}
@@ -62,7 +68,7 @@ func ConvertJSONtoYAML(jsonFile, yamlFile string) error {
 		return fmt.Errorf("error writing YAML data to file: %v", err)
 	}
 
-	fmt.Printf("YAML data written to %s\n", yamlFile)
+	slog.Info("YAML data written", "path", yamlFile)
 	return nil
 }
 
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template actions, such as includes and values, standing for a whole YAML value
+var (
+	bareActionRegex   = regexp.MustCompile(`(?m)(^\s*-\s+|:\s+)({{[^}]*}})[ \t]*$`)
+	quotedActionRegex = regexp.MustCompile(`'({{[^}']*}})'`)
+	actionValueRegex  = regexp.MustCompile(`^{{[^}']*}}$`)
+)
+
+// LoadTemplateDocument reads a dashboard template without expanding includes and values, keeping them as quoted placeholders
+func LoadTemplateDocument(templatePath string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template file: %w", err)
+	}
+
+	return ParseTemplateDocument(content)
+}
+
+// ParseTemplateDocument parses template content into a document, keeping includes and values as quoted placeholders
+func ParseTemplateDocument(content []byte) (map[string]interface{}, error) {
+	quoted := bareActionRegex.ReplaceAllString(string(content), `$1'$2'`)
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(quoted), &doc); err != nil {
+		return nil, fmt.Errorf("error unmarshaling YAML: %w", err)
+	}
+
+	return doc, nil
+}
+
+// RenderTemplateDocument marshals a document back into canonical template form, unquoting include and value placeholders
+func RenderTemplateDocument(doc map[string]interface{}, schema reflect.Type) ([]byte, error) {
+	return CanonicalYAML(doc, schema)
+}
+
+// SaveTemplateDocument writes a document to the template path
+func SaveTemplateDocument(doc map[string]interface{}, schema reflect.Type, templatePath string) error {
+	content, err := RenderTemplateDocument(doc, schema)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(templatePath, content, 0644); err != nil {
+		return fmt.Errorf("error writing template file: %w", err)
+	}
+
+	return nil
+}
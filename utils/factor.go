@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/kql"
+)
+
+// Ways pull factors the let blocks shared by queries
+const (
+	FactorSnippets    = "snippets"
+	FactorBaseQueries = "base-queries"
+)
+
+const maxSnippetNameLength = 60
+
+// rawQuery is a query of a raw dashboard, from the queries list or inline in a tile
+type rawQuery struct {
+	query map[string]interface{}
+	block kql.LetBlock
+}
+
+// FactorSharedLets finds queries of a raw dashboard starting with identical let statements, and moves the statements
+// into a snippet under snippetDir of fsys that the queries include, or into base queries of the dashboard. Base
+// queries only take pipelines, blocks with scalar or function lets are always factored into snippets. It returns a
+// description of each factoring.
+func FactorSharedLets(fsys FileSystem, dashboardRaw *interface{}, mode string, snippetDir string) ([]string, error) {
+	if mode != FactorSnippets && mode != FactorBaseQueries {
+		return nil, fmt.Errorf("unknown factoring %q, expected %s or %s", mode, FactorSnippets, FactorBaseQueries)
+	}
+
+	dataMap := (*dashboardRaw).(map[string]interface{})
+	dashboard, err := ConvertRawDashboardToConcrete(dashboardRaw)
+	if err != nil {
+		return nil, fmt.Errorf("error converting to Dashboard struct: %v", err)
+	}
+	variables := DashboardVariables(dashboard)
+
+	var queries []map[string]interface{}
+	rawQueries, _ := dataMap["queries"].([]interface{})
+	for _, q := range rawQueries {
+		if query, ok := q.(map[string]interface{}); ok {
+			queries = append(queries, query)
+		}
+	}
+	rawTiles, _ := dataMap["tiles"].([]interface{})
+	for _, t := range rawTiles {
+		tile, _ := t.(map[string]interface{})
+		if query, ok := tile["query"].(map[string]interface{}); ok && tile["visualType"] != "markdownCard" {
+			queries = append(queries, query)
+		}
+	}
+
+	// Group the queries by their leading let statements, keeping the order of first appearance
+	groups := map[string][]rawQuery{}
+	var order []string
+	for _, query := range queries {
+		text, _ := query["text"].(string)
+		block, err := kql.LeadingLets(text)
+		if err != nil || len(block.Statements) == 0 || block.Rest == "" {
+			continue
+		}
+		key := strings.TrimSpace(block.Text)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], rawQuery{query: query, block: block})
+	}
+
+	var factored []string
+	for _, key := range order {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+
+		if mode == FactorBaseQueries && factorableIntoBaseQueries(group, variables) {
+			names := factorIntoBaseQueries(dataMap, group, variables)
+			variables = append(variables, names...)
+			factored = append(factored, fmt.Sprintf("let statements shared by %d queries into base queries: %s", len(group), strings.Join(names, ", ")))
+			continue
+		}
+
+		filename, err := writeSnippet(fsys, snippetDir, group[0].block)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range group {
+			member.query["text"] = includeDirective(filename) + member.block.Separator + member.block.Rest
+		}
+		factored = append(factored, fmt.Sprintf("let statements shared by %d queries into snippet: %s", len(group), filepath.Join(snippetDir, filename)))
+	}
+
+	return factored, nil
+}
+
+// factorableIntoBaseQueries reports whether the shared lets are pipelines run against a single data source whose
+// names are free to become dashboard variables
+func factorableIntoBaseQueries(group []rawQuery, variables []string) bool {
+	declared := map[string]bool{}
+	for _, variable := range variables {
+		declared[variable] = true
+	}
+	for _, statement := range group[0].block.Statements {
+		if !statement.Tabular || declared[statement.Name] {
+			return false
+		}
+	}
+
+	dataSourceId := queryDataSourceId(group[0].query)
+	for _, member := range group[1:] {
+		if queryDataSourceId(member.query) != dataSourceId {
+			return false
+		}
+	}
+	return true
+}
+
+// factorIntoBaseQueries adds a base query for each shared let statement and removes the statements from the queries
+func factorIntoBaseQueries(dataMap map[string]interface{}, group []rawQuery, variables []string) []string {
+	rawBaseQueries, _ := dataMap["baseQueries"].([]interface{})
+	rawQueries, _ := dataMap["queries"].([]interface{})
+	dataSource := group[0].query["dataSource"]
+
+	var names []string
+	for _, statement := range group[0].block.Statements {
+		queryId := newId()
+		rawQueries = append(rawQueries, map[string]interface{}{
+			"dataSource":    dataSource,
+			"text":          statement.Expression,
+			"id":            queryId,
+			"usedVariables": referencedVariables(statement.Expression, append(variables, names...)),
+		})
+		rawBaseQueries = append(rawBaseQueries, map[string]interface{}{
+			"id":           newId(),
+			"queryId":      queryId,
+			"variableName": statement.Name,
+		})
+		names = append(names, statement.Name)
+	}
+	dataMap["queries"] = rawQueries
+	dataMap["baseQueries"] = rawBaseQueries
+
+	for _, member := range group {
+		member.query["text"] = member.block.Rest
+		member.query["usedVariables"] = referencedVariables(member.block.Rest, append(variables, names...))
+	}
+
+	return names
+}
+
+// writeSnippet writes the let statements to a snippet file named after them and returns its name. A snippet of
+// the same name and content is reused, so dashboards pulled into the same workspace share their snippets.
+func writeSnippet(fsys FileSystem, snippetDir string, block kql.LetBlock) (string, error) {
+	if err := fsys.MkdirAll(snippetDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating snippets directory: %v", err)
+	}
+
+	var names []string
+	for _, statement := range block.Statements {
+		names = append(names, statement.Name)
+	}
+	base := fileSafeName("lets_" + strings.Join(names, "_"))
+	if len(base) > maxSnippetNameLength {
+		base = base[:maxSnippetNameLength]
+	}
+
+	content := block.Text + "\n"
+	for i := 1; ; i++ {
+		filename := base + ".kql"
+		if i > 1 {
+			filename = fmt.Sprintf("%s_%d.kql", base, i)
+		}
+
+		existing, err := fsys.ReadFile(filepath.Join(snippetDir, filename))
+		if err == nil && string(existing) != content {
+			continue
+		}
+		if err == nil {
+			return filename, nil
+		}
+		if err := fsys.WriteFile(filepath.Join(snippetDir, filename), []byte(content), 0644); err != nil {
+			return "", fmt.Errorf("error writing snippet %s: %v", filename, err)
+		}
+		return filename, nil
+	}
+}
+
+// referencedVariables returns the variables the query text references
+func referencedVariables(text string, variables []string) []interface{} {
+	used := []interface{}{}
+	for _, variable := range variables {
+		if ReferencesVariable(text, variable) {
+			used = append(used, variable)
+		}
+	}
+	return used
+}
+
+func queryDataSourceId(query map[string]interface{}) string {
+	dataSource, _ := query["dataSource"].(map[string]interface{})
+	id, _ := dataSource["dataSourceId"].(string)
+	return id
+}
+
+// newId returns a random UUID, as used for the ids of dashboard elements
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
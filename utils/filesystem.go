@@ -0,0 +1,336 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileSystem is the filesystem a workspace is read from and written to. It reads like an io/fs file system,
+// except that names may be relative paths climbing out of a directory with .., as includes and snippet paths do.
+type FileSystem interface {
+	fs.ReadFileFS
+	fs.StatFS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	RemoveAll(name string) error
+	// Rename moves a file or directory, replacing a file at newname. On disk renaming within a file system is
+	// atomic, which pull relies on to swap files into place.
+	Rename(oldname, newname string) error
+}
+
+// DirFS returns a FileSystem for the directory tree rooted at dir on disk
+func DirFS(dir string) FileSystem {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) join(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(name))
+}
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	return os.Open(dir.join(name))
+}
+
+func (dir dirFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(dir.join(name))
+}
+
+func (dir dirFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(dir.join(name))
+}
+
+func (dir dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(dir.join(name), data, perm)
+}
+
+func (dir dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(dir.join(name), perm)
+}
+
+func (dir dirFS) RemoveAll(name string) error {
+	return os.RemoveAll(dir.join(name))
+}
+
+func (dir dirFS) Rename(oldname, newname string) error {
+	return os.Rename(dir.join(oldname), dir.join(newname))
+}
+
+// MemFS is a FileSystem held in memory, mapping slash separated names to files. Directories exist once created
+// with MkdirAll or once a file is written under them.
+type MemFS struct {
+	files map[string]*memFile
+}
+
+// memFile is a file or, with fs.ModeDir set in its mode, a directory created with MkdirAll
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns a MemFS holding the given files
+func NewMemFS(files map[string]string) *MemFS {
+	m := &MemFS{files: map[string]*memFile{}}
+	for name, content := range files {
+		m.WriteFile(name, []byte(content), 0644)
+	}
+	return m
+}
+
+// clean turns a name into a valid io/fs name, failing for names outside the file system
+func (m *MemFS) clean(op string, name string) (string, error) {
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(cleaned) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return cleaned, nil
+}
+
+// isDir reports whether name is the root, a directory created with MkdirAll or the parent of a file
+func (m *MemFS) isDir(name string) bool {
+	if name == "." {
+		return true
+	}
+	if file, ok := m.files[name]; ok {
+		return file.mode.IsDir()
+	}
+	for file := range m.files {
+		if strings.HasPrefix(file, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// stat describes the file or directory name, a cleaned name
+func (m *MemFS) stat(op string, name string) (*memFileInfo, error) {
+	if file, ok := m.files[name]; ok {
+		return &memFileInfo{name: path.Base(name), size: int64(len(file.data)), mode: file.mode, modTime: file.modTime}, nil
+	}
+	if m.isDir(name) {
+		return &memFileInfo{name: path.Base(name), mode: fs.ModeDir | 0755}, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	cleaned, err := m.clean("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := m.stat("open", cleaned)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := m.ReadDir(cleaned)
+		if err != nil {
+			return nil, err
+		}
+		return &memDir{info: info, entries: entries}, nil
+	}
+	return &memOpenFile{info: info, Reader: bytes.NewReader(m.files[cleaned].data)}, nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	cleaned, err := m.clean("read", name)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := m.files[cleaned]
+	if !ok {
+		if m.isDir(cleaned) {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fmt.Errorf("is a directory")}
+		}
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	if file.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+	return append([]byte{}, file.data...), nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	cleaned, err := m.clean("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.stat("stat", cleaned)
+}
+
+// ReadDir lists the files and directories in the directory name, sorted by name
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	cleaned, err := m.clean("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !m.isDir(cleaned) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	prefix := cleaned + "/"
+	if cleaned == "." {
+		prefix = ""
+	}
+	children := map[string]bool{}
+	for file := range m.files {
+		if rest, ok := strings.CutPrefix(file, prefix); ok && rest != "" {
+			child, _, _ := strings.Cut(rest, "/")
+			children[child] = true
+		}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for child := range children {
+		info, _ := m.stat("readdir", path.Join(cleaned, child))
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	cleaned, err := m.clean("write", name)
+	if err != nil {
+		return err
+	}
+	if m.isDir(cleaned) {
+		return &fs.PathError{Op: "write", Path: name, Err: fmt.Errorf("is a directory")}
+	}
+	m.files[cleaned] = &memFile{data: append([]byte{}, data...), mode: perm, modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	cleaned, err := m.clean("mkdir", name)
+	if err != nil {
+		return err
+	}
+	for dir := cleaned; dir != "."; dir = path.Dir(dir) {
+		if file, ok := m.files[dir]; ok && !file.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fmt.Errorf("not a directory")}
+		}
+	}
+	if cleaned != "." && !m.isDir(cleaned) {
+		m.files[cleaned] = &memFile{mode: fs.ModeDir | perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	cleaned, err := m.clean("remove", name)
+	if err != nil {
+		return err
+	}
+	for file := range m.files {
+		if cleaned == "." || file == cleaned || strings.HasPrefix(file, cleaned+"/") {
+			delete(m.files, file)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	cleanedOld, err := m.clean("rename", oldname)
+	if err != nil {
+		return err
+	}
+	cleanedNew, err := m.clean("rename", newname)
+	if err != nil {
+		return err
+	}
+	if _, err := m.stat("rename", cleanedOld); err != nil {
+		return err
+	}
+	if file, ok := m.files[cleanedNew]; ok && file.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fmt.Errorf("is a directory")}
+	}
+
+	moved := map[string]*memFile{}
+	for file, data := range m.files {
+		if file == cleanedOld || strings.HasPrefix(file, cleanedOld+"/") {
+			moved[cleanedNew+strings.TrimPrefix(file, cleanedOld)] = data
+			delete(m.files, file)
+		}
+	}
+	for file, data := range moved {
+		m.files[file] = data
+	}
+	return nil
+}
+
+// Files returns the content of every file, keyed by name
+func (m *MemFS) Files() map[string]string {
+	files := map[string]string{}
+	for name, file := range m.files {
+		if !file.mode.IsDir() {
+			files[name] = string(file.data)
+		}
+	}
+	return files
+}
+
+// memFileInfo describes a file or directory of a MemFS
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() any           { return nil }
+
+// memOpenFile is a file of a MemFS opened for reading
+type memOpenFile struct {
+	*bytes.Reader
+	info *memFileInfo
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Close() error               { return nil }
+
+// memDir is a directory of a MemFS opened for listing
+type memDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS(map[string]string{
+		"dashboard.yml":           "title: Test",
+		"dashboard/pages/a/t.yml": "id: t",
+		"queries/q1.kql":          "StormEvents",
+	})
+	if err := m.MkdirAll("bin", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := fs.Glob(m, "dashboard/pages/*/*.yml")
+	if err != nil || !reflect.DeepEqual(matches, []string{"dashboard/pages/a/t.yml"}) {
+		t.Errorf("Glob = %v, %v", matches, err)
+	}
+
+	entries, err := fs.ReadDir(m, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"bin", "dashboard", "dashboard.yml", "queries"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir(.) = %v, want %v", names, want)
+	}
+
+	if info, err := m.Stat("dashboard/pages"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(dashboard/pages) = %v, %v, want a directory", info, err)
+	}
+	if _, err := m.ReadFile("missing.yml"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(missing.yml) error = %v, want fs.ErrNotExist", err)
+	}
+	if err := m.WriteFile("queries", []byte("x"), 0644); err == nil {
+		t.Error("WriteFile over a directory succeeded")
+	}
+	if _, err := m.ReadFile("../outside"); err == nil {
+		t.Error("ReadFile outside the file system succeeded")
+	}
+
+	if err := m.Rename("queries", "kql"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := m.ReadFile("kql/q1.kql"); err != nil || string(data) != "StormEvents" {
+		t.Errorf("ReadFile(kql/q1.kql) after rename = %q, %v", data, err)
+	}
+	if _, err := m.Stat("queries"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(queries) after rename error = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := m.RemoveAll("dashboard"); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"dashboard.yml": "title: Test", "kql/q1.kql": "StormEvents"}; !reflect.DeepEqual(m.Files(), want) {
+		t.Errorf("Files() = %v, want %v", m.Files(), want)
+	}
+}
@@ -0,0 +1,477 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Grid of the dashboard canvas that generated tiles are laid out on
+const GridColumns = 24
+
+// DashboardSpec is the compact description of a dashboard expanded by GenerateDashboard
+type DashboardSpec struct {
+	Title       string           `yaml:"title"`
+	DataSources []DataSourceSpec `yaml:"dataSources"`
+	Parameters  []ParameterSpec  `yaml:"parameters"`
+	Pages       []PageSpec       `yaml:"pages"`
+}
+
+// DataSourceSpec describes a database queries run against
+type DataSourceSpec struct {
+	Name       string `yaml:"name"`
+	ClusterUri string `yaml:"clusterUri"`
+	Database   string `yaml:"database"`
+}
+
+// ParameterSpec describes a parameter, either a time range (kind duration) or a value parameter
+type ParameterSpec struct {
+	Kind  string `yaml:"kind"`
+	Label string `yaml:"label"`
+	// Variable is the variable of a value parameter, Begin and End those of a time range
+	Variable string `yaml:"variable"`
+	Begin    string `yaml:"begin"`
+	End      string `yaml:"end"`
+	// Default is the default value, or a timespan such as 1h for time ranges. Multi selection parameters take Defaults.
+	Default  string   `yaml:"default"`
+	Defaults []string `yaml:"defaults"`
+	Multi    bool     `yaml:"multi"`
+	All      bool     `yaml:"all"`
+	// Values are the choices of the parameter, or else Query is a query file whose Column lists them
+	Values     []string `yaml:"values"`
+	Query      string   `yaml:"query"`
+	Column     string   `yaml:"column"`
+	DataSource string   `yaml:"dataSource"`
+	// Pages the parameter is shown on, all pages when empty
+	Pages []string `yaml:"pages"`
+}
+
+// PageSpec describes a page and its tiles, which are laid out in order, left to right and top to bottom
+type PageSpec struct {
+	Name  string     `yaml:"name"`
+	Tiles []TileSpec `yaml:"tiles"`
+}
+
+// TileSpec describes a tile showing a query file, or a markdown file
+type TileSpec struct {
+	Title      string                 `yaml:"title"`
+	Query      string                 `yaml:"query"`
+	Markdown   string                 `yaml:"markdown"`
+	Visual     string                 `yaml:"visual"`
+	DataSource string                 `yaml:"dataSource"`
+	Width      int                    `yaml:"width"`
+	Height     int                    `yaml:"height"`
+	Options    map[string]interface{} `yaml:"options"`
+}
+
+// GeneratedDashboard is a dashboard expanded from a spec, with the files its queries and markdown come from
+type GeneratedDashboard struct {
+	Dashboard     *models.Dashboard
+	QueryFiles    map[string]string // query id to query file
+	MarkdownFiles map[string]string // tile id to markdown file
+}
+
+// visualDefaults are the size and visual options of a new tile of each visual type
+type visualDefaults struct {
+	width, height int
+	options       map[string]interface{}
+}
+
+var chartDefaults = visualDefaults{12, 7, map[string]interface{}{
+	"hideLegend": false,
+	"xAxisScale": "linear",
+	"multipleYAxes": map[string]interface{}{
+		"base":       map[string]interface{}{"id": "-1", "label": "", "columns": []interface{}{}, "yAxisScale": "linear", "horizontalLines": []interface{}{}},
+		"additional": []interface{}{},
+	},
+}}
+
+var visualTypeDefaults = map[string]visualDefaults{
+	"table":            {12, 8, map[string]interface{}{"table__enableRenderLinks": true}},
+	"card":             {4, 4, map[string]interface{}{"multiStat__textSize": "auto"}},
+	"multistat":        {8, 4, map[string]interface{}{"multiStat__textSize": "auto", "multiStat__displayOrientation": "horizontal", "multiStat__slot": map[string]interface{}{"width": 4, "height": 2}}},
+	"pie":              {8, 7, map[string]interface{}{"pie__kind": "pie", "pie__label": []string{"name", "percentage"}, "pie__tooltip": []string{"name", "percentage", "value"}, "pie__orderBy": "size"}},
+	"markdownCard":     {GridColumns, 4, map[string]interface{}{}},
+	"timechart":        chartDefaults,
+	"linechart":        chartDefaults,
+	"areachart":        chartDefaults,
+	"stackedareachart": chartDefaults,
+	"barchart":         chartDefaults,
+	"columnchart":      chartDefaults,
+	"scatterchart":     chartDefaults,
+	"anomalychart":     chartDefaults,
+}
+
+var timespanRegex = regexp.MustCompile(`^(\d+)([mhd])$`)
+
+// LoadDashboardSpec reads a dashboard spec from fsys, rejecting unknown keys
+func LoadDashboardSpec(fsys FileSystem, path string) (*DashboardSpec, error) {
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var spec DashboardSpec
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %w", path, err)
+	}
+
+	return &spec, nil
+}
+
+// GenerateDashboard expands a spec into a full dashboard, reading query and markdown files of fsys relative to specDir
+// and the snippets query files include from snippetPath
+func GenerateDashboard(fsys FileSystem, spec *DashboardSpec, specDir string, snippetPath []string) (*GeneratedDashboard, error) {
+	generated := &GeneratedDashboard{
+		Dashboard: &models.Dashboard{
+			Schema:        "https://dataexplorer.azure.com/static/d/schema/" + models.LatestSchemaVersion() + "/dashboard.json",
+			SchemaVersion: models.LatestSchemaVersion(),
+			Title:         spec.Title,
+			BaseQueries:   []interface{}{},
+		},
+		QueryFiles:    map[string]string{},
+		MarkdownFiles: map[string]string{},
+	}
+	dashboard := generated.Dashboard
+
+	if len(spec.DataSources) == 0 {
+		return nil, fmt.Errorf("spec has no data sources")
+	}
+	dataSourceIds := map[string]string{}
+	for _, dataSourceSpec := range spec.DataSources {
+		dataSource := models.DataSource{
+			Id:         newId(),
+			Kind:       "manual-kusto",
+			ScopeId:    "kusto",
+			Name:       dataSourceSpec.Name,
+			ClusterUri: dataSourceSpec.ClusterUri,
+			Database:   dataSourceSpec.Database,
+		}
+		dataSourceIds[dataSourceSpec.Name] = dataSource.Id
+		dashboard.DataSources = append(dashboard.DataSources, dataSource)
+	}
+	dataSourceId := func(name string) (string, error) {
+		if name == "" {
+			return dashboard.DataSources[0].Id, nil
+		}
+		id, ok := dataSourceIds[name]
+		if !ok {
+			return "", fmt.Errorf("unknown data source %q", name)
+		}
+		return id, nil
+	}
+
+	pageIds := map[string]string{}
+	for _, pageSpec := range spec.Pages {
+		page := models.Page{Id: newId(), Name: pageSpec.Name}
+		pageIds[pageSpec.Name] = page.Id
+		dashboard.Pages = append(dashboard.Pages, page)
+	}
+
+	// Queries are added once parameters are known, so their used variables can be computed
+	type pendingQuery struct {
+		id, file, dataSourceId string
+	}
+	var pending []pendingQuery
+
+	for _, parameterSpec := range spec.Parameters {
+		parameter, err := generateParameter(parameterSpec, pageIds)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", parameterSpec.Label, err)
+		}
+		if parameterSpec.Query != "" {
+			id, err := dataSourceId(parameterSpec.DataSource)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %q: %w", parameterSpec.Label, err)
+			}
+			queryId := newId()
+			parameter.DataSource.Kind = "query"
+			parameter.DataSource.Columns.Value = parameterSpec.Column
+			parameter.DataSource.QueryRef.Kind = "query"
+			parameter.DataSource.QueryRef.QueryId = queryId
+			pending = append(pending, pendingQuery{queryId, parameterSpec.Query, id})
+		}
+		dashboard.Parameters = append(dashboard.Parameters, parameter)
+	}
+
+	for _, pageSpec := range spec.Pages {
+		x, y, rowHeight := 0, 0, 0
+		for _, tileSpec := range pageSpec.Tiles {
+			tile, err := generateTile(tileSpec)
+			if err != nil {
+				return nil, fmt.Errorf("tile %q on page %q: %w", tileSpec.Title, pageSpec.Name, err)
+			}
+			tile.PageId = pageIds[pageSpec.Name]
+
+			// Tiles flow left to right, wrapping to a new row below the tallest tile of the row
+			if x+tile.Layout.Width > GridColumns {
+				x, y, rowHeight = 0, y+rowHeight, 0
+			}
+			tile.Layout.X, tile.Layout.Y = x, y
+			x += tile.Layout.Width
+			rowHeight = max(rowHeight, tile.Layout.Height)
+
+			if tileSpec.Markdown != "" {
+				content, err := fsys.ReadFile(filepath.Join(specDir, tileSpec.Markdown))
+				if err != nil {
+					return nil, fmt.Errorf("tile %q: %w", tileSpec.Title, err)
+				}
+				tile.MarkdownText = string(content)
+				generated.MarkdownFiles[tile.Id] = filepath.Join(specDir, tileSpec.Markdown)
+			} else {
+				id, err := dataSourceId(tileSpec.DataSource)
+				if err != nil {
+					return nil, fmt.Errorf("tile %q: %w", tileSpec.Title, err)
+				}
+				queryId := newId()
+				tile.QueryRef.Kind = "query"
+				tile.QueryRef.QueryId = queryId
+				pending = append(pending, pendingQuery{queryId, tileSpec.Query, id})
+			}
+
+			dashboard.Tiles = append(dashboard.Tiles, *tile)
+		}
+	}
+
+	variables := DashboardVariables(dashboard)
+	for _, p := range pending {
+		path := filepath.Join(specDir, p.file)
+		text, _, err := ExpandSnippets(fsys, path, snippetPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading query file: %w", err)
+		}
+
+		query := models.Query{Text: text, Id: p.id, UsedVariables: []string{}}
+		query.DataSource.Kind = "inline"
+		query.DataSource.DataSourceId = p.dataSourceId
+		for _, variable := range variables {
+			if ReferencesVariable(text, variable) {
+				query.UsedVariables = append(query.UsedVariables, variable)
+			}
+		}
+		sort.Strings(query.UsedVariables)
+
+		dashboard.Queries = append(dashboard.Queries, query)
+		generated.QueryFiles[p.id] = path
+	}
+
+	if problems := ValidateDashboard(dashboard); len(problems) > 0 {
+		return nil, problems[0]
+	}
+
+	return generated, nil
+}
+
+// generateParameter expands a parameter spec, the data source of query backed parameters is set by the caller
+func generateParameter(spec ParameterSpec, pageIds map[string]string) (models.Parameter, error) {
+	parameter := models.Parameter{Kind: spec.Kind, Id: newId(), DisplayName: spec.Label}
+
+	if spec.Kind == "duration" {
+		parameter.BeginVariableName = valueOr(spec.Begin, "_startTime")
+		parameter.EndVariableName = valueOr(spec.End, "_endTime")
+
+		match := timespanRegex.FindStringSubmatch(valueOr(spec.Default, "1h"))
+		if match == nil {
+			return parameter, fmt.Errorf("default %q is not a timespan such as 30m, 1h or 7d", spec.Default)
+		}
+		count, _ := strconv.Atoi(match[1])
+		parameter.DefaultValue.Kind = "dynamic"
+		parameter.DefaultValue.Count = count
+		parameter.DefaultValue.Unit = map[string]string{"m": "minutes", "h": "hours", "d": "days"}[match[2]]
+	} else {
+		if spec.Variable == "" {
+			return parameter, fmt.Errorf("parameter has no variable")
+		}
+		if spec.Kind == "" {
+			parameter.Kind = "string"
+		}
+		parameter.VariableName = spec.Variable
+		parameter.SelectionType = "single"
+		if spec.Multi {
+			parameter.SelectionType = "multi"
+		}
+		parameter.IncludeAllOption = spec.All
+
+		switch {
+		case spec.Multi && len(spec.Defaults) > 0:
+			parameter.DefaultValue.Kind = "values"
+			parameter.DefaultValue.Values = spec.Defaults
+		case spec.Default != "":
+			parameter.DefaultValue.Kind = "value"
+			parameter.DefaultValue.Value = spec.Default
+		default:
+			parameter.DefaultValue.Kind = "all"
+		}
+
+		if len(spec.Values) > 0 && spec.Query != "" {
+			return parameter, fmt.Errorf("parameter has both values and a query")
+		}
+		if spec.Query != "" && spec.Column == "" {
+			return parameter, fmt.Errorf("query backed parameter has no column")
+		}
+		if len(spec.Values) > 0 {
+			parameter.DataSource.Kind = "static"
+			for _, value := range spec.Values {
+				parameter.DataSource.Values = append(parameter.DataSource.Values, struct {
+					DisplayText string `json:"displayText,omitempty"`
+					Value       string `json:"value,omitempty"`
+				}{Value: value})
+			}
+		}
+	}
+
+	parameter.ShowOnPages.Kind = "all"
+	if len(spec.Pages) > 0 {
+		parameter.ShowOnPages.Kind = "selection"
+		for _, name := range spec.Pages {
+			id, ok := pageIds[name]
+			if !ok {
+				return parameter, fmt.Errorf("unknown page %q", name)
+			}
+			parameter.ShowOnPages.PageIds = append(parameter.ShowOnPages.PageIds, id)
+		}
+	}
+
+	return parameter, nil
+}
+
+// generateTile expands a tile spec into a tile with the size and visual options of its visual type
+func generateTile(spec TileSpec) (*models.Tile, error) {
+	if (spec.Query == "") == (spec.Markdown == "") {
+		return nil, fmt.Errorf("tile needs either a query or a markdown file")
+	}
+
+	visual := spec.Visual
+	if spec.Markdown != "" {
+		visual = "markdownCard"
+	}
+	if visual == "" {
+		visual = "table"
+	}
+	defaults, ok := visualTypeDefaults[visual]
+	if !ok {
+		var known []string
+		for name := range visualTypeDefaults {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("unknown visual type %q, expected one of %s", visual, strings.Join(known, ", "))
+	}
+
+	tile := &models.Tile{Title: spec.Title, Id: newId(), VisualType: visual}
+	tile.Layout.Width = min(valueOrInt(spec.Width, defaults.width), GridColumns)
+	tile.Layout.Height = valueOrInt(spec.Height, defaults.height)
+
+	// Options of the spec override the defaults of the visual type, unknown options are rejected
+	options := map[string]interface{}{}
+	for key, value := range defaults.options {
+		options[key] = value
+	}
+	for key, value := range spec.Options {
+		options[key] = value
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling visual options: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tile.VisualOptions); err != nil {
+		return nil, fmt.Errorf("invalid visual options: %w", err)
+	}
+
+	return tile, nil
+}
+
+// Template returns the generated dashboard as a dashboard template, including its query and markdown files
+func (g *GeneratedDashboard) Template() ([]byte, error) {
+	data, err := json.Marshal(g.Dashboard)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling dashboard: %w", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard: %w", err)
+	}
+
+	// Zero values of the typed models the service does not expect, such as the inline query of a tile with a queryRef
+	tiles, _ := doc["tiles"].([]interface{})
+	for _, t := range tiles {
+		tile := t.(map[string]interface{})
+		if query, ok := tile["query"].(map[string]interface{}); ok && query["text"] == "" {
+			delete(tile, "query")
+		}
+	}
+	pruneEmpty(doc)
+
+	// Includes are resolved against the queries directory
+	include := func(path string) (string, error) {
+		relative, err := filepath.Rel(QueriesDir, path)
+		if err != nil {
+			return "", err
+		}
+		return includeDirective(filepath.ToSlash(relative)), nil
+	}
+
+	queries, _ := doc["queries"].([]interface{})
+	for _, q := range queries {
+		query := q.(map[string]interface{})
+		if path, ok := g.QueryFiles[query["id"].(string)]; ok {
+			if query["text"], err = include(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, t := range tiles {
+		tile := t.(map[string]interface{})
+		if path, ok := g.MarkdownFiles[tile["id"].(string)]; ok {
+			if tile["markdownText"], err = include(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return CanonicalYAML(doc, DashboardSchema)
+}
+
+// pruneEmpty removes null values and empty objects from a document, except visual options which are always present
+func pruneEmpty(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			pruneEmpty(child)
+			childMap, isMap := child.(map[string]interface{})
+			if child == nil || (isMap && len(childMap) == 0 && key != "visualOptions") {
+				delete(v, key)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			pruneEmpty(child)
+		}
+	}
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func valueOrInt(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDashboard(t *testing.T) {
+	fsys := DirFS("testdata/generate")
+	spec, err := LoadDashboardSpec(fsys, "dashboard.spec.yml")
+	if err != nil {
+		t.Fatalf("LoadDashboardSpec returned error: %v", err)
+	}
+	generated, err := GenerateDashboard(fsys, spec, ".", nil)
+	if err != nil {
+		t.Fatalf("GenerateDashboard returned error: %v", err)
+	}
+	dashboard := generated.Dashboard
+
+	ids := map[string]string{}
+	checkId := func(what, id string) {
+		if id == "" {
+			t.Errorf("%s has no id", what)
+		} else if other, ok := ids[id]; ok {
+			t.Errorf("%s has the id of %s", what, other)
+		}
+		ids[id] = what
+	}
+
+	if len(dashboard.DataSources) != 1 || dashboard.DataSources[0].Database != "Samples" {
+		t.Fatalf("dataSources = %+v, want the samples database", dashboard.DataSources)
+	}
+	dataSourceId := dashboard.DataSources[0].Id
+	checkId("data source", dataSourceId)
+
+	var pages []string
+	for _, page := range dashboard.Pages {
+		pages = append(pages, page.Name)
+		checkId("page "+page.Name, page.Id)
+	}
+	if want := []string{"Main", "About"}; !reflect.DeepEqual(pages, want) {
+		t.Fatalf("pages = %v, want %v", pages, want)
+	}
+
+	if len(dashboard.Parameters) != 2 {
+		t.Fatalf("parameters = %+v, want the time range and State", dashboard.Parameters)
+	}
+	timeRange, state := dashboard.Parameters[0], dashboard.Parameters[1]
+	checkId("parameter "+timeRange.DisplayName, timeRange.Id)
+	checkId("parameter "+state.DisplayName, state.Id)
+	if timeRange.Kind != "duration" || timeRange.BeginVariableName != "_startTime" || timeRange.EndVariableName != "_endTime" ||
+		timeRange.DefaultValue.Count != 6 || timeRange.DefaultValue.Unit != "hours" {
+		t.Errorf("time range = %+v, want _startTime and _endTime defaulting to 6 hours", timeRange)
+	}
+	if state.Kind != "string" || state.VariableName != "_state" || !state.IncludeAllOption || state.DefaultValue.Kind != "all" ||
+		state.DataSource.Kind != "query" || state.DataSource.Columns.Value != "State" {
+		t.Errorf("State = %+v, want _state listing the State column of its query", state)
+	}
+	if state.ShowOnPages.Kind != "selection" || !reflect.DeepEqual(state.ShowOnPages.PageIds, []string{dashboard.Pages[0].Id}) {
+		t.Errorf("State shows on %+v, want the Main page only", state.ShowOnPages)
+	}
+
+	type layout struct{ page, x, y, width, height int }
+	pageIndex := map[string]int{dashboard.Pages[0].Id: 0, dashboard.Pages[1].Id: 1}
+	wantTiles := map[string]struct {
+		visual string
+		layout layout
+	}{
+		"Events per hour": {"timechart", layout{0, 0, 0, 12, 7}},
+		// 24 columns do not fit next to the chart, so the tile starts a row below it
+		"Events": {"table", layout{0, 0, 7, 24, 8}},
+		"Notes":  {"markdownCard", layout{1, 0, 0, GridColumns, 4}},
+	}
+	if len(dashboard.Tiles) != len(wantTiles) {
+		t.Fatalf("tiles = %+v, want %d", dashboard.Tiles, len(wantTiles))
+	}
+	for _, tile := range dashboard.Tiles {
+		checkId("tile "+tile.Title, tile.Id)
+		want, ok := wantTiles[tile.Title]
+		if !ok {
+			t.Errorf("unexpected tile %q", tile.Title)
+			continue
+		}
+		got := layout{pageIndex[tile.PageId], tile.Layout.X, tile.Layout.Y, tile.Layout.Width, tile.Layout.Height}
+		if tile.VisualType != want.visual || got != want.layout {
+			t.Errorf("tile %q is a %s at %+v, want a %s at %+v", tile.Title, tile.VisualType, got, want.visual, want.layout)
+		}
+	}
+	if !dashboard.Tiles[1].VisualOptions.HideLegend {
+		t.Error("hideLegend of the spec was not merged over the table defaults")
+	}
+	if notes := dashboard.Tiles[2]; !strings.HasPrefix(notes.MarkdownText, "# Storms") || generated.MarkdownFiles[notes.Id] != "notes.md" {
+		t.Errorf("Notes = %q from %q, want notes.md", notes.MarkdownText, generated.MarkdownFiles[notes.Id])
+	}
+
+	queries := map[string][]string{}
+	for _, query := range dashboard.Queries {
+		checkId("query "+generated.QueryFiles[query.Id], query.Id)
+		if query.DataSource.DataSourceId != dataSourceId {
+			t.Errorf("query %s runs on %q, want the samples data source", query.Id, query.DataSource.DataSourceId)
+		}
+		queries[query.Id] = query.UsedVariables
+	}
+	wantVariables := map[string][]string{
+		state.DataSource.QueryRef.QueryId:   {},
+		dashboard.Tiles[0].QueryRef.QueryId: {"_endTime", "_startTime", "_state"},
+		dashboard.Tiles[1].QueryRef.QueryId: {"_endTime", "_startTime"},
+	}
+	if !reflect.DeepEqual(queries, wantVariables) {
+		t.Errorf("used variables by query = %v, want %v", queries, wantVariables)
+	}
+	if file := generated.QueryFiles[dashboard.Tiles[0].QueryRef.QueryId]; file != "queries/events_per_hour.kql" {
+		t.Errorf("query file of Events per hour = %q", file)
+	}
+}
+
+func TestGenerateDashboardErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr string
+	}{
+		{name: "unknown key", spec: "title: x\ntilez: []", wantErr: "field tilez not found"},
+		{name: "no data source", spec: "title: x", wantErr: "no data sources"},
+		{name: "unknown data source", spec: "dataSources: [{name: a}]\npages: [{name: p, tiles: [{title: t, query: q.kql, dataSource: b}]}]", wantErr: `unknown data source "b"`},
+		{name: "query and markdown", spec: "dataSources: [{name: a}]\npages: [{name: p, tiles: [{title: t, query: q.kql, markdown: n.md}]}]", wantErr: "either a query or a markdown file"},
+		{name: "unknown visual", spec: "dataSources: [{name: a}]\npages: [{name: p, tiles: [{title: t, query: q.kql, visual: radar}]}]", wantErr: `unknown visual type "radar"`},
+		{name: "unknown option", spec: "dataSources: [{name: a}]\npages: [{name: p, tiles: [{title: t, query: q.kql, options: {legend: true}}]}]", wantErr: "invalid visual options"},
+		{name: "bad timespan", spec: "dataSources: [{name: a}]\nparameters: [{kind: duration, label: r, default: 1w}]", wantErr: "not a timespan"},
+		{name: "unknown page", spec: "dataSources: [{name: a}]\nparameters: [{label: s, variable: _s, pages: [Other]}]", wantErr: `unknown page "Other"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := NewMemFS(map[string]string{"spec.yml": tt.spec, "q.kql": "StormEvents"})
+			spec, err := LoadDashboardSpec(fsys, "spec.yml")
+			if err == nil {
+				_, err = GenerateDashboard(fsys, spec, ".", nil)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/omeshp/kusto-dashboards-sync/kql"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+)
+
+// QueryDiagnostic is a lint finding in a query of the dashboard
+type QueryDiagnostic struct {
+	Query string
+	kql.Diagnostic
+}
+
+func (d QueryDiagnostic) String() string {
+	return d.Query + ": " + d.Diagnostic.String()
+}
+
+// QueryLintOptions returns the lint options of a query of the dashboard
+func QueryLintOptions(dashboard *models.Dashboard, query *models.Query, largeTables []string) kql.LintOptions {
+	options := kql.LintOptions{
+		LargeTables: largeTables,
+		Parameters: &kql.ParameterScope{
+			Declared: DashboardVariables(dashboard),
+			Used:     query.UsedVariables,
+		},
+	}
+	for _, parameter := range dashboard.Parameters {
+		if parameter.Kind == "duration" {
+			options.TimeVariables = append(options.TimeVariables, parameter.BeginVariableName, parameter.EndVariableName)
+		}
+	}
+	return options
+}
+
+// LintDashboard lints the queries of all tiles and base queries of the dashboard
+func LintDashboard(dashboard *models.Dashboard, largeTables []string) []QueryDiagnostic {
+	names := map[string]string{}
+	markdown := map[string]bool{}
+	for _, baseQuery := range BaseQueries(dashboard) {
+		names[baseQuery.QueryId] = "base query " + baseQuery.VariableName
+	}
+	for _, tile := range dashboard.Tiles {
+		if tile.QueryRef.QueryId != "" {
+			names[tile.QueryRef.QueryId] = fmt.Sprintf("tile %q", tile.Title)
+			markdown[tile.QueryRef.QueryId] = tile.VisualType == "markdownCard"
+		}
+	}
+
+	var diagnostics []QueryDiagnostic
+	lint := func(name string, query *models.Query) {
+		findings, err := kql.Lint(query.Text, QueryLintOptions(dashboard, query, largeTables))
+		if err != nil {
+			diagnostics = append(diagnostics, QueryDiagnostic{Query: name, Diagnostic: kql.Diagnostic{
+				Line:     1,
+				Severity: kql.SeverityError,
+				Rule:     "syntax",
+				Message:  err.Error(),
+			}})
+			return
+		}
+		for _, finding := range findings {
+			diagnostics = append(diagnostics, QueryDiagnostic{Query: name, Diagnostic: finding})
+		}
+	}
+
+	for i := range dashboard.Queries {
+		query := &dashboard.Queries[i]
+		if markdown[query.Id] {
+			continue
+		}
+		name, ok := names[query.Id]
+		if !ok {
+			name = "query " + query.Id
+		}
+		lint(name, query)
+	}
+	for i := range dashboard.Tiles {
+		tile := &dashboard.Tiles[i]
+		if tile.QueryRef.QueryId == "" && tile.Query.Text != "" && tile.VisualType != "markdownCard" {
+			lint(fmt.Sprintf("tile %q", tile.Title), &tile.Query)
+		}
+	}
+
+	return diagnostics
+}
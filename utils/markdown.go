@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownHeadingRegex   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownRuleRegex      = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	markdownUnorderedRegex = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	markdownOrderedRegex   = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	markdownTableSepRegex  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	markdownImageRegex     = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	markdownLinkRegex      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownStrongRegex    = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	markdownEmRegex        = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	markdownStrikeRegex    = regexp.MustCompile(`~~(.+?)~~`)
+)
+
+// RenderMarkdown converts the markdown subset used by dashboard text tiles into HTML: headings, paragraphs,
+// emphasis, code, links, images, lists, block quotes, rules and pipe tables
+func RenderMarkdown(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var sb strings.Builder
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```"):
+			lang := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			i++
+			var code []string
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+				code = append(code, lines[i])
+				i++
+			}
+			i++ // closing fence
+			if lang != "" {
+				sb.WriteString(`<pre><code class="language-` + html.EscapeString(lang) + `">`)
+			} else {
+				sb.WriteString("<pre><code>")
+			}
+			sb.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			sb.WriteString("</code></pre>\n")
+
+		case markdownHeadingRegex.MatchString(trimmed):
+			match := markdownHeadingRegex.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(match[1])))
+			sb.WriteString("<h" + level + ">" + renderInlineMarkdown(match[2]) + "</h" + level + ">\n")
+			i++
+
+		case markdownRuleRegex.MatchString(trimmed):
+			sb.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+				i++
+			}
+			sb.WriteString("<blockquote>\n" + RenderMarkdown(strings.Join(quoted, "\n")) + "</blockquote>\n")
+
+		case markdownUnorderedRegex.MatchString(line), markdownOrderedRegex.MatchString(line):
+			itemRegex, tag := markdownUnorderedRegex, "ul"
+			if !markdownUnorderedRegex.MatchString(line) {
+				itemRegex, tag = markdownOrderedRegex, "ol"
+			}
+			sb.WriteString("<" + tag + ">\n")
+			for i < len(lines) && itemRegex.MatchString(lines[i]) {
+				item := itemRegex.FindStringSubmatch(lines[i])[1]
+				i++
+				// Indented lines continue the previous item
+				for i < len(lines) && strings.HasPrefix(lines[i], "  ") && strings.TrimSpace(lines[i]) != "" && !itemRegex.MatchString(lines[i]) {
+					item += " " + strings.TrimSpace(lines[i])
+					i++
+				}
+				sb.WriteString("<li>" + renderInlineMarkdown(item) + "</li>\n")
+			}
+			sb.WriteString("</" + tag + ">\n")
+
+		case strings.Contains(line, "|") && i+1 < len(lines) && markdownTableSepRegex.MatchString(lines[i+1]):
+			sb.WriteString("<table>\n<thead>\n<tr>")
+			for _, cell := range splitTableRow(line) {
+				sb.WriteString("<th>" + renderInlineMarkdown(cell) + "</th>")
+			}
+			sb.WriteString("</tr>\n</thead>\n<tbody>\n")
+			i += 2
+			for i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != "" {
+				sb.WriteString("<tr>")
+				for _, cell := range splitTableRow(lines[i]) {
+					sb.WriteString("<td>" + renderInlineMarkdown(cell) + "</td>")
+				}
+				sb.WriteString("</tr>\n")
+				i++
+			}
+			sb.WriteString("</tbody>\n</table>\n")
+
+		default:
+			var paragraph []string
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsMarkdownBlock(lines, i) {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+				i++
+			}
+			if len(paragraph) == 0 {
+				// A line that looked like a block start but did not form one is rendered as text
+				paragraph = append(paragraph, trimmed)
+				i++
+			}
+			sb.WriteString("<p>" + renderInlineMarkdown(strings.Join(paragraph, "\n")) + "</p>\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// startsMarkdownBlock reports whether the line at index i starts a block other than a paragraph
+func startsMarkdownBlock(lines []string, i int) bool {
+	trimmed := strings.TrimSpace(lines[i])
+	return strings.HasPrefix(trimmed, "```") ||
+		strings.HasPrefix(trimmed, ">") ||
+		markdownHeadingRegex.MatchString(trimmed) ||
+		markdownRuleRegex.MatchString(trimmed) ||
+		markdownUnorderedRegex.MatchString(lines[i]) ||
+		markdownOrderedRegex.MatchString(lines[i]) ||
+		(strings.Contains(lines[i], "|") && i+1 < len(lines) && markdownTableSepRegex.MatchString(lines[i+1]))
+}
+
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// renderInlineMarkdown renders code spans, images, links and emphasis within a block of text
+func renderInlineMarkdown(text string) string {
+	var sb strings.Builder
+
+	segments := strings.Split(text, "`")
+	if len(segments)%2 == 0 {
+		// An unmatched backtick is kept as text
+		last := len(segments) - 1
+		segments = append(segments[:last-1], segments[last-1]+"`"+segments[last])
+	}
+
+	// Odd segments between backticks are code spans and are not processed further
+	for i, segment := range segments {
+		if i%2 == 1 {
+			sb.WriteString("<code>" + html.EscapeString(segment) + "</code>")
+			continue
+		}
+
+		segment = html.EscapeString(segment)
+		segment = markdownImageRegex.ReplaceAllStringFunc(segment, func(image string) string {
+			match := markdownImageRegex.FindStringSubmatch(image)
+			if !safeMarkdownURL(match[2]) {
+				return match[1]
+			}
+			return `<img src="` + match[2] + `" alt="` + match[1] + `">`
+		})
+		segment = markdownLinkRegex.ReplaceAllStringFunc(segment, func(link string) string {
+			match := markdownLinkRegex.FindStringSubmatch(link)
+			if !safeMarkdownURL(match[2]) {
+				return match[1]
+			}
+			return `<a href="` + match[2] + `">` + match[1] + `</a>`
+		})
+		segment = markdownStrongRegex.ReplaceAllString(segment, `<strong>$1$2</strong>`)
+		segment = markdownEmRegex.ReplaceAllString(segment, `<em>$1$2</em>`)
+		segment = markdownStrikeRegex.ReplaceAllString(segment, `<del>$1</del>`)
+		sb.WriteString(segment)
+	}
+
+	return sb.String()
+}
+
+// safeMarkdownURL reports whether an escaped link or image URL is relative or uses http or https. Other schemes, such
+// as javascript: and data:, are dropped from the rendered page and only the text of the link is kept.
+func safeMarkdownURL(escaped string) bool {
+	target := html.UnescapeString(escaped)
+	colon := strings.Index(target, ":")
+	if colon < 0 || strings.ContainsAny(target[:colon], "/?#") {
+		return true
+	}
+	scheme := strings.ToLower(target[:colon])
+	return scheme == "http" || scheme == "https"
+}
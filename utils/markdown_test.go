@@ -0,0 +1,39 @@
+package utils
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{name: "heading", markdown: "## Storms ##", want: "<h2>Storms</h2>\n"},
+		{name: "paragraph", markdown: "one\ntwo\n\nthree", want: "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{name: "emphasis", markdown: "**bold** *em* _under_ ~~gone~~", want: "<p><strong>bold</strong> <em>em</em> <em>under</em> <del>gone</del></p>\n"},
+		{name: "html escaped", markdown: "a <b> & c", want: "<p>a &lt;b&gt; &amp; c</p>\n"},
+		{name: "code span", markdown: "run `**x** <y>`", want: "<p>run <code>**x** &lt;y&gt;</code></p>\n"},
+		{name: "unmatched backtick", markdown: "a ` b", want: "<p>a ` b</p>\n"},
+		{name: "code block", markdown: "```kusto\nStormEvents\n| take <10>\n```", want: "<pre><code class=\"language-kusto\">StormEvents\n| take &lt;10&gt;</code></pre>\n"},
+		{name: "unordered list", markdown: "- one\n  continued\n* two", want: "<ul>\n<li>one continued</li>\n<li>two</li>\n</ul>\n"},
+		{name: "ordered list", markdown: "1. one\n2) *two*", want: "<ol>\n<li>one</li>\n<li><em>two</em></li>\n</ol>\n"},
+		{name: "block quote", markdown: "> quoted\n> text", want: "<blockquote>\n<p>quoted\ntext</p>\n</blockquote>\n"},
+		{name: "rule", markdown: "- - -", want: "<hr>\n"},
+		{name: "table", markdown: "| a | b |\n|---|:-:|\n| 1 | **2** |", want: "<table>\n<thead>\n<tr><th>a</th><th>b</th></tr>\n</thead>\n<tbody>\n<tr><td>1</td><td><strong>2</strong></td></tr>\n</tbody>\n</table>\n"},
+		{name: "link", markdown: "[docs](https://learn.microsoft.com/kusto?a=1&b=2)", want: "<p><a href=\"https://learn.microsoft.com/kusto?a=1&amp;b=2\">docs</a></p>\n"},
+		{name: "relative link", markdown: "[next](../page#top)", want: "<p><a href=\"../page#top\">next</a></p>\n"},
+		{name: "image", markdown: "![logo](http://example.com/logo.png)", want: "<p><img src=\"http://example.com/logo.png\" alt=\"logo\"></p>\n"},
+		{name: "javascript link dropped", markdown: "[click](javascript:alert(1))", want: "<p>click)</p>\n"},
+		{name: "javascript link in capitals dropped", markdown: "[click](JavaScript:void)", want: "<p>click</p>\n"},
+		{name: "data image dropped", markdown: "![x](data:image/svg+xml;base64,PHN2Zz4=)", want: "<p>x</p>\n"},
+		{name: "link in code span left alone", markdown: "`[a](javascript:x)`", want: "<p><code>[a](javascript:x)</code></p>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderMarkdown(tt.markdown); got != tt.want {
+				t.Errorf("RenderMarkdown(%q) =\n%q\nwant\n%q", tt.markdown, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+)
+
+// ParameterValue is the concrete KQL expression bound to a dashboard variable
+type ParameterValue struct {
+	Variable   string
+	Expression string
+	Parameter  string
+	Source     string
+}
+
+// Sources of parameter values
+const (
+	ValueSourceDefault  = "default"
+	ValueSourceQuery    = "query"
+	ValueSourceOverride = "override"
+)
+
+// ParameterResolver computes concrete values for the variables declared by dashboard parameters and base queries
+type ParameterResolver struct {
+	Dashboard *models.Dashboard
+
+	// Overrides replace the KQL expression bound to individual variables
+	Overrides map[string]string
+
+	// RunQuery runs the queries of query backed parameters; when nil those parameters fall back to an empty selection
+	RunQuery func(query *models.Query, text string) (*dataexplorer.QueryResult, error)
+
+	values    map[string]ParameterValue
+	resolving map[string]bool
+}
+
+// NewParameterResolver creates a resolver binding the parameters of the dashboard to their default values
+func NewParameterResolver(dashboard *models.Dashboard) *ParameterResolver {
+	return &ParameterResolver{
+		Dashboard: dashboard,
+		Overrides: map[string]string{},
+		values:    map[string]ParameterValue{},
+		resolving: map[string]bool{},
+	}
+}
+
+// Resolve returns the value bound to a parameter variable
+func (r *ParameterResolver) Resolve(variable string) (ParameterValue, error) {
+	if value, ok := r.values[variable]; ok {
+		return value, nil
+	}
+
+	parameter, ok := r.parameterOf(variable)
+	if !ok {
+		return ParameterValue{}, fmt.Errorf("variable %s is not declared by a parameter", variable)
+	}
+
+	if r.resolving[variable] {
+		return ParameterValue{}, fmt.Errorf("parameter %q depends on itself", parameter.DisplayName)
+	}
+	r.resolving[variable] = true
+	defer delete(r.resolving, variable)
+
+	value := ParameterValue{Variable: variable, Parameter: parameter.DisplayName, Source: ValueSourceDefault}
+	if override, ok := r.Overrides[variable]; ok {
+		value.Expression, value.Source = override, ValueSourceOverride
+	} else {
+		expression, source, err := r.defaultExpression(parameter, variable)
+		if err != nil {
+			return ParameterValue{}, fmt.Errorf("parameter %q: %w", parameter.DisplayName, err)
+		}
+		value.Expression, value.Source = expression, source
+	}
+
+	r.values[variable] = value
+	return value, nil
+}
+
+// Expand returns the query text preceded by let statements binding every variable it uses,
+// parameters to their resolved values and base queries to their text, so it can run outside the dashboard
+func (r *ParameterResolver) Expand(query *models.Query) (string, error) {
+	baseQueries := map[string]BaseQuery{}
+	for _, baseQuery := range BaseQueries(r.Dashboard) {
+		baseQueries[baseQuery.VariableName] = baseQuery
+	}
+
+	var parameterLets []string
+	var baseQueryLets []string
+	declared := map[string]bool{}
+
+	var declare func(variables []string) error
+	declare = func(variables []string) error {
+		sorted := append([]string(nil), variables...)
+		sort.Strings(sorted)
+		for _, variable := range sorted {
+			if declared[variable] {
+				continue
+			}
+			declared[variable] = true
+
+			if _, ok := r.parameterOf(variable); ok {
+				value, err := r.Resolve(variable)
+				if err != nil {
+					return err
+				}
+				parameterLets = append(parameterLets, fmt.Sprintf("let %s = %s; // %s", variable, value.Expression, value.Parameter))
+				continue
+			}
+
+			baseQuery, ok := baseQueries[variable]
+			if !ok {
+				return fmt.Errorf("variable %s is neither a parameter nor a base query", variable)
+			}
+			text, err := FindQuery(r.Dashboard, baseQuery.QueryId)
+			if err != nil {
+				return fmt.Errorf("base query %s: %w", variable, err)
+			}
+			// Base queries may themselves use parameters and other base queries, which must be declared first
+			if err := declare(text.UsedVariables); err != nil {
+				return err
+			}
+			baseQueryLets = append(baseQueryLets, fmt.Sprintf("let %s = %s;", variable, strings.TrimSuffix(strings.TrimSpace(text.Text), ";")))
+		}
+		return nil
+	}
+
+	if err := declare(query.UsedVariables); err != nil {
+		return "", err
+	}
+
+	lines := append(parameterLets, baseQueryLets...)
+	lines = append(lines, query.Text)
+	return strings.Join(lines, "\n"), nil
+}
+
+// parameterOf returns the parameter declaring a variable
+func (r *ParameterResolver) parameterOf(variable string) (models.Parameter, bool) {
+	for _, parameter := range r.Dashboard.Parameters {
+		if parameter.VariableName == variable || parameter.BeginVariableName == variable || parameter.EndVariableName == variable {
+			return parameter, true
+		}
+	}
+	return models.Parameter{}, false
+}
+
+// defaultExpression computes the KQL expression of a parameter's default value for one of its variables
+func (r *ParameterResolver) defaultExpression(parameter models.Parameter, variable string) (string, string, error) {
+	defaultValue := parameter.DefaultValue
+
+	if parameter.Kind == "duration" {
+		isEnd := variable == parameter.EndVariableName
+		// Fixed ranges carry their start and end, relative ones a count of units before now
+		if len(defaultValue.Values) == 2 {
+			if isEnd {
+				return kqlLiteral("datetime", defaultValue.Values[1]), ValueSourceDefault, nil
+			}
+			return kqlLiteral("datetime", defaultValue.Values[0]), ValueSourceDefault, nil
+		}
+		if isEnd {
+			return "now()", ValueSourceDefault, nil
+		}
+		return fmt.Sprintf("ago(%s)", kqlTimespan(defaultValue.Count, defaultValue.Unit)), ValueSourceDefault, nil
+	}
+
+	multi := parameter.SelectionType == "multi"
+	list := func(values []string) string {
+		var items []string
+		for _, value := range values {
+			items = append(items, kqlLiteral(parameter.Kind, value))
+		}
+		return "dynamic([" + strings.Join(items, ", ") + "])"
+	}
+
+	switch {
+	case multi && len(defaultValue.Values) > 0:
+		return list(defaultValue.Values), ValueSourceDefault, nil
+	case multi && defaultValue.Value != "":
+		return list([]string{defaultValue.Value}), ValueSourceDefault, nil
+	case !multi && defaultValue.Value != "":
+		return kqlLiteral(parameter.Kind, defaultValue.Value), ValueSourceDefault, nil
+	case !multi && len(defaultValue.Values) > 0:
+		return kqlLiteral(parameter.Kind, defaultValue.Values[0]), ValueSourceDefault, nil
+	}
+
+	// Without an explicit default, query backed parameters select the first value their query returns
+	if defaultValue.Kind != "all" && parameter.DataSource.QueryRef.QueryId != "" && r.RunQuery != nil {
+		value, err := r.firstQueryValue(parameter)
+		if err != nil {
+			return "", "", err
+		}
+		if value != nil {
+			if multi {
+				return list([]string{*value}), ValueSourceQuery, nil
+			}
+			return kqlLiteral(parameter.Kind, *value), ValueSourceQuery, nil
+		}
+	}
+
+	// "All" and empty selections are passed to queries as empty values
+	if multi {
+		return "dynamic([])", ValueSourceDefault, nil
+	}
+	return kqlLiteral(parameter.Kind, ""), ValueSourceDefault, nil
+}
+
+// firstQueryValue runs the query of a query backed parameter and returns the first value of its value column
+func (r *ParameterResolver) firstQueryValue(parameter models.Parameter) (*string, error) {
+	query, err := FindQuery(r.Dashboard, parameter.DataSource.QueryRef.QueryId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parameter query may depend on other parameters
+	text, err := r.Expand(query)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := r.RunQuery(query, text)
+	if err != nil {
+		return nil, fmt.Errorf("error running parameter query: %w", err)
+	}
+
+	column := 0
+	for i, c := range result.Columns {
+		if c.Name == parameter.DataSource.Columns.Value {
+			column = i
+		}
+	}
+
+	if len(result.Rows) == 0 || len(result.Rows[0]) <= column {
+		return nil, nil
+	}
+
+	value := fmt.Sprint(result.Rows[0][column])
+	return &value, nil
+}
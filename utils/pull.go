@@ -5,10 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/omeshp/kusto-dashboards-sync/models"
-	"gopkg.in/yaml.v3"
-	"log"
-	"os"
-	"regexp"
+	"path/filepath"
 	"strings"
 )
 
@@ -36,19 +33,18 @@ func ConvertRawDashboardToConcrete(rawDashboard *interface{}) (*models.Dashboard
 	return &dashboard, nil
 }
 
-func PersistDashboardData(dashboardRaw *interface{}, masterDashboard *models.Dashboard, outputYamlPath string) error {
-	if _, err := os.Stat("queries"); !os.IsNotExist(err) {
-		// If the directory exists, delete it
-		err := os.RemoveAll("queries")
-		if err != nil {
-			log.Fatalf("Failed to clean directory: %v", err)
-		}
+// PersistDashboardData writes the dashboard template to outputYamlPath of fsys and its queries under QueriesDir, and
+// returns the files written
+func PersistDashboardData(fsys FileSystem, dashboardRaw *interface{}, masterDashboard *models.Dashboard, outputYamlPath string) ([]string, error) {
+	// Clean the queries directory, so queries of removed tiles do not linger
+	if err := fsys.RemoveAll(QueriesDir); err != nil {
+		return nil, fmt.Errorf("error cleaning queries directory: %v", err)
 	}
 
 	// Create queries directory
-	err := os.MkdirAll("queries", 0755)
+	err := fsys.MkdirAll(QueriesDir, 0755)
 	if err != nil {
-		return fmt.Errorf("error creating queries directory: %v", err)
+		return nil, fmt.Errorf("error creating queries directory: %v", err)
 	}
 
 	//// Marshal YAML data
@@ -68,10 +64,11 @@ func PersistDashboardData(dashboardRaw *interface{}, masterDashboard *models.Das
 
 	dashboard, err := ConvertRawDashboardToConcrete(dashboardRaw)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return fmt.Errorf("error converting to Dashboard struct: %v", err)
+		return nil, fmt.Errorf("error converting to Dashboard struct: %v", err)
 	}
 
+	var written []string
+
 	for tileIndex, tile := range dashboard.Tiles {
 		var query models.Query
 		currentPageName := ""
@@ -88,7 +85,11 @@ func PersistDashboardData(dashboardRaw *interface{}, masterDashboard *models.Das
 			filename = strings.ReplaceAll(filename, " ", "_") + ".kql"
 		}
 
-		if tile.QueryRef.QueryId != "" {
+		if tile.VisualType == "markdownCard" && tile.MarkdownText != "" {
+			// Markdown tiles keep their content in markdownText rather than in a query
+			query.Text = tile.MarkdownText
+			dataMap["tiles"].([]interface{})[tileIndex].(map[string]interface{})["markdownText"] = includeDirective(filename)
+		} else if tile.QueryRef.QueryId != "" {
 			// Find the query by QueryRef
 			for queryIndex, q := range dashboard.Queries {
 				if q.Id == tile.QueryRef.QueryId {
@@ -105,12 +106,12 @@ func PersistDashboardData(dashboardRaw *interface{}, masterDashboard *models.Das
 		}
 
 		if query.Text != "" {
-			filepath := fmt.Sprintf("queries/%s", filename)
-			err := os.WriteFile(filepath, []byte(query.Text), 0644)
+			queryPath := filepath.Join(QueriesDir, filename)
+			err := fsys.WriteFile(queryPath, []byte(query.Text), 0644)
 			if err != nil {
-				return fmt.Errorf("error writing data to file %s: %v", filename, err)
+				return nil, fmt.Errorf("error writing data to file %s: %v", filename, err)
 			}
-			fmt.Printf("Query saved to file: %s\n", filepath)
+			written = append(written, queryPath)
 		}
 	}
 
@@ -119,25 +120,17 @@ func PersistDashboardData(dashboardRaw *interface{}, masterDashboard *models.Das
 	dataMap["title"] = masterDashboard.Title
 	dataMap["eTag"] = masterDashboard.ETag
 
-	// Marshal the data back into a YAML string
-	newYamlBytes, err := yaml.Marshal(&dataMap)
+	// Marshal the data back into a canonical YAML string, so unchanged dashboards serialize identically
+	yamlData, err := CanonicalYAML(dataMap, DashboardSchema)
 	if err != nil {
-		return fmt.Errorf("Error marshaling YAML: %v\n", err)
+		return nil, fmt.Errorf("Error marshaling YAML: %v\n", err)
 	}
 
-	yamlData := string(newYamlBytes)
-	re := regexp.MustCompile(`'{{ include "(.*?)"}}'`)
-	yamlData = re.ReplaceAllString(yamlData, `{{ include "$1"}}`)
-
-	err = os.WriteFile(outputYamlPath, []byte(yamlData), 0644)
-	if err != nil {
-		fmt.Println("Error:", err)
-		// handle the error
+	if err := fsys.WriteFile(outputYamlPath, yamlData, 0644); err != nil {
+		return nil, fmt.Errorf("error writing dashboard template: %v", err)
 	}
 
-	fmt.Printf("Saved dashboard template to: %s\n", outputYamlPath)
-
-	return nil
+	return append(written, outputYamlPath), nil
 }
 
 // replaceQueryText replaces the query text with include filename in jsonString
@@ -1,57 +1,93 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
-	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 )
 
-// include function reads the file content and returns it as a string
-func include(filename string) (string, error) {
-	filepath := fmt.Sprintf("queries/%s", filename)
-	// replace all escaped single quotes with single quotes
-	filepath = strings.ReplaceAll(filepath, "''", "'")
-	content, err := os.ReadFile(filepath)
-	if err != nil {
-		return "", err
-	}
+// QueriesDir is the directory includes are resolved against in the single file layout
+const QueriesDir = "queries"
 
-	value := string(content)
+// includeFrom returns an include function that reads files of fsys relative to dir, expanding the snippets included
+// by query files against snippetPath
+func includeFrom(fsys FileSystem, dir string, snippetPath []string) func(filename string) (string, error) {
+	// include function reads the file content and returns it as a string
+	return func(filename string) (string, error) {
+		// replace all escaped single quotes with single quotes
+		filename = strings.ReplaceAll(filename, "''", "'")
+		path := filepath.Join(dir, filename)
 
-	value = strings.ReplaceAll(value, "\n", "\\n")
-	value = strings.ReplaceAll(value, "'", "''")
-	value = "'" + value + "'"
+		var content string
+		if strings.EqualFold(filepath.Ext(filename), ".kql") {
+			expanded, _, err := ExpandSnippets(fsys, path, snippetPath)
+			if err != nil {
+				return "", err
+			}
+			content = expanded
+		} else {
+			data, err := fsys.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			content = string(data)
+		}
 
-	return value, nil
+		// A JSON string is also a valid YAML double quoted scalar, escaping newlines and quotes exactly
+		value, err := JSONMarshal(content)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSuffix(string(value), "\n"), nil
+	}
 }
 
-// ProcessTemplate processes the YAML template and writes the output to a file
-func ProcessTemplate(templatePath, outputPath string) error {
+// RenderTemplate processes a YAML template of fsys resolving includes against includeDir, with the values and
+// snippets of options, and returns the output
+func RenderTemplate(fsys FileSystem, templatePath, includeDir string, options TemplateOptions) ([]byte, error) {
 	// Read the template file
-	tmplContent, err := os.ReadFile(templatePath)
+	tmplContent, err := fsys.ReadFile(templatePath)
 	if err != nil {
-		return fmt.Errorf("error reading template file: %w", err)
+		return nil, fmt.Errorf("error reading template file: %w", err)
 	}
 
-	// Create a new template and register the include function
-	tmpl, err := template.New("yamlTemplate").Funcs(template.FuncMap{
-		"include": include,
-	}).Parse(string(tmplContent))
+	// Create a new template and register the include function. Using a value that is not set fails, unless it is given
+	// to default.
+	tmpl, err := template.New(templatePath).Option("missingkey=error").Funcs(templateFuncs(fsys, includeDir, options)).Parse(string(tmplContent))
 	if err != nil {
-		return fmt.Errorf("error parsing template: %w", err)
+		return nil, fmt.Errorf("error parsing template: %w", err)
 	}
+	optionalDefaults(tmpl.Root)
 
-	// Create the output file
-	outFile, err := os.Create(outputPath)
+	data := map[string]interface{}{
+		"Values":      options.Values,
+		"Environment": options.Environment,
+	}
+
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, data); err != nil {
+		if strings.Contains(err.Error(), "map has no entry for key") {
+			return nil, fmt.Errorf("error executing template: %w, set the value or give it a default", err)
+		}
+		return nil, fmt.Errorf("error executing template: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// ProcessTemplate processes the YAML template and writes the output to a file
+func ProcessTemplate(fsys FileSystem, templatePath, outputPath string, options TemplateOptions) error {
+	output, err := RenderTemplate(fsys, templatePath, QueriesDir, options)
 	if err != nil {
-		return fmt.Errorf("error creating output file: %w", err)
+		return err
 	}
-	defer outFile.Close()
 
-	// Execute the template with an empty data context
-	if err = tmpl.Execute(outFile, nil); err != nil {
-		return fmt.Errorf("error executing template: %w", err)
+	// Create the output file
+	if err := fsys.WriteFile(outputPath, output, 0644); err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	values := map[string]interface{}{
+		"cluster": map[string]interface{}{"uri": "https://c.kusto.windows.net"},
+		"empty":   "",
+		"pages":   []interface{}{map[string]interface{}{"name": "Main"}, map[string]interface{}{}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{name: "value", template: "uri: {{ .Values.cluster.uri }}", want: "uri: https://c.kusto.windows.net"},
+		{name: "piped default", template: `env: {{ .Environment | default "dev" }}`, want: "env: dev"},
+		{name: "default of a value not set", template: `db: {{ .Values.database | default "Samples" }}`, want: "db: Samples"},
+		{name: "default of a nested value not set", template: `db: {{ .Values.cluster.db.name | default "Samples" }}`, want: "db: Samples"},
+		{name: "default called", template: `db: {{ default "Samples" .Values.database }}`, want: "db: Samples"},
+		{name: "default of an empty value", template: `x: {{ .Values.empty | default "y" }}`, want: "x: y"},
+		{name: "default of a value set", template: `uri: {{ .Values.cluster.uri | default "none" | quote }}`, want: `uri: "https://c.kusto.windows.net"`},
+		{name: "default inside range", template: `{{ range .Values.pages }}- {{ .name | default "Untitled" }} {{ end }}`, want: "- Main - Untitled "},
+		{name: "value not set", template: "db: {{ .Values.database }}", wantErr: `dashboard.yml:1:14: executing "dashboard.yml" at <.Values.database>: map has no entry for key "database", set the value or give it a default`},
+		{name: "value not set inside range", template: `{{ range .Values.pages }}- {{ .name }} {{ end }}`, wantErr: `map has no entry for key "name"`},
+		{name: "value not set in quote", template: "db: {{ .Values.database | quote }}", wantErr: `map has no entry for key "database"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := NewMemFS(map[string]string{"dashboard.yml": tt.template})
+			out, err := RenderTemplate(fsys, "dashboard.yml", QueriesDir, TemplateOptions{Values: values})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("RenderTemplate error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderTemplate returned error: %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("RenderTemplate = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
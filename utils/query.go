@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+)
+
+// BaseQuery is a query shared by tiles through a variable
+type BaseQuery struct {
+	Id           string `json:"id"`
+	QueryId      string `json:"queryId"`
+	VariableName string `json:"variableName"`
+}
+
+// BaseQueries returns the base queries of the dashboard
+func BaseQueries(dashboard *models.Dashboard) []BaseQuery {
+	var baseQueries []BaseQuery
+	for _, raw := range dashboard.BaseQueries {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			continue
+		}
+		var baseQuery BaseQuery
+		if json.Unmarshal(data, &baseQuery) == nil && baseQuery.VariableName != "" {
+			baseQueries = append(baseQueries, baseQuery)
+		}
+	}
+	return baseQueries
+}
+
+// FindTile returns the tile with the given id, or else the only tile with the given title
+func FindTile(dashboard *models.Dashboard, idOrTitle string) (*models.Tile, error) {
+	var byTitle []int
+	for i, tile := range dashboard.Tiles {
+		if tile.Id == idOrTitle {
+			return &dashboard.Tiles[i], nil
+		}
+		if strings.EqualFold(tile.Title, idOrTitle) {
+			byTitle = append(byTitle, i)
+		}
+	}
+
+	switch len(byTitle) {
+	case 0:
+		return nil, fmt.Errorf("no tile with id or title %q", idOrTitle)
+	case 1:
+		return &dashboard.Tiles[byTitle[0]], nil
+	default:
+		return nil, fmt.Errorf("%d tiles are titled %q, use the tile id instead", len(byTitle), idOrTitle)
+	}
+}
+
+// FindQuery returns the query with the given id
+func FindQuery(dashboard *models.Dashboard, queryId string) (*models.Query, error) {
+	for i, query := range dashboard.Queries {
+		if query.Id == queryId {
+			return &dashboard.Queries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no query with id %s", queryId)
+}
+
+// TileQuery returns the query run by a tile, following its queryRef when it has one
+func TileQuery(dashboard *models.Dashboard, tile *models.Tile) (*models.Query, error) {
+	if tile.QueryRef.QueryId != "" {
+		return FindQuery(dashboard, tile.QueryRef.QueryId)
+	}
+	if tile.Query.Text != "" {
+		return &tile.Query, nil
+	}
+	return nil, fmt.Errorf("tile %q has no query", tile.Title)
+}
+
+// QueryDataSource returns the data source a query runs against
+func QueryDataSource(dashboard *models.Dashboard, query *models.Query) (*models.DataSource, error) {
+	for i, dataSource := range dashboard.DataSources {
+		if dataSource.Id == query.DataSource.DataSourceId {
+			return &dashboard.DataSources[i], nil
+		}
+	}
+	if query.DataSource.DataSourceId == "" && len(dashboard.DataSources) == 1 {
+		return &dashboard.DataSources[0], nil
+	}
+	return nil, fmt.Errorf("query %s uses unknown data source %q", query.Id, query.DataSource.DataSourceId)
+}
+
+// TimeRangeOverrides binds the variables of all time range parameters to the last timespan, e.g. "15m"
+func TimeRangeOverrides(dashboard *models.Dashboard, timespan string) map[string]string {
+	overrides := map[string]string{}
+	for _, parameter := range dashboard.Parameters {
+		if parameter.Kind == "duration" && parameter.BeginVariableName != "" && parameter.EndVariableName != "" {
+			overrides[parameter.BeginVariableName] = fmt.Sprintf("ago(%s)", timespan)
+			overrides[parameter.EndVariableName] = "now()"
+		}
+	}
+	return overrides
+}
+
+// kqlTimespan formats a count of units as a KQL timespan literal
+func kqlTimespan(count int, unit string) string {
+	if count == 0 {
+		count = 1
+	}
+	switch strings.ToLower(unit) {
+	case "seconds", "second":
+		return fmt.Sprintf("%ds", count)
+	case "minutes", "minute":
+		return fmt.Sprintf("%dm", count)
+	case "days", "day":
+		return fmt.Sprintf("%dd", count)
+	case "weeks", "week":
+		return fmt.Sprintf("%dd", count*7)
+	case "months", "month":
+		return fmt.Sprintf("%dd", count*30)
+	case "years", "year":
+		return fmt.Sprintf("%dd", count*365)
+	default:
+		return fmt.Sprintf("%dh", count)
+	}
+}
+
+// kqlLiteral formats a parameter value as a KQL literal of the parameter's type
+func kqlLiteral(kind string, value string) string {
+	switch kind {
+	case "int", "long", "real", "decimal", "datetime", "bool", "timespan", "guid":
+		if value == "" {
+			return kind + "(null)"
+		}
+		return kind + "(" + value + ")"
+	default:
+		return KQLString(value)
+	}
+}
+
+// KQLString quotes a value as a KQL string literal
+func KQLString(value string) string {
+	return `"` + kqlStringEscaper.Replace(value) + `"`
+}
+
+var kqlStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// QueryForText returns the dashboard query with the given text, or else a new query using the dashboard variables the text references
+func QueryForText(dashboard *models.Dashboard, text string) *models.Query {
+	for i, query := range dashboard.Queries {
+		if strings.TrimSpace(query.Text) == strings.TrimSpace(text) {
+			return &dashboard.Queries[i]
+		}
+	}
+	for i, tile := range dashboard.Tiles {
+		if tile.Query.Text != "" && strings.TrimSpace(tile.Query.Text) == strings.TrimSpace(text) {
+			return &dashboard.Tiles[i].Query
+		}
+	}
+
+	query := &models.Query{Text: text}
+	for _, variable := range DashboardVariables(dashboard) {
+		if ReferencesVariable(text, variable) {
+			query.UsedVariables = append(query.UsedVariables, variable)
+		}
+	}
+	return query
+}
+
+// DashboardVariables returns the names of all variables declared by parameters and base queries of the dashboard
+func DashboardVariables(dashboard *models.Dashboard) []string {
+	var variables []string
+	for _, parameter := range dashboard.Parameters {
+		for _, variable := range []string{parameter.VariableName, parameter.BeginVariableName, parameter.EndVariableName} {
+			if variable != "" {
+				variables = append(variables, variable)
+			}
+		}
+	}
+	for _, baseQuery := range BaseQueries(dashboard) {
+		variables = append(variables, baseQuery.VariableName)
+	}
+	return variables
+}
+
+// ReferencesVariable reports whether the query text contains the variable name as a whole identifier
+func ReferencesVariable(text string, variable string) bool {
+	for start := 0; ; {
+		index := strings.Index(text[start:], variable)
+		if index < 0 {
+			return false
+		}
+		index += start
+		end := index + len(variable)
+		if (index == 0 || !isIdentifierChar(text[index-1])) && (end == len(text) || !isIdentifierChar(text[end])) {
+			return true
+		}
+		start = index + 1
+	}
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
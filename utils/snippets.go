@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SnippetsDir is the default search path of snippets included by query files
+const SnippetsDir = "snippets"
+
+// snippetIncludeRegex matches an include directive within a query file
+var snippetIncludeRegex = regexp.MustCompile(`\{\{\s*include\s+"([^"]+)"\s*\}\}`)
+
+// SourceLine locates a line of an expanded query in the file it comes from
+type SourceLine struct {
+	File string
+	Line int
+}
+
+// ExpandSnippets reads a query file of fsys and replaces its include directives with the content of the included snippets.
+// Snippets are looked up next to the including file first, then in the directories of searchPath in order.
+// It returns the expanded text and, for every line of it, the file and line it comes from.
+func ExpandSnippets(fsys FileSystem, path string, searchPath []string) (string, []SourceLine, error) {
+	return expandSnippets(fsys, path, searchPath, nil)
+}
+
+func expandSnippets(fsys FileSystem, path string, searchPath []string, including []string) (string, []SourceLine, error) {
+	cleaned := filepath.Clean(path)
+	for i, file := range including {
+		if file == cleaned {
+			cycle := append(append([]string{}, including[i:]...), cleaned)
+			return "", nil, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	including = append(including, cleaned)
+
+	content, err := fsys.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var lines []string
+	var sources []SourceLine
+	for index, line := range strings.Split(string(content), "\n") {
+		matches := snippetIncludeRegex.FindAllStringSubmatchIndex(line, -1)
+		if len(matches) == 0 {
+			lines = append(lines, line)
+			sources = append(sources, SourceLine{File: path, Line: index + 1})
+			continue
+		}
+
+		var expanded strings.Builder
+		var snippetSources []SourceLine
+		last := 0
+		for _, match := range matches {
+			snippetPath, err := resolveSnippet(fsys, line[match[2]:match[3]], filepath.Dir(path), searchPath)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s:%d: %w", path, index+1, err)
+			}
+			snippet, snippetLines, err := expandSnippets(fsys, snippetPath, searchPath, including)
+			if err != nil {
+				return "", nil, err
+			}
+			expanded.WriteString(line[last:match[0]])
+			expanded.WriteString(strings.TrimSuffix(snippet, "\n"))
+			snippetSources = snippetLines
+			last = match[1]
+		}
+		expanded.WriteString(line[last:])
+
+		expandedLines := strings.Split(expanded.String(), "\n")
+		lines = append(lines, expandedLines...)
+		// Lines of a snippet included on a line of its own are located in the snippet, anything else on the including line
+		wholeLine := len(matches) == 1 && strings.TrimSpace(line) == line[matches[0][0]:matches[0][1]]
+		for i := range expandedLines {
+			if wholeLine && i < len(snippetSources) {
+				sources = append(sources, snippetSources[i])
+			} else {
+				sources = append(sources, SourceLine{File: path, Line: index + 1})
+			}
+		}
+	}
+
+	return strings.Join(lines, "\n"), sources, nil
+}
+
+// resolveSnippet finds the file of an included snippet
+func resolveSnippet(fsys FileSystem, name string, dir string, searchPath []string) (string, error) {
+	for _, candidate := range append([]string{dir}, searchPath...) {
+		path := filepath.Join(candidate, name)
+		if _, err := fsys.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("snippet %s not found next to the query or in %s", name, strings.Join(searchPath, ", "))
+}
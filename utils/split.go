@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Files and directories making up the split layout
+const (
+	SplitDashboardFile   = "dashboard.yml"
+	SplitParametersFile  = "parameters.yml"
+	SplitDataSourcesFile = "dataSources.yml"
+	SplitPagesDir        = "pages"
+	splitNoPageDir       = "_nopage"
+)
+
+// splitTileDocument describes the key order of a tile file
+type splitTileDocument struct {
+	Tile  models.Tile   `json:"tile"`
+	Query *models.Query `json:"query,omitempty"`
+}
+
+var splitTileSchema = reflect.TypeOf(splitTileDocument{})
+
+// fileSafeName turns a title into a name usable as a file or directory name on every platform, replacing spaces,
+// control characters and the characters Windows reserves in file names
+func fileSafeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(` /\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// PersistSplitDashboardData writes the dashboard as one directory per page and one YAML file per tile under outputDir
+// of fsys, and returns the files written
+func PersistSplitDashboardData(fsys FileSystem, dashboardRaw *interface{}, masterDashboard *models.Dashboard, outputDir string) ([]string, error) {
+	pagesDir := filepath.Join(outputDir, SplitPagesDir)
+	if err := fsys.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating %s directory: %v", outputDir, err)
+	}
+	if err := fsys.RemoveAll(pagesDir); err != nil {
+		return nil, fmt.Errorf("error cleaning pages directory: %v", err)
+	}
+
+	dataMap := (*dashboardRaw).(map[string]interface{})
+
+	dashboard, err := ConvertRawDashboardToConcrete(dashboardRaw)
+	if err != nil {
+		return nil, fmt.Errorf("error converting to Dashboard struct: %v", err)
+	}
+
+	var written []string
+
+	pageDirs := map[string]string{}
+	for pageIndex, page := range dashboard.Pages {
+		pageDirs[page.Id] = fmt.Sprintf("%02d_%s", pageIndex+1, fileSafeName(page.Name))
+	}
+
+	rawTiles, _ := dataMap["tiles"].([]interface{})
+	rawQueries, _ := dataMap["queries"].([]interface{})
+	ownedQueries := map[int]bool{}
+
+	for tileIndex, tile := range dashboard.Tiles {
+		pageDir, ok := pageDirs[tile.PageId]
+		if !ok {
+			pageDir = splitNoPageDir
+		}
+		tileDir := filepath.Join(pagesDir, pageDir)
+		if err := fsys.MkdirAll(tileDir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating page directory: %v", err)
+		}
+
+		baseName := fmt.Sprintf("%03d_%s", tileIndex+1, fileSafeName(tile.Title))
+		queryFilename := baseName + ".kql"
+		if tile.VisualType == "markdownCard" {
+			queryFilename = baseName + ".md"
+		}
+
+		rawTile := rawTiles[tileIndex].(map[string]interface{})
+		tileDoc := map[string]interface{}{"tile": rawTile}
+
+		queryText := ""
+		if tile.VisualType == "markdownCard" && tile.MarkdownText != "" {
+			// Markdown tiles keep their content in markdownText rather than in a query
+			queryText = tile.MarkdownText
+			rawTile["markdownText"] = includeDirective(queryFilename)
+		} else if tile.QueryRef.QueryId != "" {
+			for queryIndex, q := range dashboard.Queries {
+				if q.Id == tile.QueryRef.QueryId {
+					if ownedQueries[queryIndex] {
+						// The query is shared with an earlier tile, whose file keeps it
+						break
+					}
+					queryText = q.Text
+					rawQuery := rawQueries[queryIndex].(map[string]interface{})
+					if q.Text != "" {
+						rawQuery["text"] = includeDirective(queryFilename)
+					}
+					tileDoc["query"] = rawQuery
+					ownedQueries[queryIndex] = true
+					break
+				}
+			}
+		} else if tile.Query.Text != "" {
+			queryText = tile.Query.Text
+			rawTile["query"].(map[string]interface{})["text"] = includeDirective(queryFilename)
+		}
+
+		if queryText != "" {
+			queryPath := filepath.Join(tileDir, queryFilename)
+			if err := fsys.WriteFile(queryPath, []byte(queryText), 0644); err != nil {
+				return nil, fmt.Errorf("error writing data to file %s: %v", queryFilename, err)
+			}
+			written = append(written, queryPath)
+		}
+
+		tilePath := filepath.Join(tileDir, baseName+".yml")
+		if err := writeYAMLFile(fsys, tilePath, tileDoc, splitTileSchema); err != nil {
+			return nil, fmt.Errorf("error writing tile %s: %v", tile.Title, err)
+		}
+		written = append(written, tilePath)
+	}
+
+	parametersPath := filepath.Join(outputDir, SplitParametersFile)
+	if err := writeYAMLFile(fsys, parametersPath, dataMap["parameters"], reflect.TypeOf([]models.Parameter{})); err != nil {
+		return nil, err
+	}
+	dataSourcesPath := filepath.Join(outputDir, SplitDataSourcesFile)
+	if err := writeYAMLFile(fsys, dataSourcesPath, dataMap["dataSources"], reflect.TypeOf([]models.DataSource{})); err != nil {
+		return nil, err
+	}
+	written = append(written, parametersPath, dataSourcesPath)
+
+	// Queries not owned by a tile, e.g. parameter queries, stay in the root document
+	var remainingQueries []interface{}
+	for queryIndex, q := range rawQueries {
+		if !ownedQueries[queryIndex] {
+			remainingQueries = append(remainingQueries, q)
+		}
+	}
+
+	root := map[string]interface{}{}
+	for key, value := range dataMap {
+		switch key {
+		case "tiles", "parameters", "dataSources":
+			continue
+		}
+		root[key] = value
+	}
+	root["queries"] = remainingQueries
+	root["id"] = masterDashboard.Id
+	root["title"] = masterDashboard.Title
+	root["eTag"] = masterDashboard.ETag
+
+	rootPath := filepath.Join(outputDir, SplitDashboardFile)
+	if err := writeYAMLFile(fsys, rootPath, root, DashboardSchema); err != nil {
+		return nil, err
+	}
+
+	return append(written, rootPath), nil
+}
+
+// AssembleSplitDashboard reassembles a split layout under inputDir into a single processed dashboard YAML at outputPath
+func AssembleSplitDashboard(fsys FileSystem, inputDir, outputPath string, options TemplateOptions) error {
+	dashboard, err := LoadSplitDashboard(fsys, inputDir, options)
+	if err != nil {
+		return err
+	}
+
+	return writeYAMLFile(fsys, outputPath, dashboard, DashboardSchema)
+}
+
+// LoadSplitDashboard reads a split layout under inputDir of fsys and returns the assembled dashboard document with
+// includes expanded
+func LoadSplitDashboard(fsys FileSystem, inputDir string, options TemplateOptions) (map[string]interface{}, error) {
+	var root map[string]interface{}
+	if err := readTemplateYAML(fsys, filepath.Join(inputDir, SplitDashboardFile), inputDir, options, &root); err != nil {
+		return nil, err
+	}
+
+	var parameters, dataSources []interface{}
+	if err := readTemplateYAML(fsys, filepath.Join(inputDir, SplitParametersFile), inputDir, options, &parameters); err != nil {
+		return nil, err
+	}
+	if err := readTemplateYAML(fsys, filepath.Join(inputDir, SplitDataSourcesFile), inputDir, options, &dataSources); err != nil {
+		return nil, err
+	}
+
+	tilePaths, err := fs.Glob(fsys, filepath.ToSlash(filepath.Join(inputDir, SplitPagesDir, "*", "*.yml")))
+	if err != nil {
+		return nil, fmt.Errorf("error listing tile files: %v", err)
+	}
+
+	// Tile files are prefixed with their position in the dashboard, so sorting by file name restores tile order
+	sort.SliceStable(tilePaths, func(i, j int) bool {
+		return filepath.Base(tilePaths[i]) < filepath.Base(tilePaths[j])
+	})
+
+	queries, _ := root["queries"].([]interface{})
+	queryIds := map[string]bool{}
+	for _, q := range queries {
+		if id, ok := q.(map[string]interface{})["id"].(string); ok {
+			queryIds[id] = true
+		}
+	}
+
+	tiles := []interface{}{}
+	for _, tilePath := range tilePaths {
+		var tileDoc struct {
+			Tile  map[string]interface{} `yaml:"tile"`
+			Query map[string]interface{} `yaml:"query"`
+		}
+		if err := readTemplateYAML(fsys, tilePath, filepath.Dir(tilePath), options, &tileDoc); err != nil {
+			return nil, err
+		}
+		if tileDoc.Tile == nil {
+			return nil, fmt.Errorf("tile file %s has no tile entry", tilePath)
+		}
+
+		tiles = append(tiles, tileDoc.Tile)
+
+		if tileDoc.Query != nil {
+			id, _ := tileDoc.Query["id"].(string)
+			if !queryIds[id] {
+				queryIds[id] = true
+				queries = append(queries, tileDoc.Query)
+			}
+		}
+	}
+
+	root["tiles"] = tiles
+	root["queries"] = queries
+	root["parameters"] = parameters
+	root["dataSources"] = dataSources
+
+	return root, nil
+}
+
+// readTemplateYAML renders a template file resolving includes against includeDir and unmarshals the result
+func readTemplateYAML(fsys FileSystem, templatePath, includeDir string, options TemplateOptions, out interface{}) error {
+	content, err := RenderTemplate(fsys, templatePath, includeDir, options)
+	if err != nil {
+		return fmt.Errorf("error processing %s: %w", templatePath, err)
+	}
+
+	if err := yaml.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("error unmarshaling %s: %w", templatePath, err)
+	}
+
+	return nil
+}
+
+// writeYAMLFile marshals value to canonical YAML and writes it to path of fsys
+func writeYAMLFile(fsys FileSystem, path string, value interface{}, schema reflect.Type) error {
+	content, err := CanonicalYAML(value, schema)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// includeDirective returns the template directive including filename
+func includeDirective(filename string) string {
+	return `{{ include "` + filename + `"}}`
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+)
+
+const splitDashboardJSON = `{
+  "id": "d1",
+  "eTag": "e1",
+  "title": "Storms",
+  "schema_version": "52",
+  "pages": [{"id": "p1", "name": "Main: Page"}, {"id": "p2", "name": "About <us>"}],
+  "tiles": [
+    {"id": "t1", "title": "Storms: count?", "pageId": "p1", "visualType": "timechart", "layout": {"x": 0, "y": 0, "width": 9, "height": 7}, "queryRef": {"kind": "query", "queryId": "q1"}, "visualOptions": {}},
+    {"id": "t2", "title": "Notes", "pageId": "p2", "visualType": "markdownCard", "layout": {"x": 0, "y": 0, "width": 4, "height": 4}, "markdownText": "# Hello\nSome *notes*", "visualOptions": {}},
+    {"id": "t3", "title": "Top \"10\" | all", "pageId": "p2", "visualType": "table", "layout": {"x": 9, "y": 0, "width": 9, "height": 7}, "queryRef": {"kind": "query", "queryId": "q1"}, "visualOptions": {}}
+  ],
+  "parameters": [
+    {"kind": "string", "id": "par1", "displayName": "Region", "variableName": "_region", "selectionType": "single", "defaultValue": {"kind": "all"}, "dataSource": {"kind": "query", "columns": {"value": "Region"}, "queryRef": {"kind": "query", "queryId": "q2"}}, "showOnPages": {"kind": "all"}}
+  ],
+  "dataSources": [
+    {"id": "ds1", "kind": "manual-kusto", "scopeId": "kusto", "name": "help", "clusterUri": "https://help.kusto.windows.net/", "database": "Samples"}
+  ],
+  "queries": [
+    {"id": "q1", "dataSource": {"kind": "inline", "dataSourceId": "ds1"}, "text": "StormEvents\n| where State == _region\n| count", "usedVariables": ["_region"]},
+    {"id": "q2", "dataSource": {"kind": "inline", "dataSourceId": "ds1"}, "text": "StormEvents | distinct State | project Region=State", "usedVariables": []}
+  ]
+}`
+
+func TestSplitRoundTrip(t *testing.T) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(splitDashboardJSON), &raw); err != nil {
+		t.Fatal(err)
+	}
+	original, err := ConvertRawDashboardToConcrete(&raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := NewMemFS(nil)
+	written, err := PersistSplitDashboardData(fsys, &raw, original, "dashboard")
+	if err != nil {
+		t.Fatalf("PersistSplitDashboardData returned error: %v", err)
+	}
+	var queryFiles []string
+	for _, name := range written {
+		if strings.ContainsAny(filepath.Base(name), ` :*?"<>|`) {
+			t.Errorf("written file %q has characters not valid in file names", name)
+		}
+		if filepath.Ext(name) == ".kql" {
+			queryFiles = append(queryFiles, name)
+		}
+	}
+	// The query t1 and t3 share is kept by t1 only, so that editing it has one place to go
+	if len(queryFiles) != 1 {
+		t.Errorf("query files = %v, want only the file of t1", queryFiles)
+	}
+
+	loaded, err := LoadSplitDashboard(fsys, "dashboard", TemplateOptions{})
+	if err != nil {
+		t.Fatalf("LoadSplitDashboard returned error: %v", err)
+	}
+	var document interface{} = loaded
+	dashboard, err := ConvertRawDashboardToConcrete(&document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tileIds []string
+	for _, tile := range dashboard.Tiles {
+		tileIds = append(tileIds, tile.Id)
+	}
+	if want := []string{"t1", "t2", "t3"}; !reflect.DeepEqual(tileIds, want) {
+		t.Errorf("tiles = %v, want %v", tileIds, want)
+	}
+	if got := dashboard.Tiles[1].MarkdownText; got != original.Tiles[1].MarkdownText {
+		t.Errorf("markdownText = %q, want %q", got, original.Tiles[1].MarkdownText)
+	}
+	if dashboard.Tiles[0].QueryRef.QueryId != "q1" || dashboard.Tiles[2].QueryRef.QueryId != "q1" {
+		t.Errorf("queryRefs = %q, %q, want both tiles to keep sharing q1", dashboard.Tiles[0].QueryRef.QueryId, dashboard.Tiles[2].QueryRef.QueryId)
+	}
+
+	queryTexts := func(queries []models.Query) map[string]string {
+		texts := map[string]string{}
+		for _, q := range queries {
+			texts[q.Id] = q.Text
+		}
+		return texts
+	}
+	if got, want := queryTexts(dashboard.Queries), queryTexts(original.Queries); !reflect.DeepEqual(got, want) || len(dashboard.Queries) != len(original.Queries) {
+		t.Errorf("queries = %v, want %v", dashboard.Queries, original.Queries)
+	}
+}
+
+func TestFileSafeName(t *testing.T) {
+	tests := map[string]string{
+		"Main Page":          "Main_Page",
+		`a/b\c`:              "a_b_c",
+		`Storms: count?`:     "Storms__count_",
+		`<"all"> | *`:        "__all______",
+		"tab\there\nnewline": "tab_here_newline",
+		"Übersicht":          "Übersicht",
+	}
+	for name, want := range tests {
+		if got := fileSafeName(name); got != want {
+			t.Errorf("fileSafeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+)
+
+// ValidateDashboard checks the references inside a dashboard and returns the problems found
+func ValidateDashboard(dashboard *models.Dashboard) []error {
+	var problems []error
+
+	pageIds := map[string]bool{}
+	for _, page := range dashboard.Pages {
+		pageIds[page.Id] = true
+	}
+
+	dataSourceIds := map[string]bool{}
+	for _, dataSource := range dashboard.DataSources {
+		dataSourceIds[dataSource.Id] = true
+	}
+
+	queryIds := map[string]bool{}
+	for _, query := range dashboard.Queries {
+		if queryIds[query.Id] {
+			problems = append(problems, fmt.Errorf("query %s is defined more than once", query.Id))
+		}
+		queryIds[query.Id] = true
+
+		if query.DataSource.DataSourceId != "" && !dataSourceIds[query.DataSource.DataSourceId] {
+			problems = append(problems, fmt.Errorf("query %s uses unknown data source %s", query.Id, query.DataSource.DataSourceId))
+		}
+	}
+
+	tileIds := map[string]bool{}
+	for _, tile := range dashboard.Tiles {
+		if tile.Id == "" {
+			problems = append(problems, fmt.Errorf("tile %q has no id", tile.Title))
+		} else if tileIds[tile.Id] {
+			problems = append(problems, fmt.Errorf("tile id %s is used by more than one tile", tile.Id))
+		}
+		tileIds[tile.Id] = true
+
+		if tile.PageId != "" && len(pageIds) > 0 && !pageIds[tile.PageId] {
+			problems = append(problems, fmt.Errorf("tile %q is on unknown page %s", tile.Title, tile.PageId))
+		}
+
+		if tile.QueryRef.QueryId != "" && !queryIds[tile.QueryRef.QueryId] {
+			problems = append(problems, fmt.Errorf("tile %q references unknown query %s", tile.Title, tile.QueryRef.QueryId))
+		}
+	}
+
+	for _, parameter := range dashboard.Parameters {
+		queryId := parameter.DataSource.QueryRef.QueryId
+		if queryId != "" && !queryIds[queryId] {
+			problems = append(problems, fmt.Errorf("parameter %q references unknown query %s", parameter.DisplayName, queryId))
+		}
+	}
+
+	return problems
+}
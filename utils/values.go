@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValuesFile holds the template values shared by all environments
+const ValuesFile = "values.yml"
+
+// TemplateOptions are the inputs of rendering a dashboard template besides the template itself
+type TemplateOptions struct {
+	// SnippetPath are the directories snippets included by query files are looked up in
+	SnippetPath []string
+	// Values are available to the template as .Values
+	Values map[string]interface{}
+	// Environment is the name of the values environment, available to the template as .Environment
+	Environment string
+	// EnvAllowlist are the environment variables the template may read with env
+	EnvAllowlist []string
+}
+
+// ValuesFileFor returns the name of the values file of an environment
+func ValuesFileFor(environment string) string {
+	return "values." + environment + ".yml"
+}
+
+// LoadValues reads values.yml under dir of fsys, overlays the values file of the environment when one is given, then applies
+// key=value overrides whose keys may be dotted paths such as cluster.uri
+func LoadValues(fsys FileSystem, dir string, environment string, overrides []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	files := []string{ValuesFile}
+	if environment != "" {
+		files = append(files, ValuesFileFor(environment))
+	}
+	for i, file := range files {
+		data, err := fsys.ReadFile(filepath.Join(dir, file))
+		if errors.Is(err, fs.ErrNotExist) && i == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading values file: %w", err)
+		}
+
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s: %w", file, err)
+		}
+		mergeValues(values, fileValues)
+	}
+
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", override)
+		}
+		setValue(values, strings.Split(key, "."), parseValue(value))
+	}
+
+	return values, nil
+}
+
+// mergeValues merges src into dst, merging nested maps and replacing anything else
+func mergeValues(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// setValue sets the value at a path of keys, creating the maps along the path
+func setValue(values map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := values[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			values[key] = next
+		}
+		values = next
+	}
+	values[path[len(path)-1]] = value
+}
+
+// parseValue reads an override value as a YAML scalar, so numbers and booleans keep their type
+func parseValue(value string) interface{} {
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	switch parsed.(type) {
+	case string, int, float64, bool:
+		return parsed
+	}
+	return value
+}
+
+// templateFuncs returns the functions available to dashboard templates
+func templateFuncs(fsys FileSystem, includeDir string, options TemplateOptions) template.FuncMap {
+	allowed := map[string]bool{}
+	for _, name := range options.EnvAllowlist {
+		allowed[name] = true
+	}
+
+	return template.FuncMap{
+		"include": includeFrom(fsys, includeDir, options.SnippetPath),
+		// optionalValue looks up the values given to default, see optionalDefaults
+		optionalValueFunc: optionalValue,
+		// default returns value unless it is empty, for use as {{ .Values.x | default "y" }}
+		"default": func(fallback interface{}, value interface{}) interface{} {
+			if isEmptyValue(value) {
+				return fallback
+			}
+			return value
+		},
+		// quote returns value as a double quoted YAML scalar
+		"quote": func(value interface{}) (string, error) {
+			quoted, err := JSONMarshal(fmt.Sprint(value))
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(string(quoted), "\n"), nil
+		},
+		// indent prefixes every line of text with spaces
+		"indent": func(spaces int, text string) string {
+			padding := strings.Repeat(" ", spaces)
+			return padding + strings.ReplaceAll(text, "\n", "\n"+padding)
+		},
+		// env reads an environment variable, only variables listed in template_env of config.yml may be read
+		"env": func(name string) (string, error) {
+			if !allowed[name] {
+				return "", fmt.Errorf("environment variable %s is not listed in template_env", name)
+			}
+			return os.Getenv(name), nil
+		},
+	}
+}
+
+// optionalValueFunc names the function looking up the values given to default
+const optionalValueFunc = "optionalValue"
+
+// optionalDefaults rewrites the values given to default, as in {{ .Values.x | default "y" }} or {{ default "y" .Values.x }},
+// into lookups that return nil rather than failing when the value is not set, so a template executed with
+// missingkey=error can still fall back on a default
+func optionalDefaults(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			optionalDefaults(child)
+		}
+	case *parse.ActionNode:
+		optionalDefaults(n.Pipe)
+	case *parse.IfNode:
+		optionalDefaults(&n.BranchNode)
+	case *parse.RangeNode:
+		optionalDefaults(&n.BranchNode)
+	case *parse.WithNode:
+		optionalDefaults(&n.BranchNode)
+	case *parse.BranchNode:
+		optionalDefaults(n.Pipe)
+		optionalDefaults(n.List)
+		optionalDefaults(n.ElseList)
+	case *parse.TemplateNode:
+		optionalDefaults(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for i, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				optionalDefaults(arg)
+			}
+			if !isDefaultCommand(cmd) {
+				continue
+			}
+			// {{ .Values.x | default "y" }}
+			if i > 0 && len(n.Cmds[i-1].Args) == 1 {
+				if field, ok := n.Cmds[i-1].Args[0].(*parse.FieldNode); ok {
+					n.Cmds[i-1] = optionalValueCommand(field)
+				}
+			}
+			// {{ default "y" .Values.x }}
+			if last := len(cmd.Args) - 1; last == 2 {
+				if field, ok := cmd.Args[last].(*parse.FieldNode); ok {
+					cmd.Args[last] = &parse.PipeNode{NodeType: parse.NodePipe, Pos: field.Pos, Cmds: []*parse.CommandNode{optionalValueCommand(field)}}
+				}
+			}
+		}
+	}
+}
+
+func isDefaultCommand(cmd *parse.CommandNode) bool {
+	identifier, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && identifier.Ident == "default"
+}
+
+// optionalValueCommand returns the command looking up the field chain of field, as optionalValue . "Values" "x" for .Values.x
+func optionalValueCommand(field *parse.FieldNode) *parse.CommandNode {
+	args := []parse.Node{parse.NewIdentifier(optionalValueFunc).SetPos(field.Pos), &parse.DotNode{NodeType: parse.NodeDot, Pos: field.Pos}}
+	for _, name := range field.Ident {
+		args = append(args, &parse.StringNode{NodeType: parse.NodeString, Pos: field.Pos, Quoted: fmt.Sprintf("%q", name), Text: name})
+	}
+	return &parse.CommandNode{NodeType: parse.NodeCommand, Pos: field.Pos, Args: args}
+}
+
+// optionalValue follows path through the maps of data, returning nil when a key is missing
+func optionalValue(data interface{}, path ...string) interface{} {
+	value := reflect.ValueOf(data)
+	for _, key := range path {
+		for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Map || value.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		value = value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key()))
+		if !value.IsValid() {
+			return nil
+		}
+	}
+	return value.Interface()
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int:
+		return v == 0
+	case float64:
+		return v == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WatchFiles watches the given files and directory trees and sends the changed paths in batches,
+// once no further change has been seen for the debounce period
+func WatchFiles(roots []string, debounce time.Duration) (<-chan []string, error) {
+	events, err := watchEvents(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make(chan []string)
+	go func() {
+		defer close(batches)
+
+		pending := map[string]bool{}
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+
+		for {
+			select {
+			case path, ok := <-events:
+				if !ok {
+					return
+				}
+				if !isWatchedPath(roots, path) || isEditorTempFile(path) {
+					continue
+				}
+				pending[path] = true
+				timer.Reset(debounce)
+			case <-timer.C:
+				batch := make([]string, 0, len(pending))
+				for path := range pending {
+					batch = append(batch, path)
+				}
+				sort.Strings(batch)
+				pending = map[string]bool{}
+				batches <- batch
+			}
+		}
+	}()
+
+	return batches, nil
+}
+
+// watchedDirs returns the directories to watch for the roots, including every subdirectory of directory roots
+// and the parent of file roots, since editors often replace files instead of writing them in place
+func watchedDirs(roots []string) []string {
+	dirs := map[string]bool{}
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil || !info.IsDir() {
+			dirs[filepath.Dir(root)] = true
+			continue
+		}
+		filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+			if err == nil && entry.IsDir() {
+				dirs[path] = true
+			}
+			return nil
+		})
+	}
+
+	result := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// isWatchedPath reports whether path is one of the roots or lies within one of them
+func isWatchedPath(roots []string, path string) bool {
+	path = filepath.Clean(path)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEditorTempFile reports whether path looks like a swap or backup file written by an editor
+func isEditorTempFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || strings.HasSuffix(name, ".swp") || strings.HasSuffix(name, ".tmp")
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// watchEvents streams paths changed under the roots using inotify
+func watchEvents(roots []string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing inotify: %w", err)
+	}
+
+	dirs := map[int]string{}
+	addWatch := func(dir string) error {
+		wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("error watching %s: %w", dir, err)
+		}
+		dirs[wd] = dir
+		return nil
+	}
+
+	for _, dir := range watchedDirs(roots) {
+		if err := addWatch(dir); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+				offset = nameStart + int(event.Len)
+
+				dir, ok := dirs[int(event.Wd)]
+				if !ok {
+					continue
+				}
+				path := filepath.Join(dir, name)
+
+				// Watch directories created below a watched tree, e.g. new pages of the split layout
+				if event.Mask&syscall.IN_CREATE != 0 && event.Mask&syscall.IN_ISDIR != 0 && isWatchedPath(roots, path) {
+					if err := addWatch(path); err != nil {
+						slog.Warn("Changes in the new directory will be missed", "error", err)
+					}
+				}
+
+				events <- path
+			}
+		}
+	}()
+
+	return events, nil
+}
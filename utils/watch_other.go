@@ -0,0 +1,60 @@
+//go:build !linux
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const watchPollInterval = 500 * time.Millisecond
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchEvents streams paths changed under the roots by polling their modification times
+func watchEvents(roots []string) (<-chan string, error) {
+	events := make(chan string)
+	previous := snapshotFiles(roots)
+
+	go func() {
+		defer close(events)
+
+		for range time.Tick(watchPollInterval) {
+			current := snapshotFiles(roots)
+			for path, state := range current {
+				if old, ok := previous[path]; !ok || old != state {
+					events <- path
+				}
+			}
+			for path := range previous {
+				if _, ok := current[path]; !ok {
+					events <- path
+				}
+			}
+			previous = current
+		}
+	}()
+
+	return events, nil
+}
+
+// snapshotFiles records the modification time and size of every file under the roots
+func snapshotFiles(roots []string) map[string]fileState {
+	files := map[string]fileState{}
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+			}
+			return nil
+		})
+	}
+	return files
+}
@@ -0,0 +1,59 @@
+package main
+
+// validationReport is the --output json form of validate
+type validationReport struct {
+	Template    string       `json:"template"`
+	Valid       bool         `json:"valid"`
+	Problems    []string     `json:"problems"`
+	Diagnostics []lintReport `json:"diagnostics"`
+}
+
+type lintReport struct {
+	Query    string `json:"query"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// ValidateTemplate renders the local template, checks the references inside the dashboard and lints its queries,
+// exiting with Exit_Failure when it cannot be pushed
+func ValidateTemplate(args []string) {
+	flags := newFlagSet("validate")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	parseFlags(flags, args)
+
+	template := layoutPath(checkLayout(flags, *layout))
+	validation, err := openWorkspace(checkLayout(flags, *layout), nil).Validate(*values)
+	if err != nil {
+		fatalf("Failed to render dashboard: %v", err)
+	}
+
+	if jsonOutput() {
+		report := validationReport{Template: template, Valid: validation.Valid(), Problems: []string{}, Diagnostics: []lintReport{}}
+		for _, problem := range validation.Problems {
+			report.Problems = append(report.Problems, problem.Error())
+		}
+		for _, diagnostic := range validation.Diagnostics {
+			report.Diagnostics = append(report.Diagnostics, lintReport{
+				Query:    diagnostic.Query,
+				Line:     diagnostic.Line,
+				Severity: diagnostic.Severity.String(),
+				Rule:     diagnostic.Rule,
+				Message:  diagnostic.Message,
+			})
+		}
+		printJSON(report)
+	} else {
+		printValidationProblems(validation.Problems)
+		printLintDiagnostics(validation.Diagnostics)
+		if validation.Valid() {
+			printInfo("%s is valid\n", template)
+		}
+	}
+
+	if !validation.Valid() {
+		exit(Exit_Failure)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+const Query_Cache_Path = "bin/query_cache.json"
+
+// Query_Cache_Max_Age is how long a query that succeeded is not run again by default, as the tables it reads may
+// change or disappear in the meantime
+const Query_Cache_Max_Age = 24 * time.Hour
+
+// Defaults of query smoke tests
+const (
+	Verify_Time_Range  = "15m"
+	Verify_Concurrency = 4
+)
+
+// verifyOptions controls how the queries of a dashboard are smoke tested
+type verifyOptions struct {
+	TimeRange   string
+	Concurrency int
+	// NoCache runs every query, even those that succeeded before unchanged
+	NoCache bool
+	// CacheMaxAge is how long a cached success is trusted, older ones are run again
+	CacheMaxAge time.Duration
+}
+
+// queryCheck is a query of the dashboard to smoke test
+type queryCheck struct {
+	Name  string
+	Query *models.Query
+}
+
+// queryCheckResult is the outcome of running a query check
+type queryCheckResult struct {
+	Name       string
+	DataSource string
+	Rows       int
+	Latency    time.Duration
+	Cached     bool
+	Err        error
+}
+
+// testReport is the --output json form of test
+type testReport struct {
+	Queries []queryCheckReport `json:"queries"`
+	Failed  int                `json:"failed"`
+}
+
+type queryCheckReport struct {
+	Name       string       `json:"name"`
+	DataSource string       `json:"dataSource"`
+	Rows       int          `json:"rows"`
+	LatencyMs  int64        `json:"latencyMs"`
+	Cached     bool         `json:"cached"`
+	Error      *errorDetail `json:"error,omitempty"`
+}
+
+// cachedQueryResult records a successful run of a query in the query cache
+type cachedQueryResult struct {
+	Rows      int       `json:"rows"`
+	LatencyMs int64     `json:"latencyMs"`
+	RunAt     time.Time `json:"runAt"`
+}
+
+// TestDashboard runs every tile and base query of the local template and reports failures
+func TestDashboard(args []string) {
+	flags := newFlagSet("test")
+	layout := flags.String("layout", localLayout(), "on-disk layout of the dashboard template: single or split")
+	values := addTemplateFlags(flags)
+	options := addVerifyFlags(flags)
+	parseFlags(flags, args)
+
+	dashboard, err := loadProcessedDashboard(*layout, values)
+	if err != nil {
+		fatalf("Failed to load dashboard: %v", err)
+	}
+
+	results := runQueryChecks(dashboard, dashboardQueryChecks(dashboard), *options)
+	failed := 0
+	if jsonOutput() {
+		report := testReport{Queries: []queryCheckReport{}}
+		for _, result := range results {
+			check := queryCheckReport{Name: result.Name, DataSource: result.DataSource, Rows: result.Rows, LatencyMs: result.Latency.Milliseconds(), Cached: result.Cached}
+			if result.Err != nil {
+				check.Error = &errorDetail{Code: Error_QueryFailed, Message: result.Err.Error()}
+				failed++
+			}
+			report.Queries = append(report.Queries, check)
+		}
+		report.Failed = failed
+		printJSON(report)
+	} else {
+		failed = printQueryChecks(results)
+	}
+
+	if failed > 0 {
+		failf("%d queries failed", failed)
+	}
+}
+
+// addVerifyFlags registers the flags controlling query smoke tests
+func addVerifyFlags(flags *flag.FlagSet) *verifyOptions {
+	options := &verifyOptions{}
+	flags.StringVar(&options.TimeRange, "time-range", Verify_Time_Range, "time range parameters are bound to when running queries")
+	flags.IntVar(&options.Concurrency, "concurrency", Verify_Concurrency, "maximum number of queries running at once")
+	flags.BoolVar(&options.NoCache, "no-cache", false, "run queries even if they succeeded before unchanged")
+	flags.DurationVar(&options.CacheMaxAge, "cache-max-age", Query_Cache_Max_Age, "run queries again once their cached success is older than this")
+	return options
+}
+
+// verifyDashboard runs every tile and base query of the dashboard, prints a report and returns the number of failures.
+// With --output json the results are logged instead.
+func verifyDashboard(dashboard *models.Dashboard, options verifyOptions) int {
+	results := runQueryChecks(dashboard, dashboardQueryChecks(dashboard), options)
+	if !jsonOutput() {
+		return printQueryChecks(results)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			slog.Error("Query failed", "query", result.Name, "dataSource", result.DataSource, "error", result.Err)
+			failed++
+		} else {
+			slog.Info("Query succeeded", "query", result.Name, "dataSource", result.DataSource, "rows", result.Rows, "latency", result.Latency, "cached", result.Cached)
+		}
+	}
+	return failed
+}
+
+// printQueryChecks prints the results of query checks as a table and returns the number of failures
+func printQueryChecks(results []queryCheckResult) int {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "QUERY\tDATA SOURCE\tROWS\tLATENCY\tRESULT")
+	failed := 0
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case result.Err != nil:
+			status = "FAILED: " + strings.ReplaceAll(result.Err.Error(), "\n", " ")
+			failed++
+		case result.Cached:
+			status = "ok (cached)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", result.Name, result.DataSource, result.Rows, result.Latency.Round(time.Millisecond), status)
+	}
+	writer.Flush()
+
+	return failed
+}
+
+// dashboardQueryChecks lists the queries of all tiles and base queries of the dashboard
+func dashboardQueryChecks(dashboard *models.Dashboard) []queryCheck {
+	var checks []queryCheck
+	for i := range dashboard.Tiles {
+		tile := &dashboard.Tiles[i]
+		if tile.VisualType == "markdownCard" {
+			continue
+		}
+		query, err := utils.TileQuery(dashboard, tile)
+		if err != nil {
+			continue
+		}
+		checks = append(checks, queryCheck{Name: "tile " + tile.Title, Query: query})
+	}
+
+	for _, baseQuery := range utils.BaseQueries(dashboard) {
+		query, err := utils.FindQuery(dashboard, baseQuery.QueryId)
+		if err != nil {
+			continue
+		}
+		// Running the base query variable itself binds it, and whatever it uses, through let statements
+		checks = append(checks, queryCheck{
+			Name: "base query " + baseQuery.VariableName,
+			Query: &models.Query{
+				DataSource:    query.DataSource,
+				Text:          baseQuery.VariableName,
+				UsedVariables: []string{baseQuery.VariableName},
+			},
+		})
+	}
+
+	return checks
+}
+
+// runQueryChecks runs the checks concurrently, skipping queries that succeeded before unchanged when the cache is used
+func runQueryChecks(dashboard *models.Dashboard, checks []queryCheck, options verifyOptions) []queryCheckResult {
+	cache := map[string]cachedQueryResult{}
+	if !options.NoCache {
+		cache = loadQueryCache(options.CacheMaxAge)
+	}
+	clients := &kustoClients{}
+	resolver := newParameterResolver(dashboard, clients)
+	resolver.Overrides = utils.TimeRangeOverrides(dashboard, options.TimeRange)
+
+	// Resolve parameters up front, the resolver caches values and is not safe for concurrent use
+	var texts []string
+	var resolveErrs []error
+	for _, check := range checks {
+		text, err := resolver.Expand(check.Query)
+		texts = append(texts, text)
+		resolveErrs = append(resolveErrs, err)
+	}
+
+	var mu sync.Mutex
+
+	results := make([]queryCheckResult, len(checks))
+	semaphore := make(chan struct{}, max(options.Concurrency, 1))
+	var wg sync.WaitGroup
+
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check queryCheck) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			result := queryCheckResult{Name: check.Name}
+			defer func() { results[i] = result }()
+
+			dataSource, err := utils.QueryDataSource(dashboard, check.Query)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.DataSource = dataSource.Name
+
+			text, err := texts[i], resolveErrs[i]
+			if err != nil {
+				result.Err = err
+				return
+			}
+
+			key := queryCacheKey(dataSource, text)
+			mu.Lock()
+			cached, ok := cache[key]
+			mu.Unlock()
+			if ok {
+				result.Rows, result.Latency, result.Cached = cached.Rows, time.Duration(cached.LatencyMs)*time.Millisecond, true
+				return
+			}
+
+			start := time.Now()
+			queryResult, err := clients.get(dataSource.ClusterUri).QueryContext(commandContext, dataSource.ClusterUri, dataSource.Database, text)
+			result.Latency = time.Since(start)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Rows = len(queryResult.Rows)
+
+			mu.Lock()
+			cache[key] = cachedQueryResult{Rows: result.Rows, LatencyMs: result.Latency.Milliseconds(), RunAt: time.Now().UTC()}
+			mu.Unlock()
+		}(i, check)
+	}
+	wg.Wait()
+
+	if !options.NoCache {
+		if err := saveQueryCache(cache); err != nil {
+			slog.Warn("Failed to save query cache", "error", err)
+		}
+	}
+
+	return results
+}
+
+// queryCacheKey identifies a query by the database it runs against and its bound text
+func queryCacheKey(dataSource *models.DataSource, text string) string {
+	hash := sha256.Sum256([]byte(strings.TrimSuffix(dataSource.ClusterUri, "/") + "\n" + dataSource.Database + "\n" + text))
+	return hex.EncodeToString(hash[:])
+}
+
+// loadQueryCache reads the query cache, leaving out the successes older than maxAge so they are run again
+func loadQueryCache(maxAge time.Duration) map[string]cachedQueryResult {
+	cache := map[string]cachedQueryResult{}
+	data, err := os.ReadFile(Query_Cache_Path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return map[string]cachedQueryResult{}
+	}
+	for key, cached := range cache {
+		if time.Since(cached.RunAt) > maxAge {
+			delete(cache, key)
+		}
+	}
+	return cache
+}
+
+func saveQueryCache(cache map[string]cachedQueryResult) error {
+	if err := os.MkdirAll("bin", 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Query_Cache_Path, data, 0644)
+}
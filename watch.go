@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+const Watch_Debounce = 500 * time.Millisecond
+
+// errUnchanged stops a push of the watched template when the dashboard it renders is the one pushed last
+var errUnchanged = errors.New("rendered dashboard unchanged")
+
+// WatchDashboard watches the local template and pushes the dashboard whenever its rendered document changes,
+// running its queries first when verify options are given and snapshotting the dashboard first when backup is set
+func WatchDashboard(accessToken string, dashboardId string, layout string, values *templateValues, verify *verifyOptions, backup bool) {
+	roots := []string{Dashboard_Template_Path, utils.QueriesDir}
+	if layout == Layout_Split {
+		roots = []string{Dashboard_Split_Path}
+	}
+	// Snippets and values render into the dashboard as much as the template does
+	roots = append(roots, snippetPath()...)
+	roots = append(roots, utils.ValuesFile)
+	if values.Environment != "" {
+		roots = append(roots, utils.ValuesFileFor(values.Environment))
+	}
+
+	changes, err := utils.WatchFiles(roots, Watch_Debounce)
+	if err != nil {
+		fatalf("Failed to watch files: %v", err)
+	}
+
+	ws := openWorkspace(layout, newDashboardsAPI(accessToken))
+	last, err := ws.Render(*values)
+	if err != nil {
+		fatalf("Failed to render dashboard: %v", err)
+	}
+	// The first push sends the eTag of the template, the next ones the eTag returned by the previous push
+	eTag := ""
+
+	printInfo("Watching %s for changes, press Ctrl+C to stop\n", strings.Join(roots, ", "))
+
+	for changed := range changes {
+		printInfo("Changed: %s\n", strings.Join(changed, ", "))
+
+		result, err := ws.Push(dashboardId, workspace.PushOptions{
+			Values:     *values,
+			ETag:       eTag,
+			SkipBackup: !backup,
+			Check: func(result *workspace.PushResult) error {
+				if bytes.Equal(result.Rendered.JSON, last.JSON) {
+					return errUnchanged
+				}
+				printLintDiagnostics(result.Validation.Diagnostics)
+
+				if verify != nil {
+					if failed := verifyDashboard(result.Rendered.Dashboard, *verify); failed > 0 {
+						return fmt.Errorf("%d queries failed, not pushing", failed)
+					}
+				}
+				return nil
+			},
+		})
+		switch {
+		case errors.Is(err, errUnchanged):
+			printInfo("Rendered dashboard unchanged, nothing to push\n")
+			continue
+		case errors.Is(err, workspace.ErrInvalid):
+			printValidationProblems(result.Validation.Problems)
+			printLintDiagnostics(result.Validation.Diagnostics)
+			slog.Error("Dashboard is not valid, not pushing", "problems", len(result.Validation.Problems), "lintErrors", result.Validation.LintErrors())
+			continue
+		case err != nil:
+			slog.Error("Failed to push dashboard", "dashboard", dashboardId, "code", errorCode(err), "error", err)
+			continue
+		}
+
+		changes := utils.SummarizeChanges(last.Dashboard, result.Rendered.Dashboard)
+		for _, change := range changes {
+			printInfo("  %s\n", change)
+		}
+		if jsonOutput() {
+			// One line per push, for the output to be read as it comes
+			printJSONLine(pushReport{DashboardID: dashboardId, Template: layoutPath(layout), Pushed: true, Spliced: []string{}, Changes: nonNil(changes), Backup: result.Backup})
+		}
+
+		if result.ETag == "" {
+			// Reading the eTag back could adopt the eTag of an edit made in the portal since, which the next push
+			// would then overwrite
+			fatalCodef(Error_Conflict, "The service did not return the eTag of %s as pushed, stopping so the next push cannot overwrite changes made since, pull and watch again", dashboardId)
+		}
+		eTag = result.ETag
+		last = result.Rendered
+	}
+}
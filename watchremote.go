@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/git"
+	"github.com/omeshp/kusto-dashboards-sync/workspace"
+)
+
+// Types of the events watch-remote emits
+const (
+	// Event_Drift is emitted when a dashboard starts differing from its template, or differs in another way
+	Event_Drift = "drift"
+	// Event_Resolved is emitted when a dashboard that drifted is the same as its template again
+	Event_Resolved = "resolved"
+	// Event_Error is emitted every time checking a dashboard fails
+	Event_Error = "error"
+)
+
+// Webhook_Attempts is how many times an event is posted to the webhook before giving up on it
+const Webhook_Attempts = 3
+
+// driftEvent is a line of watch-remote output, and the body posted to the webhook
+type driftEvent struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	DashboardID string    `json:"dashboardId"`
+	// Template is what the dashboard is compared with, HEAD or the working tree
+	Template     string   `json:"template"`
+	RemoteETag   string   `json:"remoteETag,omitempty"`
+	TemplateETag string   `json:"templateETag,omitempty"`
+	Changes      []string `json:"changes,omitempty"`
+	Diff         string   `json:"diff,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	// Code is the error code of an error event, as printed by failing commands with --output json
+	Code string `json:"code,omitempty"`
+}
+
+// dashboardHealth is the state of a watched dashboard in the report file and the health endpoint
+type dashboardHealth struct {
+	DashboardID string    `json:"dashboardId"`
+	Status      string    `json:"status"`
+	LastChecked time.Time `json:"lastChecked,omitempty"`
+	NextCheck   time.Time `json:"nextCheck"`
+	// Failures counts the checks failed in a row, each one doubling the time to the next check
+	Failures     int      `json:"failures"`
+	LastError    string   `json:"lastError,omitempty"`
+	RemoteETag   string   `json:"remoteETag,omitempty"`
+	TemplateETag string   `json:"templateETag,omitempty"`
+	Changes      []string `json:"changes"`
+	Diff         string   `json:"diff,omitempty"`
+}
+
+// driftReport is the content of the report file and the body of the health endpoint
+type driftReport struct {
+	Healthy    bool              `json:"healthy"`
+	Started    time.Time         `json:"started"`
+	Updated    time.Time         `json:"updated"`
+	Dashboards []dashboardHealth `json:"dashboards"`
+}
+
+// driftWatcher polls dashboards and reports how they drift from their templates
+type driftWatcher struct {
+	client         dataexplorer.DashboardsAPI
+	layout         string
+	values         templateValues
+	repo           *git.Repo
+	interval       time.Duration
+	maxBackoff     time.Duration
+	unhealthyAfter int
+	webhook        string
+	reportPath     string
+	httpClient     *http.Client
+
+	mu         sync.Mutex
+	started    time.Time
+	dashboards map[string]*dashboardHealth
+}
+
+// WatchRemote polls dashboards on an interval and emits an event whenever one of them drifts from the committed
+// template, such as after being edited in the portal, until interrupted
+func WatchRemote(args []string) {
+	flags := newFlagSet("watch-remote")
+	layout := flags.String("layout", localConfig().Layout, "on-disk layout of the dashboard template: single or split")
+	interval := flags.Duration("interval", 5*time.Minute, "time between checks of each dashboard")
+	maxBackoff := flags.Duration("max-backoff", time.Hour, "longest time between checks of a dashboard that keeps failing")
+	webhook := flags.String("webhook", "", "URL to POST every event to as JSON")
+	report := flags.String("report", "", "file to keep a JSON report of the state of every dashboard in")
+	healthAddr := flags.String("health-addr", "", "address to serve the report on at /healthz, e.g. :8080, answering 503 when unhealthy")
+	unhealthyAfter := flags.Int("unhealthy-after", 3, "checks of a dashboard failing in a row before the watcher reports itself unhealthy")
+	worktree := flags.Bool("worktree", false, "compare with the template in the working tree instead of the one committed at HEAD")
+	values := addTemplateFlags(flags)
+	parseFlags(flags, args)
+
+	if *interval <= 0 || *maxBackoff < *interval {
+		usageError(flags, "--interval must be positive and --max-backoff at least --interval")
+	}
+
+	config := localConfig()
+	dashboardIDs := flags.Args()
+	if len(dashboardIDs) == 0 {
+		for _, dashboardID := range append([]string{config.DashboardID}, config.Dashboards...) {
+			if dashboardID != "" {
+				dashboardIDs = append(dashboardIDs, dashboardID)
+			}
+		}
+	}
+	if len(dashboardIDs) == 0 {
+		usageError(flags, "No dashboard id given and none configured in %s", configPath())
+	}
+
+	watcher := &driftWatcher{
+		client:         newDashboardsClient(dashboardsTokenProvider()),
+		layout:         checkLayout(flags, *layout),
+		values:         *values,
+		interval:       *interval,
+		maxBackoff:     *maxBackoff,
+		unhealthyAfter: *unhealthyAfter,
+		webhook:        *webhook,
+		reportPath:     *report,
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		started:        time.Now(),
+		dashboards:     map[string]*dashboardHealth{},
+	}
+	for _, dashboardID := range dashboardIDs {
+		watcher.dashboards[dashboardID] = &dashboardHealth{DashboardID: dashboardID, Status: "pending", NextCheck: time.Now(), Changes: []string{}}
+	}
+	if !*worktree {
+		repo, err := git.Open(".")
+		if err != nil {
+			fatalf("Failed to open the git repository of the workspace, use --worktree to compare with the working tree: %v", err)
+		}
+		watcher.repo = repo
+	}
+
+	if *healthAddr != "" {
+		server := &http.Server{Addr: *healthAddr, Handler: http.HandlerFunc(watcher.serveHealth)}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatalf("Failed to serve the health endpoint: %v", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	printInfo("Watching %d dashboards for drift from %s every %s, press Ctrl+C to stop\n", len(dashboardIDs), watcher.template(), *interval)
+
+	interrupted := interruptChannel()
+	var wg sync.WaitGroup
+	for _, dashboardID := range dashboardIDs {
+		wg.Add(1)
+		go func(dashboardID string) {
+			defer wg.Done()
+			watcher.watch(dashboardID, interrupted)
+		}(dashboardID)
+	}
+	wg.Wait()
+	printInfo("Stopped watching\n")
+}
+
+// watch checks a dashboard until interrupted, waiting the interval after each successful check and backing off
+// exponentially after failed ones
+func (d *driftWatcher) watch(dashboardID string, interrupted <-chan struct{}) {
+	for {
+		wait := d.check(dashboardID)
+		select {
+		case <-interrupted:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// check compares a dashboard with its template, emits an event if its state changed, and returns the time to wait
+// before the next check
+func (d *driftWatcher) check(dashboardID string) time.Duration {
+	drift, err := d.drift(dashboardID)
+
+	d.mu.Lock()
+	health := d.dashboards[dashboardID]
+	previous := *health
+	health.LastChecked = time.Now()
+	var event *driftEvent
+	if err != nil {
+		health.Failures++
+		health.LastError = err.Error()
+		if health.Status == "pending" {
+			health.Status = "error"
+		}
+		event = &driftEvent{Type: Event_Error, Error: err.Error(), Code: errorCode(err)}
+	} else {
+		health.Failures = 0
+		health.LastError = ""
+		health.RemoteETag, health.TemplateETag = drift.RemoteETag, drift.TemplateETag
+		health.Changes, health.Diff = nonNil(drift.Changes), drift.Text
+		switch {
+		case drift.Drifted() && (previous.Status != "drifted" || previous.Diff != drift.Text):
+			health.Status = "drifted"
+			event = &driftEvent{Type: Event_Drift, RemoteETag: drift.RemoteETag, TemplateETag: drift.TemplateETag, Changes: drift.Changes, Diff: drift.Text}
+		case !drift.Drifted() && previous.Status == "drifted":
+			health.Status = "ok"
+			event = &driftEvent{Type: Event_Resolved, RemoteETag: drift.RemoteETag, TemplateETag: drift.TemplateETag}
+		case !drift.Drifted():
+			health.Status = "ok"
+		}
+	}
+
+	wait := d.interval
+	for i := 0; i < health.Failures && wait < d.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.maxBackoff {
+		wait = d.maxBackoff
+	}
+	health.NextCheck = time.Now().Add(wait)
+	if d.reportPath != "" {
+		// Written while holding the lock, so reports of concurrent checks are not written out of order
+		if err := writeReport(d.reportPath, d.report()); err != nil {
+			slog.Error("Failed to write report", "path", d.reportPath, "error", err)
+		}
+	}
+	checked := health.LastChecked
+	d.mu.Unlock()
+
+	if event != nil {
+		event.Time = checked
+		event.DashboardID = dashboardID
+		event.Template = d.template()
+		d.emit(event)
+	}
+	return wait
+}
+
+// drift compares a dashboard with the template at HEAD, or in the working tree without a repository, rendered with
+// the values of the watcher
+func (d *driftWatcher) drift(dashboardID string) (*workspace.Drift, error) {
+	ws := openWorkspace(d.layout, d.client)
+	if d.repo != nil {
+		// Read again for every check, so templates committed while watching are picked up
+		files, err := d.repo.Files("HEAD", workspace.BackupDir, workspace.StagingDir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the template at HEAD: %w", err)
+		}
+		ws.FS = files
+	}
+	return ws.Drift(dashboardID, d.values)
+}
+
+// template names what dashboards are compared with
+func (d *driftWatcher) template() string {
+	if d.repo != nil {
+		return "HEAD"
+	}
+	return "working tree"
+}
+
+// report returns the state of every dashboard, the caller holds d.mu
+func (d *driftWatcher) report() driftReport {
+	report := driftReport{Healthy: true, Started: d.started, Updated: time.Now()}
+	for _, health := range d.dashboards {
+		report.Dashboards = append(report.Dashboards, *health)
+		if health.Failures >= d.unhealthyAfter {
+			report.Healthy = false
+		}
+	}
+	sort.Slice(report.Dashboards, func(i, j int) bool {
+		return report.Dashboards[i].DashboardID < report.Dashboards[j].DashboardID
+	})
+	return report
+}
+
+// emit prints an event as a line of JSON and posts it to the webhook
+func (d *driftWatcher) emit(event *driftEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal event", "error", err)
+		return
+	}
+
+	d.mu.Lock()
+	fmt.Println(string(data))
+	d.mu.Unlock()
+
+	if d.webhook == "" {
+		return
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := d.post(data)
+		if err == nil {
+			return
+		}
+		if attempt == Webhook_Attempts {
+			slog.Error("Failed to post event to the webhook", "type", event.Type, "dashboard", event.DashboardID, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Debug("Posting to the webhook failed, retrying", "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (d *driftWatcher) post(data []byte) error {
+	response, err := d.httpClient.Post(d.webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// serveHealth answers with the report, with status 503 when a dashboard failed too many checks in a row
+func (d *driftWatcher) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/healthz" {
+		http.NotFound(w, r)
+		return
+	}
+
+	d.mu.Lock()
+	report := d.report()
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// writeReport replaces the report file, through a temporary file so readers never see it half written
+func writeReport(path string, report driftReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+)
+
+// remoteDashboard serves a dashboard document, or answers with an error status when status is set
+type remoteDashboard struct {
+	document string
+	status   int
+}
+
+func (r *remoteDashboard) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := http.StatusOK, r.document
+	if r.status != 0 {
+		status, body = r.status, `{"error":{"code":"Unavailable","message":"try again later"}}`
+	}
+	return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+// webhookEvents collects the events posted to a test webhook
+type webhookEvents struct {
+	mu     sync.Mutex
+	events []driftEvent
+}
+
+func (w *webhookEvents) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var event driftEvent
+	json.NewDecoder(req.Body).Decode(&event)
+	w.mu.Lock()
+	w.events = append(w.events, event)
+	w.mu.Unlock()
+}
+
+// take returns the events posted since the last call
+func (w *webhookEvents) take() []driftEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.events
+	w.events = nil
+	return events
+}
+
+// newTestWatcher returns a watcher of the dashboard remote served by remote, comparing it with the template pulled
+// into the testdata of the workspace package, in a temporary working directory
+func newTestWatcher(t *testing.T, remote *remoteDashboard) (*driftWatcher, *webhookEvents) {
+	t.Helper()
+	templateDir, err := filepath.Abs(filepath.Join("workspace", "testdata", "pull"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := os.ReadFile(filepath.Join("workspace", "testdata", "dashboard.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.document = string(document)
+
+	dir := t.TempDir()
+	err = filepath.WalkDir(templateDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, _ := filepath.Rel(templateDir, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(relative)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, relative), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+
+	events := &webhookEvents{}
+	server := httptest.NewServer(events)
+	t.Cleanup(server.Close)
+
+	client := &dataexplorer.DataExplorerClient{
+		Client:  &http.Client{Transport: &dataexplorer.Transport{TokenProvider: dataexplorer.StaticToken("Bearer test"), Base: remote}},
+		BaseURL: Dashboards_Base_URL,
+	}
+	watcher := &driftWatcher{
+		client:         client,
+		layout:         Layout_Single,
+		interval:       time.Minute,
+		maxBackoff:     5 * time.Minute,
+		unhealthyAfter: 3,
+		webhook:        server.URL,
+		httpClient:     server.Client(),
+		started:        time.Now(),
+		dashboards:     map[string]*dashboardHealth{"remote": {DashboardID: "remote", Status: "pending", Changes: []string{}}},
+	}
+	return watcher, events
+}
+
+func TestDriftWatcherCheck(t *testing.T) {
+	remote := &remoteDashboard{}
+	watcher, events := newTestWatcher(t, remote)
+	original := remote.document
+
+	check := func(wantWait time.Duration, wantStatus string, wantEvents ...string) []driftEvent {
+		t.Helper()
+		if wait := watcher.check("remote"); wait != wantWait {
+			t.Errorf("wait = %s, want %s", wait, wantWait)
+		}
+		if status := watcher.dashboards["remote"].Status; status != wantStatus {
+			t.Errorf("status = %s, want %s", status, wantStatus)
+		}
+		posted := events.take()
+		var types []string
+		for _, event := range posted {
+			types = append(types, event.Type)
+		}
+		if strings.Join(types, ",") != strings.Join(wantEvents, ",") {
+			t.Errorf("events = %v, want %v", types, wantEvents)
+		}
+		return posted
+	}
+
+	// The dashboard is the template as pulled
+	check(time.Minute, "ok")
+
+	// Edited in the portal
+	remote.document = strings.Replace(original, "| take 10", "| take 20", 1)
+	posted := check(time.Minute, "drifted", Event_Drift)
+	if len(posted) == 1 && (posted[0].DashboardID != "remote" || posted[0].Template != "working tree" || !strings.Contains(posted[0].Diff, "take 20") || len(posted[0].Changes) == 0) {
+		t.Errorf("drift event = %+v, want the change of the query of remote", posted[0])
+	}
+	check(time.Minute, "drifted")
+
+	// Edited again, differently
+	remote.document = strings.Replace(original, "| take 10", "| take 30", 1)
+	check(time.Minute, "drifted", Event_Drift)
+
+	// Put back as the template has it
+	remote.document = original
+	check(time.Minute, "ok", Event_Resolved)
+
+	// Failing checks back off, doubling the wait up to the maximum, until one succeeds
+	remote.status = http.StatusServiceUnavailable
+	posted = check(2*time.Minute, "ok", Event_Error)
+	if len(posted) == 1 && (posted[0].Code == "" || !strings.Contains(posted[0].Error, "503")) {
+		t.Errorf("error event = %+v, want the status of the service and a code", posted[0])
+	}
+	check(4*time.Minute, "ok", Event_Error)
+	watcher.mu.Lock()
+	healthy := watcher.report().Healthy
+	watcher.mu.Unlock()
+	if !healthy {
+		t.Error("report unhealthy after 2 failures, want healthy until 3")
+	}
+	check(5*time.Minute, "ok", Event_Error)
+	check(5*time.Minute, "ok", Event_Error)
+	watcher.mu.Lock()
+	report := watcher.report()
+	watcher.mu.Unlock()
+	if report.Healthy || report.Dashboards[0].Failures != 4 {
+		t.Errorf("report = %+v, want unhealthy after 4 failures", report)
+	}
+
+	remote.status = 0
+	check(time.Minute, "ok")
+	if failures := watcher.dashboards["remote"].Failures; failures != 0 {
+		t.Errorf("failures = %d after a successful check, want 0", failures)
+	}
+}
+
+func TestDriftWatcherValues(t *testing.T) {
+	remote := &remoteDashboard{}
+	watcher, events := newTestWatcher(t, remote)
+
+	// The title comes from the values of the environment
+	template, err := os.ReadFile("dashboard.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templated := strings.Replace(string(template), "title: Remote", "title: {{ .Values.title }}", 1)
+	if templated == string(template) {
+		t.Fatal("dashboard.yml has no title to template")
+	}
+	files := map[string]string{"dashboard.yml": templated, "values.yml": "title: Remote\n", "values.staging.yml": "title: Staging\n"}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watcher.check("remote")
+	if posted := events.take(); len(posted) != 0 {
+		t.Errorf("events = %+v, want none with values.yml", posted)
+	}
+
+	watcher.values = templateValues{Environment: "staging"}
+	watcher.check("remote")
+	if posted := events.take(); len(posted) != 1 || posted[0].Type != Event_Drift || !strings.Contains(posted[0].Diff, "Staging") {
+		t.Errorf("events = %+v, want the title of staging to drift", posted)
+	}
+
+	watcher.values = templateValues{Environment: "staging", Overrides: []string{"title=Remote"}}
+	watcher.check("remote")
+	if posted := events.take(); len(posted) != 1 || posted[0].Type != Event_Resolved {
+		t.Errorf("events = %+v, want the drift resolved by --set", posted)
+	}
+}
@@ -0,0 +1,264 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// BackupDir holds the snapshots of dashboards, as objects named by the hash of their content and an index of them
+const BackupDir = "backups"
+
+const (
+	backupObjectsDir = "objects"
+	backupIndexFile  = "index.json"
+	// shortHashLength is the length of snapshot hashes as displayed, any unique prefix names a snapshot
+	shortHashLength = 12
+)
+
+// Errors looking up snapshots
+var (
+	ErrSnapshotNotFound  = errors.New("snapshot not found")
+	ErrSnapshotAmbiguous = errors.New("snapshot prefix is ambiguous")
+)
+
+// Snapshot is a version of a dashboard in the backup archive
+type Snapshot struct {
+	// Hash is the SHA-256 of the raw dashboard document, naming its object in the archive
+	Hash        string    `json:"hash"`
+	DashboardID string    `json:"dashboardId"`
+	Title       string    `json:"title"`
+	ETag        string    `json:"eTag"`
+	TakenAt     time.Time `json:"takenAt"`
+	// Reason tells what took the snapshot, such as backup, push or restore
+	Reason string `json:"reason,omitempty"`
+}
+
+// ShortHash returns the abbreviated hash snapshots are displayed with
+func (s *Snapshot) ShortHash() string {
+	return s.Hash[:min(shortHashLength, len(s.Hash))]
+}
+
+// RestoreResult describes a restore
+type RestoreResult struct {
+	// Restored is the snapshot pushed
+	Restored *Snapshot
+	// Previous is the snapshot of the dashboard taken before it was overwritten
+	Previous *Snapshot
+}
+
+// Backup snapshots the raw document of a dashboard into the backup archive. A dashboard unchanged since its latest
+// snapshot is not snapshotted again, the latest snapshot is returned with taken false.
+func (w *Workspace) Backup(dashboardID string, reason string) (snapshot *Snapshot, taken bool, err error) {
+	client, err := w.client()
+	if err != nil {
+		return nil, false, err
+	}
+
+	rawDashboard, err := client.GetDashboardRaw(dashboardID)
+	if err != nil {
+		return nil, false, fmt.Errorf("error retrieving dashboard: %w", err)
+	}
+
+	return w.snapshot(dashboardID, rawDashboard, reason)
+}
+
+// snapshot adds the raw document of a dashboard to the backup archive unless it is the latest snapshot of the
+// dashboard already
+func (w *Workspace) snapshot(dashboardID string, rawDashboard *interface{}, reason string) (snapshot *Snapshot, taken bool, err error) {
+	dashboard, err := utils.ConvertRawDashboardToConcrete(rawDashboard)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Object keys are sorted by the encoder, so the same document always hashes the same
+	content, err := json.MarshalIndent(rawDashboard, "", "  ")
+	if err != nil {
+		return nil, false, fmt.Errorf("error marshalling dashboard data: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	index, err := w.readBackupIndex()
+	if err != nil {
+		return nil, false, err
+	}
+	for i := len(index) - 1; i >= 0; i-- {
+		if index[i].DashboardID == dashboardID {
+			if index[i].Hash == hash {
+				return &index[i], false, nil
+			}
+			break
+		}
+	}
+
+	objectPath := backupObjectPath(hash)
+	if _, err := w.FS.Stat(objectPath); errors.Is(err, fs.ErrNotExist) {
+		if err := w.FS.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+			return nil, false, fmt.Errorf("error creating backup directory: %v", err)
+		}
+		if err := w.FS.WriteFile(objectPath, content, 0644); err != nil {
+			return nil, false, fmt.Errorf("error writing snapshot: %v", err)
+		}
+	}
+
+	snapshot = &Snapshot{
+		Hash:        hash,
+		DashboardID: dashboardID,
+		Title:       dashboard.Title,
+		ETag:        dashboard.ETag,
+		TakenAt:     time.Now().UTC(),
+		Reason:      reason,
+	}
+	if err := w.writeBackupIndex(append(index, *snapshot)); err != nil {
+		return nil, false, err
+	}
+
+	return snapshot, true, nil
+}
+
+// History returns the snapshots of a dashboard, or of all dashboards for an empty id, newest first
+func (w *Workspace) History(dashboardID string) ([]Snapshot, error) {
+	index, err := w.readBackupIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Snapshot
+	for i := len(index) - 1; i >= 0; i-- {
+		if dashboardID == "" || index[i].DashboardID == dashboardID {
+			history = append(history, index[i])
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].TakenAt.After(history[j].TakenAt)
+	})
+
+	return history, nil
+}
+
+// FindSnapshot returns the newest snapshot whose hash starts with prefix
+func (w *Workspace) FindSnapshot(prefix string) (*Snapshot, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, prefix)
+	}
+	history, err := w.History("")
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Snapshot
+	for i := range history {
+		if !strings.HasPrefix(history[i].Hash, prefix) {
+			continue
+		}
+		if found != nil && found.Hash != history[i].Hash {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotAmbiguous, prefix)
+		}
+		if found == nil {
+			found = &history[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, prefix)
+	}
+
+	return found, nil
+}
+
+// Restore pushes a snapshot back to its dashboard, or to dashboardID when given. The dashboard is snapshotted first,
+// and the snapshot is pushed with the eTag retrieved then, so the service refuses it if the dashboard changes in between.
+func (w *Workspace) Restore(prefix string, dashboardID string) (*RestoreResult, error) {
+	client, err := w.client()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := w.FindSnapshot(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if dashboardID == "" {
+		dashboardID = snapshot.DashboardID
+	}
+
+	document, err := w.readSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, _, err := w.Backup(dashboardID, "restore")
+	if err != nil {
+		return nil, fmt.Errorf("error backing up dashboard before restore: %w", err)
+	}
+
+	dataMap := document.(map[string]interface{})
+	dataMap["id"] = dashboardID
+	dataMap["eTag"] = previous.ETag
+
+	if _, err := client.UpdateDashboardRaw(dashboardID, &document); err != nil {
+		return nil, fmt.Errorf("error restoring dashboard, it may have changed since it was backed up as %s: %w", previous.ShortHash(), err)
+	}
+
+	return &RestoreResult{Restored: snapshot, Previous: previous}, nil
+}
+
+// readSnapshot returns the raw dashboard document of a snapshot
+func (w *Workspace) readSnapshot(snapshot *Snapshot) (interface{}, error) {
+	content, err := w.FS.ReadFile(backupObjectPath(snapshot.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot %s: %v", snapshot.ShortHash(), err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("error unmarshalling snapshot %s: %v", snapshot.ShortHash(), err)
+	}
+	return document, nil
+}
+
+func backupObjectPath(hash string) string {
+	return filepath.Join(BackupDir, backupObjectsDir, hash[:2], hash+".json")
+}
+
+// readBackupIndex returns the snapshots of the archive in the order they were taken
+func (w *Workspace) readBackupIndex() ([]Snapshot, error) {
+	data, err := w.FS.ReadFile(filepath.Join(BackupDir, backupIndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup index: %v", err)
+	}
+
+	var index []Snapshot
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("error unmarshalling backup index: %v", err)
+	}
+
+	return index, nil
+}
+
+func (w *Workspace) writeBackupIndex(index []Snapshot) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling backup index: %v", err)
+	}
+
+	if err := w.FS.MkdirAll(BackupDir, 0755); err != nil {
+		return fmt.Errorf("error creating backup directory: %v", err)
+	}
+	if err := w.FS.WriteFile(filepath.Join(BackupDir, backupIndexFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing backup index: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// newBackupWorkspace returns a workspace on an empty MemFS whose client serves testdata/dashboard.json as remote
+func newBackupWorkspace(t *testing.T) (*Workspace, *fakeDashboards) {
+	t.Helper()
+	document, err := os.ReadFile(filepath.Join("testdata", "dashboard.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDashboards{documents: map[string]string{"remote": string(document), "other": string(document)}}
+	return &Workspace{FS: utils.NewMemFS(nil), Client: newTestClient(fake)}, fake
+}
+
+// editDashboard changes the title and eTag of a document of fake, as an edit in the portal would
+func editDashboard(t *testing.T, fake *fakeDashboards, id, title, eTag string) {
+	t.Helper()
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(fake.documents[id]), &document); err != nil {
+		t.Fatal(err)
+	}
+	document["title"], document["eTag"] = title, eTag
+	data, _ := json.Marshal(document)
+	fake.documents[id] = string(data)
+}
+
+func TestBackupIndex(t *testing.T) {
+	w, fake := newBackupWorkspace(t)
+
+	first, taken, err := w.Backup("remote", "backup")
+	if err != nil || !taken {
+		t.Fatalf("Backup = %+v, %v, %v, want a snapshot taken", first, taken, err)
+	}
+	if first.Title != "Remote" || first.ETag != "e1" || first.Reason != "backup" || len(first.Hash) != 64 {
+		t.Errorf("snapshot = %+v, want Remote at e1 named by its SHA-256", first)
+	}
+
+	again, taken, err := w.Backup("remote", "push")
+	if err != nil || taken || again.Hash != first.Hash {
+		t.Errorf("Backup of an unchanged dashboard = %+v, %v, %v, want the first snapshot not taken again", again, taken, err)
+	}
+
+	// The same document under another id is a snapshot of its own, sharing the object
+	other, taken, err := w.Backup("other", "backup")
+	if err != nil || !taken || other.Hash != first.Hash {
+		t.Errorf("Backup of other = %+v, %v, %v, want a snapshot of the same object", other, taken, err)
+	}
+
+	editDashboard(t, fake, "remote", "Edited", "e5")
+	edited, taken, err := w.Backup("remote", "backup")
+	if err != nil || !taken || edited.Hash == first.Hash || edited.Title != "Edited" {
+		t.Fatalf("Backup of the edited dashboard = %+v, %v, %v, want a new snapshot", edited, taken, err)
+	}
+
+	history, err := w.History("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Hash != edited.Hash || history[1].Hash != first.Hash {
+		t.Errorf("History(remote) = %+v, want the edited snapshot, then the first", history)
+	}
+	if all, _ := w.History(""); len(all) != 3 {
+		t.Errorf("History() = %+v, want the 3 snapshots taken", all)
+	}
+
+	objects := 0
+	for name := range w.FS.(*utils.MemFS).Files() {
+		if filepath.Dir(filepath.Dir(name)) == filepath.Join(BackupDir, backupObjectsDir) {
+			objects++
+		}
+	}
+	if objects != 2 {
+		t.Errorf("archive holds %d objects, want one per distinct document", objects)
+	}
+
+	found, err := w.FindSnapshot(edited.ShortHash())
+	if err != nil || found.Hash != edited.Hash {
+		t.Errorf("FindSnapshot(%s) = %+v, %v, want the edited snapshot", edited.ShortHash(), found, err)
+	}
+	if found, err := w.FindSnapshot(first.Hash[:4]); err != nil || found.Hash != first.Hash {
+		t.Errorf("FindSnapshot of a prefix shared by snapshots of one object = %+v, %v, want the object", found, err)
+	}
+	if _, err := w.FindSnapshot("zz"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("FindSnapshot(zz) error = %v, want ErrSnapshotNotFound", err)
+	}
+	if _, err := w.FindSnapshot(""); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("FindSnapshot() error = %v, want ErrSnapshotNotFound", err)
+	}
+}
+
+func TestFindSnapshotAmbiguous(t *testing.T) {
+	sixtyZeros := strings.Repeat("0", 60)
+	index := `[
+  {"hash": "ab12` + sixtyZeros + `", "dashboardId": "remote", "takenAt": "2026-01-01T00:00:00Z"},
+  {"hash": "ab34` + sixtyZeros + `", "dashboardId": "remote", "takenAt": "2026-01-02T00:00:00Z"}
+]`
+	w := &Workspace{FS: utils.NewMemFS(map[string]string{filepath.Join(BackupDir, backupIndexFile): index})}
+
+	if _, err := w.FindSnapshot("ab"); !errors.Is(err, ErrSnapshotAmbiguous) {
+		t.Errorf("FindSnapshot(ab) error = %v, want ErrSnapshotAmbiguous", err)
+	}
+	if found, err := w.FindSnapshot("ab3"); err != nil || found.Hash[:4] != "ab34" {
+		t.Errorf("FindSnapshot(ab3) = %+v, %v, want the second snapshot", found, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	w, fake := newBackupWorkspace(t)
+
+	original, _, err := w.Backup("remote", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	editDashboard(t, fake, "remote", "Edited", "e5")
+
+	result, err := w.Restore(original.ShortHash(), "")
+	if err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+	if result.Restored.Hash != original.Hash {
+		t.Errorf("Restored = %+v, want the original snapshot", result.Restored)
+	}
+	if result.Previous == nil || result.Previous.Title != "Edited" || result.Previous.Reason != "restore" {
+		t.Errorf("Previous = %+v, want the edited dashboard snapshotted before it was overwritten", result.Previous)
+	}
+
+	var restored map[string]interface{}
+	if err := json.Unmarshal([]byte(fake.documents["remote"]), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored["title"] != "Remote" {
+		t.Errorf("dashboard title = %v after restore, want Remote", restored["title"])
+	}
+	if fake.saves != 1 {
+		t.Errorf("service saved %d times, want the one restore sent with the eTag of the edited dashboard", fake.saves)
+	}
+
+	// Restoring to another id pushes the snapshot under that id
+	if _, err := w.Restore(original.ShortHash(), "other"); err != nil {
+		t.Fatalf("Restore to other returned error: %v", err)
+	}
+	if err := json.Unmarshal([]byte(fake.documents["other"]), &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored["id"] != "other" {
+		t.Errorf("restored id = %v, want other", restored["id"])
+	}
+
+	if _, err := w.Restore("zz", ""); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Restore(zz) error = %v, want ErrSnapshotNotFound", err)
+	}
+}
@@ -0,0 +1,142 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// DiffResult describes how a dashboard differs from the rendered dashboard template of the workspace
+type DiffResult struct {
+	// Changes describe, one line per change, what pushing the template would change
+	Changes []string
+	// Text is a unified diff of the dashboard against the rendered template, empty when they are the same
+	Text string
+	// RemoteETag is the eTag of the dashboard, LocalETag the one of the template, they differ when the dashboard
+	// changed since the template was pulled
+	RemoteETag string
+	LocalETag  string
+}
+
+// Diff compares a dashboard with the rendered dashboard template of the workspace. Ids and eTags are left out, as
+// they differ between copies of a dashboard without changing its content.
+func (w *Workspace) Diff(dashboardID string, values TemplateValues) (*DiffResult, error) {
+	client, err := w.client()
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := w.Render(values)
+	if err != nil {
+		return nil, err
+	}
+
+	rawRemote, err := client.GetDashboardRaw(dashboardID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving dashboard: %w", err)
+	}
+
+	return diffDashboards(dashboardID, rendered, rawRemote)
+}
+
+// diffDashboards compares the raw document of a dashboard with a rendered template, rewriting the document
+func diffDashboards(dashboardID string, rendered *Rendered, rawRemote *interface{}) (*DiffResult, error) {
+	compared, err := compareDashboards(rendered, rawRemote)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiffResult{
+		Changes:    utils.SummarizeChanges(compared.remote, compared.local),
+		Text:       utils.UnifiedDiff("remote/"+dashboardID, "local/"+TemplatePath, compared.remoteText, compared.localText),
+		RemoteETag: compared.remoteETag,
+		LocalETag:  rendered.Dashboard.ETag,
+	}, nil
+}
+
+// comparison is a dashboard and a rendered template in the canonical form they are compared in
+type comparison struct {
+	remote, local         *models.Dashboard
+	remoteText, localText string
+	remoteETag            string
+}
+
+// compareDashboards canonicalizes the raw document of a dashboard and a rendered template, rewriting the document
+func compareDashboards(rendered *Rendered, rawRemote *interface{}) (*comparison, error) {
+	var local interface{}
+	if err := json.Unmarshal(rendered.JSON, &local); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+
+	compared := &comparison{}
+	if dataMap, ok := (*rawRemote).(map[string]interface{}); ok {
+		compared.remoteETag, _ = dataMap["eTag"].(string)
+	}
+
+	// Canonicalizing sorts unordered lists in place, so the changes are summarized from the canonical documents too
+	var err error
+	if compared.remoteText, err = diffText(*rawRemote); err != nil {
+		return nil, err
+	}
+	if compared.localText, err = diffText(local); err != nil {
+		return nil, err
+	}
+
+	if compared.remote, err = utils.ConvertRawDashboardToConcrete(rawRemote); err != nil {
+		return nil, err
+	}
+	if compared.local, err = utils.ConvertRawDashboardToConcrete(&local); err != nil {
+		return nil, err
+	}
+
+	return compared, nil
+}
+
+// diffText renders a dashboard document canonically without its id and eTag
+func diffText(document interface{}) (string, error) {
+	if dataMap, ok := document.(map[string]interface{}); ok {
+		delete(dataMap, "id")
+		delete(dataMap, "eTag")
+	}
+
+	text, err := utils.CanonicalYAML(document, utils.DashboardSchema)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// TemplateChanges summarizes how the rendered dashboard template of the workspace differs from the one in base, such
+// as the files of the workspace at an earlier commit. Everything is reported as added when base has no template.
+func (w *Workspace) TemplateChanges(base utils.FileSystem) ([]string, error) {
+	rendered, err := w.Render(TemplateValues{})
+	if err != nil {
+		return nil, err
+	}
+
+	previous := &models.Dashboard{}
+	if baseWorkspace := (&Workspace{FS: base, Config: w.Config}); baseWorkspace.hasTemplate() {
+		baseRendered, err := baseWorkspace.Render(TemplateValues{})
+		if err != nil {
+			return nil, fmt.Errorf("error rendering previous template: %w", err)
+		}
+		previous = baseRendered.Dashboard
+	}
+
+	return utils.SummarizeChanges(previous, rendered.Dashboard), nil
+}
+
+// hasTemplate reports whether the dashboard template of the workspace exists
+func (w *Workspace) hasTemplate() bool {
+	layout, err := w.Layout()
+	if err != nil {
+		return false
+	}
+	if layout == LayoutSplit {
+		return w.exists(filepath.Join(SplitPath, utils.SplitDashboardFile))
+	}
+	return w.exists(TemplatePath)
+}
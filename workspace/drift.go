@@ -0,0 +1,68 @@
+package workspace
+
+import (
+	"fmt"
+
+	"github.com/omeshp/kusto-dashboards-sync/telemetry"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// Drift describes how a dashboard was changed outside of its template, such as by editing it in the portal
+type Drift struct {
+	DashboardID string
+	// RemoteETag is the eTag of the dashboard, TemplateETag the one in the template, they differ when the dashboard
+	// was saved since the template was pulled, whether or not its content changed
+	RemoteETag   string
+	TemplateETag string
+	// Changes describe, one line per change, what was changed on the dashboard compared to the template
+	Changes []string
+	// Text is a unified diff of the rendered template against the dashboard, empty when they are the same
+	Text string
+}
+
+// Drifted reports whether the content of the dashboard differs from the template
+func (d *Drift) Drifted() bool {
+	return d.Text != ""
+}
+
+// Drift compares a dashboard with the dashboard template of the workspace rendered with values, the other way round
+// from Diff: what changed on the dashboard rather than what pushing the template would change.
+func (w *Workspace) Drift(dashboardID string, values TemplateValues) (*Drift, error) {
+	ctx, span := telemetry.Start(w.context(), "drift", "dashboard.id", dashboardID)
+	drift, err := w.withContext(ctx).drift(dashboardID, values)
+	if err == nil {
+		span.SetAttributes("drifted", drift.Drifted())
+	}
+	span.End(err)
+	return drift, err
+}
+
+func (w *Workspace) drift(dashboardID string, values TemplateValues) (*Drift, error) {
+	client, err := w.client()
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := w.Render(values)
+	if err != nil {
+		return nil, err
+	}
+
+	rawRemote, err := client.GetDashboardRaw(dashboardID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving dashboard: %w", err)
+	}
+
+	compared, err := compareDashboards(rendered, rawRemote)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Drift{
+		DashboardID:  dashboardID,
+		RemoteETag:   compared.remoteETag,
+		TemplateETag: rendered.Dashboard.ETag,
+		Changes:      utils.SummarizeChanges(compared.local, compared.remote),
+		Text:         utils.UnifiedDiff("template/"+TemplatePath, "remote/"+dashboardID, compared.localText, compared.remoteText),
+	}, nil
+}
@@ -0,0 +1,243 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/telemetry"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// ErrNoPullState is returned by selective pulls in a workspace the dashboard was never pulled into as a whole
+var ErrNoPullState = errors.New("no previous pull to refresh")
+
+// PullOptions configure Pull
+type PullOptions struct {
+	// FactorLets moves let statements shared by several queries into snippets (utils.FactorSnippets) or base queries
+	// (utils.FactorBaseQueries), nothing is factored when empty
+	FactorLets string
+	// Force overwrites and deletes files modified locally since the last pull
+	Force bool
+	// Selection refreshes only the selected tiles of the dashboard, the rest of the template stays as last pulled
+	Selection Selection
+	// Cancel is closed to interrupt the pull, while retrieving the dashboard or staging its files, which leaves the
+	// workspace as it was. Once files are being swapped into place the pull completes.
+	Cancel <-chan struct{}
+}
+
+// PullResult describes a pull
+type PullResult struct {
+	// Dashboard is the dashboard as retrieved
+	Dashboard *models.Dashboard
+	// Files are the files written, with the snippets factored out
+	Files []string
+	// Removed are the files of tiles no longer in the dashboard, deleted
+	Removed []string
+	// Kept are files modified locally the dashboard did not change, or differing from it in a workspace no pull was
+	// recorded in, left as they are
+	Kept []string
+	// Conflicts are files modified locally the pull would overwrite or delete, it refuses to unless forced
+	Conflicts []string
+	// Factored describes the let statements factored out of the queries
+	Factored []string
+	// Spliced describes the pages, tiles and queries a selective pull refreshed
+	Spliced []string
+	// Snapshot is the dashboard as pulled in the backup archive, the baseline of offline dry runs of Push, nil unless
+	// the files were written
+	Snapshot *Snapshot
+	// Recovered is set when an earlier pull killed while swapping files into place was rolled back first
+	Recovered bool
+}
+
+// Pull retrieves a dashboard and writes it into the workspace as the dashboard template and query files. The template
+// keeps the id, title and eTag of the dashboard configured in config.yml, so pulling a copy of it updates the template.
+//
+// Files modified locally since the last pull are only overwritten when forced: if the dashboard changed any of them
+// the result lists them in Conflicts and is returned with an error wrapping ErrLocalChanges, and nothing is written.
+// Otherwise every file is staged under StagingDir first and swapped into place only once all of them were written,
+// so a failed or interrupted pull leaves the workspace as it was.
+func (w *Workspace) Pull(dashboardID string, options PullOptions) (*PullResult, error) {
+	ctx, span := telemetry.Start(w.context(), "pull", "dashboard.id", dashboardID, "selective", !options.Selection.IsEmpty())
+	result, err := w.withContext(ctx).pull(dashboardID, options)
+	if err == nil {
+		span.SetAttributes("files", len(result.Files))
+		dashboardsSynced.Add(1, "operation", "pull")
+	}
+	span.End(err)
+	return result, err
+}
+
+func (w *Workspace) pull(dashboardID string, options PullOptions) (*PullResult, error) {
+	client, err := w.client()
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := w.Layout()
+	if err != nil {
+		return nil, err
+	}
+
+	recovered, err := w.recoverPull()
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := w.readPullState()
+	if err != nil {
+		return nil, err
+	}
+	if !options.Selection.IsEmpty() && previous == nil {
+		return nil, fmt.Errorf("%w: pull the whole dashboard before refreshing some of its tiles", ErrNoPullState)
+	}
+
+	rawDashboard, err := fetchDashboard(client, dashboardID, options.Cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard, err := utils.ConvertRawDashboardToConcrete(rawDashboard)
+	if err != nil {
+		return nil, err
+	}
+
+	masterDashboard := dashboard
+	if w.Config.DashboardID != "" && w.Config.DashboardID != dashboardID {
+		rawMaster, err := fetchDashboard(client, w.Config.DashboardID, options.Cancel)
+		if err != nil {
+			return nil, err
+		}
+		if masterDashboard, err = utils.ConvertRawDashboardToConcrete(rawMaster); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &PullResult{Dashboard: dashboard, Recovered: recovered}
+
+	// Copied before factoring and saving rewrite the queries of the document, to be snapshotted as retrieved
+	retrieved, err := copyDocument(*rawDashboard)
+	if err != nil {
+		return nil, err
+	}
+
+	document := *rawDashboard
+	if !options.Selection.IsEmpty() {
+		// The selected tiles are spliced into the dashboard as last pulled, which keeps its eTag, so pushing the
+		// template still refuses to overwrite changes to the tiles not refreshed
+		tileIDs, err := selectedTiles(dashboard, options.Selection)
+		if err != nil {
+			return nil, err
+		}
+		if document, err = copyDocument(previous.Document); err != nil {
+			return nil, err
+		}
+		if result.Spliced, _, err = spliceTiles((*rawDashboard).(map[string]interface{}), document.(map[string]interface{}), tileIDs); err != nil {
+			return nil, err
+		}
+		if masterDashboard, err = utils.ConvertRawDashboardToConcrete(&document); err != nil {
+			return nil, err
+		}
+	}
+
+	// Snippets are written with the other files of the pull
+	snippets := newOverlayFS(w.FS)
+	if options.FactorLets != "" {
+		factored, err := utils.FactorSharedLets(snippets, &document, options.FactorLets, w.SnippetPath()[0])
+		if err != nil {
+			return nil, fmt.Errorf("error factoring shared let statements: %w", err)
+		}
+		result.Factored = factored
+	}
+
+	// The document the files are written from, with the id, title and eTag the template keeps
+	pulled, err := copyDocument(document)
+	if err != nil {
+		return nil, err
+	}
+	pulled.(map[string]interface{})["id"] = masterDashboard.Id
+	pulled.(map[string]interface{})["title"] = masterDashboard.Title
+	pulled.(map[string]interface{})["eTag"] = masterDashboard.ETag
+
+	// The files are written to memory first, to compare them with the files of the workspace
+	output := utils.NewMemFS(nil)
+	if layout == LayoutSplit {
+		_, err = utils.PersistSplitDashboardData(output, &document, masterDashboard, SplitPath)
+	} else {
+		_, err = utils.PersistDashboardData(output, &document, masterDashboard, TemplatePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error saving queries to files: %w", err)
+	}
+	staged := output.Files()
+
+	plan, err := w.planPull(staged, previous, layout)
+	if err != nil {
+		return nil, err
+	}
+	result.Kept, result.Conflicts = plan.kept, plan.conflicts
+	if len(plan.conflicts) > 0 && !options.Force {
+		return result, localChangesError(plan.conflicts)
+	}
+	if options.Force {
+		for _, name := range plan.unrecorded {
+			plan.write[name] = []byte(staged[name])
+		}
+		// Without a recorded pull every file kept is unrecorded
+		result.Kept = nil
+	}
+
+	state := &pullState{DashboardID: dashboardID, Layout: layout, Files: map[string]string{}, Document: pulled}
+	for name, content := range staged {
+		state.Files[name] = contentHash([]byte(content))
+	}
+	if plan.write[PullStateFile], err = marshalPullState(state); err != nil {
+		return nil, err
+	}
+	for name, content := range snippets.written {
+		plan.write[name] = content
+	}
+
+	if err := w.commitFiles(plan.write, plan.remove, options.Cancel); err != nil {
+		return nil, err
+	}
+
+	// Snapshotted once the files are in place, so a pull refused, failed or cancelled leaves the archive as it was
+	if result.Snapshot, _, err = w.snapshot(dashboardID, &retrieved, "pull"); err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(staged) {
+		if _, ok := plan.write[name]; ok {
+			result.Files = append(result.Files, name)
+		}
+	}
+	result.Files = append(result.Files, sortedNames(snippets.written)...)
+	result.Removed = plan.remove
+
+	return result, nil
+}
+
+// fetchDashboard retrieves a raw dashboard for a pull, returning ErrInterrupted once cancel is closed without waiting
+// for the response, which is dropped
+func fetchDashboard(client dataexplorer.DashboardsAPI, dashboardID string, cancel <-chan struct{}) (*interface{}, error) {
+	type fetched struct {
+		rawDashboard *interface{}
+		err          error
+	}
+	done := make(chan fetched, 1)
+	go func() {
+		rawDashboard, err := client.GetDashboardRaw(dashboardID)
+		done <- fetched{rawDashboard, err}
+	}()
+
+	select {
+	case result := <-done:
+		if result.err != nil {
+			return nil, fmt.Errorf("error retrieving dashboard: %w", result.err)
+		}
+		return result.rawDashboard, nil
+	case <-cancel:
+		return nil, ErrInterrupted
+	}
+}
@@ -0,0 +1,100 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+func TestPullRefusedTakesNoSnapshot(t *testing.T) {
+	document, err := os.ReadFile("testdata/dashboard.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &fakeDashboards{documents: map[string]string{"remote": string(document)}}
+	fsys := utils.NewMemFS(nil)
+	w := &Workspace{FS: fsys, Client: newTestClient(service), Config: Config{DashboardID: "remote"}}
+
+	if _, err := w.Pull("remote", PullOptions{}); err != nil {
+		t.Fatalf("Pull returned error: %v", err)
+	}
+
+	// The query is edited locally and on the service
+	const queryFile = "queries/Main_Page_Errors.kql"
+	if err := fsys.WriteFile(queryFile, []byte("StormEvents | take 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	service.documents["remote"] = strings.Replace(service.documents["remote"], "| take 10", "| take 20", 1)
+
+	result, err := w.Pull("remote", PullOptions{})
+	if !errors.Is(err, ErrLocalChanges) {
+		t.Fatalf("Pull error = %v, want ErrLocalChanges", err)
+	}
+	if result.Snapshot != nil {
+		t.Errorf("Snapshot = %+v, want none for a refused pull", result.Snapshot)
+	}
+	history, err := w.History("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 {
+		t.Errorf("History = %d snapshots, want only the one of the first pull", len(history))
+	}
+
+	result, err = w.Pull("remote", PullOptions{Force: true})
+	if err != nil {
+		t.Fatalf("Pull returned error: %v", err)
+	}
+	if history, _ := w.History("remote"); result.Snapshot == nil || len(history) != 2 || history[0].Hash != result.Snapshot.Hash {
+		t.Errorf("Snapshot = %+v, want the dashboard pulled with --force as the latest of %d snapshots", result.Snapshot, len(history))
+	}
+}
+
+func TestFirstPullKeepsExistingFiles(t *testing.T) {
+	document, err := os.ReadFile("testdata/dashboard.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &fakeDashboards{documents: map[string]string{"remote": string(document)}}
+
+	// The workspace holds the template as pulled before pull state was recorded, with one query edited since
+	const queryFile = "queries/Main_Page_Errors.kql"
+	files := readGoldenDir(t, pulledDir)
+	delete(files, PullStateFile)
+	files[queryFile] = "StormEvents | take 1"
+	fsys := utils.NewMemFS(files)
+	w := &Workspace{FS: fsys, Client: newTestClient(service), Config: Config{DashboardID: "remote"}}
+
+	result, err := w.Pull("remote", PullOptions{})
+	if err != nil {
+		t.Fatalf("Pull returned error: %v", err)
+	}
+	if len(result.Kept) != 1 || result.Kept[0] != queryFile || len(result.Conflicts) != 0 {
+		t.Errorf("Kept = %v, Conflicts = %v, want only %s kept", result.Kept, result.Conflicts, queryFile)
+	}
+	if content := fsys.Files()[queryFile]; content != files[queryFile] {
+		t.Errorf("%s = %q, want the local edit kept", queryFile, content)
+	}
+	if _, ok := fsys.Files()[PullStateFile]; !ok {
+		t.Fatalf("%s not written", PullStateFile)
+	}
+
+	// From then on the edit is told apart from changes to the dashboard
+	service.documents["remote"] = strings.Replace(service.documents["remote"], "| take 10", "| take 20", 1)
+	if _, err := w.Pull("remote", PullOptions{}); !errors.Is(err, ErrLocalChanges) {
+		t.Errorf("second Pull error = %v, want ErrLocalChanges", err)
+	}
+
+	// --force overwrites the files of a first pull too
+	fsys = utils.NewMemFS(files)
+	w.FS = fsys
+	if result, err = w.Pull("remote", PullOptions{Force: true}); err != nil {
+		t.Fatalf("Pull --force returned error: %v", err)
+	}
+	if content := fsys.Files()[queryFile]; !strings.Contains(content, "take 20") || len(result.Kept) != 0 {
+		t.Errorf("%s = %q, kept %v, want the query of the dashboard", queryFile, content, result.Kept)
+	}
+}
@@ -0,0 +1,175 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PullStateFile records what the last pull wrote, so the next one can tell local edits from changes to the dashboard
+const PullStateFile = ".pull_state.json"
+
+// ErrLocalChanges is returned when a pull would overwrite or delete files modified since the last pull
+var ErrLocalChanges = errors.New("files modified locally since the last pull")
+
+// pullState is the content of PullStateFile
+type pullState struct {
+	DashboardID string `json:"dashboardId"`
+	Layout      string `json:"layout"`
+	// Files maps the files the pull wrote to the SHA-256 of their content
+	Files map[string]string `json:"files"`
+	// Document is the raw dashboard the files were written from, selective pulls splice tiles into it
+	Document interface{} `json:"document"`
+}
+
+// pullPlan is how a pull changes the files of the workspace
+type pullPlan struct {
+	write  map[string][]byte
+	remove []string
+	// kept are files modified locally that the dashboard did not change
+	kept []string
+	// conflicts are files modified locally that the pull would overwrite or delete
+	conflicts []string
+	// unrecorded are files differing from the dashboard when no pull was recorded before, kept like files modified
+	// locally unless forced, since whether they were edited cannot be told
+	unrecorded []string
+}
+
+// planPull compares the files a pull produces with the files of the workspace and what the last pull wrote. A file is
+// only written when the dashboard changed it since the last pull and it was not modified locally, files the last pull
+// wrote that are no longer produced are deleted on the same condition. Files never written by a pull are left alone,
+// as are the files of a workspace no pull was recorded in.
+func (w *Workspace) planPull(staged map[string]string, previous *pullState, layout string) (*pullPlan, error) {
+	plan := &pullPlan{write: map[string][]byte{}}
+	previousFiles := map[string]string{}
+	if previous != nil {
+		previousFiles = previous.Files
+	}
+
+	for _, name := range sortedKeys(staged) {
+		content := staged[name]
+		pulledHash, tracked := previousFiles[name]
+
+		local, err := w.FS.ReadFile(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			plan.write[name] = []byte(content)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", name, err)
+		}
+
+		localHash := contentHash(local)
+		switch {
+		case string(local) == content:
+		case tracked && contentHash([]byte(content)) == pulledHash:
+			plan.kept = append(plan.kept, name)
+		case tracked && localHash == pulledHash:
+			plan.write[name] = []byte(content)
+		case previous == nil:
+			plan.kept = append(plan.kept, name)
+			plan.unrecorded = append(plan.unrecorded, name)
+		default:
+			plan.conflicts = append(plan.conflicts, name)
+			plan.write[name] = []byte(content)
+		}
+	}
+
+	// Files of another layout are not the ones this pull would have written
+	if previous == nil || previous.Layout != layout {
+		return plan, nil
+	}
+	for _, name := range sortedKeys(previousFiles) {
+		if _, produced := staged[name]; produced {
+			continue
+		}
+		local, err := w.FS.ReadFile(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", name, err)
+		}
+		if contentHash(local) != previousFiles[name] {
+			plan.conflicts = append(plan.conflicts, name)
+		}
+		plan.remove = append(plan.remove, name)
+	}
+
+	return plan, nil
+}
+
+// readPullState returns the state recorded by the last pull, nil when there is none
+func (w *Workspace) readPullState() (*pullState, error) {
+	data, err := w.FS.ReadFile(PullStateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading pull state: %v", err)
+	}
+
+	var state pullState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error unmarshalling %s: %v", PullStateFile, err)
+	}
+	return &state, nil
+}
+
+// marshalPullState returns the content of PullStateFile, committed with the files of the pull it describes
+func marshalPullState(state *pullState) ([]byte, error) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling pull state: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// writeFile writes a file of the workspace, creating the directories it is in
+func (w *Workspace) writeFile(name string, content []byte) error {
+	if err := w.FS.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("error creating directory for %s: %v", name, err)
+	}
+	if err := w.FS.WriteFile(name, content, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	return nil
+}
+
+// localChangesError describes the files a pull refuses to overwrite
+func localChangesError(conflicts []string) error {
+	return fmt.Errorf("%w: %s", ErrLocalChanges, strings.Join(conflicts, ", "))
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// copyDocument returns a deep copy of a raw dashboard document
+func copyDocument(document interface{}) (interface{}, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling dashboard data: %v", err)
+	}
+	var copied interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+	return copied, nil
+}
+
+func sortedKeys(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
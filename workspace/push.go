@@ -0,0 +1,303 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/kql"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/telemetry"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// ErrInvalid is returned when a dashboard fails validation or its queries have lint errors
+var ErrInvalid = errors.New("dashboard is not valid")
+
+// ErrDryRunUnsupported is returned by dry runs with a client that is not a dataexplorer.UpdatePreparer
+var ErrDryRunUnsupported = errors.New("client cannot prepare a push without sending it")
+
+// Rendered is the dashboard template of a workspace with includes and values expanded
+type Rendered struct {
+	// YAML is the processed template
+	YAML []byte
+	// JSON is the document pushed to the service
+	JSON []byte
+	// Dashboard is the document parsed
+	Dashboard *models.Dashboard
+}
+
+// Validation lists what is wrong with a rendered dashboard
+type Validation struct {
+	// Problems are broken references inside the dashboard
+	Problems []error
+	// Diagnostics are the findings of linting its queries, warnings and errors
+	Diagnostics []utils.QueryDiagnostic
+}
+
+// LintErrors returns the number of diagnostics of error severity
+func (v *Validation) LintErrors() int {
+	count := 0
+	for _, diagnostic := range v.Diagnostics {
+		if diagnostic.Severity == kql.SeverityError {
+			count++
+		}
+	}
+	return count
+}
+
+// Valid reports whether the dashboard can be pushed, lint warnings do not prevent pushing
+func (v *Validation) Valid() bool {
+	return len(v.Problems) == 0 && v.LintErrors() == 0
+}
+
+// PushOptions configure Push
+type PushOptions struct {
+	Values TemplateValues
+	// ETag replaces the eTag of the template when set, e.g. with the one returned by a previous push
+	ETag string
+	// SkipBackup pushes without snapshotting the dashboard into the backup archive first
+	SkipBackup bool
+	// Selection restricts the push to some tiles of the template, spliced into the dashboard as it is
+	Selection Selection
+	// DryRun prepares the push without sending it or backing up the dashboard, see DryRunResult. It takes a client
+	// implementing dataexplorer.UpdatePreparer.
+	DryRun bool
+	// Offline compares a dry run with the latest snapshot of the dashboard in the backup archive, taken when it was
+	// pulled or backed up, instead of retrieving the dashboard
+	Offline bool
+	// Check runs after validation of the rendered dashboard and stops the push when it fails, e.g. to report lint
+	// warnings and run the queries of the dashboard
+	Check func(result *PushResult) error
+}
+
+// PushResult describes a push
+type PushResult struct {
+	// Rendered is the document pushed, the dashboard with the selected tiles spliced in for a selective push
+	Rendered   *Rendered
+	Validation *Validation
+	// Backup is the snapshot of the dashboard as it was before the push
+	Backup *Snapshot
+	// DryRun describes the push prepared by a dry run
+	DryRun *DryRunResult
+	// Spliced describes the pages, tiles and queries a selective push copied into the dashboard
+	Spliced []string
+	// ETag is the eTag of the dashboard as pushed, the one the next push of it has to send, empty when the service
+	// did not return it
+	ETag string
+}
+
+// DryRunResult describes a push prepared without being sent
+type DryRunResult struct {
+	// Request is the request the push would send, with the token redacted
+	Request *dataexplorer.RecordedRequest
+	// Diff is how the push would change the dashboard
+	Diff *DiffResult
+	// Baseline is the snapshot the dashboard was compared with offline, nil when it was retrieved
+	Baseline *Snapshot
+	// Conflict is set when the eTag pushed is not the one of the dashboard, so the service would refuse the push
+	Conflict bool
+}
+
+// Render renders the dashboard template of the workspace
+func (w *Workspace) Render(values TemplateValues) (*Rendered, error) {
+	_, span := telemetry.Start(w.context(), "render")
+	rendered, err := w.render(values)
+	span.End(err)
+	return rendered, err
+}
+
+func (w *Workspace) render(values TemplateValues) (*Rendered, error) {
+	layout, err := w.Layout()
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := w.TemplateOptions(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var yamlData []byte
+	if layout == LayoutSplit {
+		document, err := utils.LoadSplitDashboard(w.FS, SplitPath, options)
+		if err != nil {
+			return nil, err
+		}
+		if yamlData, err = utils.CanonicalYAML(document, utils.DashboardSchema); err != nil {
+			return nil, err
+		}
+	} else {
+		if yamlData, err = utils.RenderTemplate(w.FS, TemplatePath, utils.QueriesDir, options); err != nil {
+			return nil, err
+		}
+	}
+
+	jsonData, err := utils.YAMLToJSON(yamlData)
+	if err != nil {
+		return nil, err
+	}
+
+	var dashboard models.Dashboard
+	if err := json.Unmarshal(jsonData, &dashboard); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+
+	return &Rendered{YAML: yamlData, JSON: jsonData, Dashboard: &dashboard}, nil
+}
+
+// Validate renders the dashboard template of the workspace, checks the references inside the dashboard and lints its
+// queries
+func (w *Workspace) Validate(values TemplateValues) (*Validation, error) {
+	rendered, err := w.Render(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.validate(rendered.Dashboard), nil
+}
+
+func (w *Workspace) validate(dashboard *models.Dashboard) *Validation {
+	_, span := telemetry.Start(w.context(), "validate")
+	validation := &Validation{
+		Problems:    utils.ValidateDashboard(dashboard),
+		Diagnostics: utils.LintDashboard(dashboard, w.Config.LargeTables),
+	}
+	span.SetAttributes("problems", len(validation.Problems), "lint_errors", validation.LintErrors())
+	span.End(nil)
+	return validation
+}
+
+// Push renders and validates the dashboard template of the workspace and updates the dashboard with it. When the
+// dashboard is not valid the result is returned along with an error wrapping ErrInvalid, so its problems can be reported.
+// With DryRun set the push stops before backing up and sending, and result.DryRun describes it. With a Selection only
+// the selected tiles of the template are pushed, the rest of the dashboard is left as it is.
+func (w *Workspace) Push(dashboardID string, options PushOptions) (*PushResult, error) {
+	ctx, span := telemetry.Start(w.context(), "push", "dashboard.id", dashboardID, "selective", !options.Selection.IsEmpty(), "dry_run", options.DryRun)
+	result, err := w.withContext(ctx).push(dashboardID, options)
+	if err == nil && !options.DryRun {
+		dashboardsSynced.Add(1, "operation", "push")
+	}
+	span.End(err)
+	return result, err
+}
+
+func (w *Workspace) push(dashboardID string, options PushOptions) (*PushResult, error) {
+	client, err := w.client()
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := w.Render(options.Values)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PushResult{Rendered: rendered}
+	if options.Selection.IsEmpty() {
+		result.Validation = w.validate(rendered.Dashboard)
+	} else {
+		spliced, err := w.splice(client, dashboardID, rendered, options.Selection, options.DryRun && options.Offline)
+		if err != nil {
+			return nil, err
+		}
+		result.Rendered, result.Spliced = spliced.rendered, spliced.changes
+
+		// Queries of the dashboard left as they are do not stop the push
+		result.Validation = w.validate(spliced.rendered.Dashboard)
+		diagnostics := result.Validation.Diagnostics[:0]
+		for _, diagnostic := range result.Validation.Diagnostics {
+			if spliced.lintNames[diagnostic.Query] {
+				diagnostics = append(diagnostics, diagnostic)
+			}
+		}
+		result.Validation.Diagnostics = diagnostics
+	}
+	if !result.Validation.Valid() {
+		return result, fmt.Errorf("%w: %d problems, %d lint errors", ErrInvalid, len(result.Validation.Problems), result.Validation.LintErrors())
+	}
+
+	if options.Check != nil {
+		if err := options.Check(result); err != nil {
+			return result, err
+		}
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(result.Rendered.JSON, &document); err != nil {
+		return result, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+	if options.ETag != "" {
+		document.(map[string]interface{})["eTag"] = options.ETag
+	}
+
+	if options.DryRun {
+		result.DryRun, err = w.dryRun(client, dashboardID, &document, result.Rendered, options.Offline)
+		return result, err
+	}
+
+	if !options.SkipBackup {
+		if result.Backup, _, err = w.Backup(dashboardID, "push"); err != nil {
+			return result, fmt.Errorf("error backing up dashboard before push: %w", err)
+		}
+	}
+
+	if result.ETag, err = client.UpdateDashboardRaw(dashboardID, &document); err != nil {
+		return result, fmt.Errorf("error updating dashboard: %w", err)
+	}
+
+	return result, nil
+}
+
+// dryRun prepares the request updating a dashboard with document and compares it with the dashboard, or offline with
+// its latest snapshot
+func (w *Workspace) dryRun(client dataexplorer.DashboardsAPI, dashboardID string, document *interface{}, rendered *Rendered, offline bool) (*DryRunResult, error) {
+	preparer, ok := client.(dataexplorer.UpdatePreparer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrDryRunUnsupported, client)
+	}
+	request, err := preparer.PrepareUpdateDashboard(dashboardID, document)
+	if err != nil {
+		return nil, err
+	}
+	result := &DryRunResult{Request: request}
+
+	rawRemote, baseline, err := w.remoteDocument(client, dashboardID, offline)
+	if err != nil {
+		return nil, err
+	}
+	result.Baseline = baseline
+
+	if result.Diff, err = diffDashboards(dashboardID, rendered, rawRemote); err != nil {
+		return nil, err
+	}
+	eTag, _ := (*document).(map[string]interface{})["eTag"].(string)
+	result.Conflict = eTag != result.Diff.RemoteETag
+
+	return result, nil
+}
+
+// remoteDocument retrieves the raw document of a dashboard or, offline, reads its latest snapshot and returns it too
+func (w *Workspace) remoteDocument(client dataexplorer.DashboardsAPI, dashboardID string, offline bool) (*interface{}, *Snapshot, error) {
+	if !offline {
+		rawRemote, err := client.GetDashboardRaw(dashboardID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error retrieving dashboard: %w", err)
+		}
+		return rawRemote, nil, nil
+	}
+
+	history, err := w.History(dashboardID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(history) == 0 {
+		return nil, nil, fmt.Errorf("%w: no snapshot of %s to compare with offline, pull or back it up first", ErrSnapshotNotFound, dashboardID)
+	}
+	document, err := w.readSnapshot(&history[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return &document, &history[0], nil
+}
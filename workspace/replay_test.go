@@ -0,0 +1,235 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// update records the golden files of the tests again, against fakeDashboards instead of the service
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+const (
+	dashboardsURL = "https://dashboards.kusto.windows.net/dashboards"
+	// pulledDir holds the template pulling testdata/dashboard.json writes
+	pulledDir = "testdata/pull"
+)
+
+func TestPullReplay(t *testing.T) {
+	client := replayClient(t, "pull")
+	w := &Workspace{FS: utils.NewMemFS(nil), Client: client, Config: Config{DashboardID: "remote"}}
+
+	result, err := w.Pull("remote", PullOptions{})
+	if err != nil {
+		t.Fatalf("Pull returned error: %v", err)
+	}
+	if result.Snapshot == nil || result.Snapshot.ETag != "e1" {
+		t.Errorf("Snapshot = %+v, want the dashboard with eTag e1", result.Snapshot)
+	}
+
+	files := w.FS.(*utils.MemFS).Files()
+	if *update {
+		for _, name := range result.Files {
+			writeGolden(t, filepath.Join(pulledDir, name), files[name])
+		}
+	}
+	golden := readGoldenDir(t, pulledDir)
+	if len(result.Files) != len(golden) {
+		t.Errorf("Pull wrote %v, want the files of %s", result.Files, pulledDir)
+	}
+	for _, name := range result.Files {
+		if files[name] != golden[name] {
+			t.Errorf("%s =\n%s\nwant\n%s", name, files[name], golden[name])
+		}
+	}
+}
+
+func TestPushReplay(t *testing.T) {
+	client := replayClient(t, "push")
+	w := &Workspace{FS: utils.NewMemFS(readGoldenDir(t, pulledDir)), Client: client}
+
+	result, err := w.Push("remote", PushOptions{})
+	if err != nil {
+		t.Fatalf("Push returned error: %v", err)
+	}
+	if result.ETag != "e2" {
+		t.Errorf("ETag = %q, want the eTag the service returned, e2", result.ETag)
+	}
+	if result.Backup == nil || result.Backup.ETag != "e1" {
+		t.Errorf("Backup = %+v, want the dashboard before the push, with eTag e1", result.Backup)
+	}
+}
+
+func TestPushReplayConflict(t *testing.T) {
+	client := replayClient(t, "push_conflict")
+	w := &Workspace{FS: utils.NewMemFS(readGoldenDir(t, pulledDir)), Client: client}
+
+	// The template was pulled before someone else saved the dashboard
+	result, err := w.Push("remote", PushOptions{ETag: "e0"})
+	var statusErr *dataexplorer.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("Push error = %v, want status code 412", err)
+	}
+	if result == nil || result.ETag != "" {
+		t.Errorf("result = %+v, want no eTag for a refused push", result)
+	}
+	if result != nil && result.Backup == nil {
+		t.Error("Backup = nil, want the dashboard snapshotted before the refused push")
+	}
+}
+
+func TestPushReplayDryRunConflict(t *testing.T) {
+	client := replayClient(t, "push_dry_run")
+	w := &Workspace{FS: utils.NewMemFS(readGoldenDir(t, pulledDir)), Client: client}
+
+	result, err := w.Push("remote", PushOptions{DryRun: true, ETag: "e0"})
+	if err != nil {
+		t.Fatalf("Push returned error: %v", err)
+	}
+	if result.DryRun == nil || !result.DryRun.Conflict {
+		t.Errorf("DryRun = %+v, want a conflict between eTag e0 and e1", result.DryRun)
+	}
+	if result.DryRun != nil && result.DryRun.Request.Method != http.MethodPut {
+		t.Errorf("Request = %+v, want the PUT the push would send", result.DryRun.Request)
+	}
+}
+
+// replayClient returns a client answered by the exchanges of testdata/replay/<name>.json and checks that the test
+// made every request recorded. With -update the exchanges with fakeDashboards are recorded to it instead.
+func replayClient(t *testing.T, name string) *dataexplorer.DataExplorerClient {
+	t.Helper()
+	golden := filepath.Join("testdata", "replay", name+".json")
+
+	if *update {
+		document, err := os.ReadFile(filepath.Join("testdata", "dashboard.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(golden)
+		base := &dataexplorer.Recorder{Path: golden, Base: &fakeDashboards{documents: map[string]string{"remote": string(document)}}}
+		return newTestClient(base)
+	}
+
+	replayer, err := dataexplorer.NewReplayer(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, exchange := range replayer.Unused() {
+			t.Errorf("recorded request not sent: %s %s", exchange.Request.Method, exchange.Request.URL)
+		}
+	})
+	return newTestClient(replayer)
+}
+
+func newTestClient(base http.RoundTripper) *dataexplorer.DataExplorerClient {
+	return &dataexplorer.DataExplorerClient{
+		Client:  &http.Client{Transport: &dataexplorer.Transport{TokenProvider: dataexplorer.StaticToken("Bearer REDACTED"), Base: base}},
+		BaseURL: dashboardsURL,
+	}
+}
+
+// fakeDashboards is a dashboards service holding documents by dashboard id. It refuses to save a dashboard with an
+// eTag other than the one it has, as the service does, and gives every save a new eTag.
+type fakeDashboards struct {
+	documents map[string]string
+	saves     int
+}
+
+func (f *fakeDashboards) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := path.Base(req.URL.Path)
+	document, ok := f.documents[id]
+	if !ok {
+		return fakeResponse(http.StatusNotFound, `{"error":{"code":"NotFound","message":"dashboard not found"}}`), nil
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		return fakeResponse(http.StatusOK, document), nil
+	case http.MethodPut:
+		var saved, current map[string]interface{}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &saved); err != nil {
+			return fakeResponse(http.StatusBadRequest, `{"error":{"code":"BadRequest","message":"invalid dashboard"}}`), nil
+		}
+		json.Unmarshal([]byte(document), &current)
+		if saved["eTag"] != current["eTag"] {
+			return fakeResponse(http.StatusPreconditionFailed, `{"error":{"code":"PreconditionFailed","message":"the dashboard was modified"}}`), nil
+		}
+		f.saves++
+		saved["eTag"] = fmt.Sprintf("e%d", f.saves+1)
+		data, _ := json.Marshal(saved)
+		f.documents[id] = string(data)
+		return fakeResponse(http.StatusOK, string(data)), nil
+	}
+	return fakeResponse(http.StatusMethodNotAllowed, ""), nil
+}
+
+func fakeResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// readGoldenDir returns the files under dir by their path relative to it
+func readGoldenDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(dir, name)
+		files[filepath.ToSlash(relative)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func writeGolden(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sendOnlyClient hides the methods of a client other than those of DashboardsAPI
+type sendOnlyClient struct {
+	dataexplorer.DashboardsAPI
+}
+
+func TestPushDryRunUnsupported(t *testing.T) {
+	w := &Workspace{FS: utils.NewMemFS(readGoldenDir(t, pulledDir)), Client: sendOnlyClient{newTestClient(nil)}}
+
+	if _, err := w.Push("remote", PushOptions{DryRun: true, Offline: true}); !errors.Is(err, ErrDryRunUnsupported) {
+		t.Errorf("Push error = %v, want ErrDryRunUnsupported", err)
+	}
+}
@@ -0,0 +1,334 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/models"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// Errors of selective pushes and pulls
+var (
+	// ErrSelectionNotFound is returned when a selected tile or page is not in the dashboard it is taken from
+	ErrSelectionNotFound = errors.New("selected tile or page not found")
+	// ErrUnresolvedReference is returned when a selected tile needs a query, data source or variable declaration that
+	// neither the dashboard it is taken from nor the one it is spliced into has
+	ErrUnresolvedReference = errors.New("unresolved reference")
+)
+
+// Selection restricts a push or a pull to some tiles, the rest of the dashboard or template is left as it is
+type Selection struct {
+	// Tiles are tile ids or titles
+	Tiles []string
+	// Pages are page names, selecting every tile on them
+	Pages []string
+}
+
+// IsEmpty reports whether nothing is selected, pushing the whole template
+func (s Selection) IsEmpty() bool {
+	return len(s.Tiles) == 0 && len(s.Pages) == 0
+}
+
+// spliced is the dashboard a selective push sends, the live dashboard with the selected tiles of the template
+type spliced struct {
+	rendered *Rendered
+	// changes describe the pages, tiles and queries spliced in
+	changes []string
+	// lintNames are the names utils.LintDashboard gives the queries spliced in
+	lintNames map[string]bool
+}
+
+// splice retrieves a dashboard and copies the selected tiles of the rendered template into it, with what they reference
+// as spliceTiles does. Offline the tiles are spliced into the latest snapshot of the dashboard instead.
+func (w *Workspace) splice(client dataexplorer.DashboardsAPI, dashboardID string, rendered *Rendered, selection Selection, offline bool) (*spliced, error) {
+	tileIDs, err := selectedTiles(rendered.Dashboard, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	rawRemote, _, err := w.remoteDocument(client, dashboardID, offline)
+	if err != nil {
+		return nil, err
+	}
+	remote, ok := (*rawRemote).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error reading dashboard %s: not a JSON object", dashboardID)
+	}
+	var local map[string]interface{}
+	if err := json.Unmarshal(rendered.JSON, &local); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+
+	changes, lintNames, err := spliceTiles(local, remote, tileIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := &spliced{changes: changes, lintNames: lintNames}
+	if result.rendered, err = renderDocument(remote); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// spliceTiles copies the tiles of the raw dashboard document from with the given ids into the document into, with the
+// queries they reference and the pages they are on when into has no page of the same id or name. The data sources,
+// parameters and base queries the queries need are copied too when into has none of the same id or variable, and an
+// error wrapping ErrUnresolvedReference is returned when from has none either. It returns what it copied and the names
+// utils.LintDashboard gives the queries copied.
+func spliceTiles(from, into map[string]interface{}, tileIDs []string) (changes []string, lintNames map[string]bool, err error) {
+	s := &splicer{from: from, into: into, fromQueries: indexByID(from["queries"]), lintNames: map[string]bool{}, variables: map[string]bool{}}
+	fromTiles := indexByID(from["tiles"])
+	fromPages := indexByID(from["pages"])
+
+	// Pages are matched by id, then by name
+	pageIDs := map[string]string{}
+	for _, tileID := range tileIDs {
+		pageID, _ := fromTiles[tileID]["pageId"].(string)
+		if _, done := pageIDs[pageID]; done || pageID == "" {
+			continue
+		}
+		page := fromPages[pageID]
+		name, _ := page["name"].(string)
+		pageIDs[pageID] = pageID
+		if findByID(into["pages"], pageID) != nil {
+			continue
+		}
+		if samePage := findByField(into["pages"], "name", name); samePage != nil {
+			pageIDs[pageID], _ = samePage["id"].(string)
+			continue
+		}
+		if page != nil {
+			into["pages"] = append(asList(into["pages"]), page)
+			s.changes = append(s.changes, fmt.Sprintf("added page %q", name))
+		}
+	}
+
+	for _, tileID := range tileIDs {
+		tile := fromTiles[tileID]
+		title, _ := tile["title"].(string)
+		if pageID, ok := tile["pageId"].(string); ok && pageID != "" {
+			tile["pageId"] = pageIDs[pageID]
+		}
+		s.changes = append(s.changes, replaceByID(into, "tiles", tile, fmt.Sprintf("tile %q", title)))
+		s.lintNames[fmt.Sprintf("tile %q", title)] = true
+
+		if queryRef, ok := tile["queryRef"].(map[string]interface{}); ok {
+			queryID, _ := queryRef["queryId"].(string)
+			if err := s.spliceQuery(queryID, fmt.Sprintf("query of tile %q", title)); err != nil {
+				return nil, nil, err
+			}
+		} else if query, ok := tile["query"].(map[string]interface{}); ok {
+			// An inline query is copied with its tile, but needs what it references as much as a shared one
+			if err := s.spliceReferences(query, fmt.Sprintf("query of tile %q", title)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return s.changes, s.lintNames, nil
+}
+
+// splicer copies queries between raw dashboard documents for spliceTiles, with what they reference
+type splicer struct {
+	from, into  map[string]interface{}
+	fromQueries map[string]map[string]interface{}
+	changes     []string
+	lintNames   map[string]bool
+	// variables are the variables whose declaration was looked up already
+	variables map[string]bool
+}
+
+// spliceQuery copies the query with the given id, described as name, with its data source and the declarations of the
+// variables it uses
+func (s *splicer) spliceQuery(queryID string, name string) error {
+	query := s.fromQueries[queryID]
+	if query == nil {
+		return fmt.Errorf("%w: %s, query %s is not in the dashboard", ErrUnresolvedReference, name, queryID)
+	}
+	s.changes = append(s.changes, replaceByID(s.into, "queries", query, name))
+	return s.spliceReferences(query, name)
+}
+
+// spliceReferences copies the data source of a query described as name and the declarations of the variables it uses
+func (s *splicer) spliceReferences(query map[string]interface{}, name string) error {
+	if dataSource, ok := query["dataSource"].(map[string]interface{}); ok {
+		if dataSourceID, _ := dataSource["dataSourceId"].(string); dataSourceID != "" {
+			if err := s.spliceDataSource(dataSourceID, name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, variable := range asList(query["usedVariables"]) {
+		if variable, ok := variable.(string); ok {
+			if err := s.spliceVariable(variable, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// spliceDataSource copies the data source with the given id used by the query named user, unless into has it
+func (s *splicer) spliceDataSource(dataSourceID string, user string) error {
+	if findByID(s.into["dataSources"], dataSourceID) != nil {
+		return nil
+	}
+	dataSource := findByID(s.from["dataSources"], dataSourceID)
+	if dataSource == nil {
+		return fmt.Errorf("%w: %s uses data source %s, which neither dashboard has", ErrUnresolvedReference, user, dataSourceID)
+	}
+	s.into["dataSources"] = append(asList(s.into["dataSources"]), dataSource)
+	s.changes = append(s.changes, fmt.Sprintf("added data source %q", dataSource["name"]))
+	return nil
+}
+
+// spliceVariable copies the parameter or base query declaring a variable used by the query named user, with the
+// query it runs, unless into declares the variable
+func (s *splicer) spliceVariable(variable string, user string) error {
+	if s.variables[variable] || declaringParameter(s.into, variable) != nil || findByField(s.into["baseQueries"], "variableName", variable) != nil {
+		return nil
+	}
+	s.variables[variable] = true
+
+	if parameter := declaringParameter(s.from, variable); parameter != nil {
+		s.into["parameters"] = append(asList(s.into["parameters"]), parameter)
+		s.changes = append(s.changes, fmt.Sprintf("added parameter %q", parameter["displayName"]))
+		dataSource, _ := parameter["dataSource"].(map[string]interface{})
+		if queryRef, ok := dataSource["queryRef"].(map[string]interface{}); ok {
+			queryID, _ := queryRef["queryId"].(string)
+			s.lintNames["query "+queryID] = true
+			return s.spliceQuery(queryID, fmt.Sprintf("query of parameter %q", parameter["displayName"]))
+		}
+		return nil
+	}
+
+	if baseQuery := findByField(s.from["baseQueries"], "variableName", variable); baseQuery != nil {
+		s.into["baseQueries"] = append(asList(s.into["baseQueries"]), baseQuery)
+		s.changes = append(s.changes, "added base query "+variable)
+		queryID, _ := baseQuery["queryId"].(string)
+		s.lintNames["base query "+variable] = true
+		return s.spliceQuery(queryID, "query of base query "+variable)
+	}
+
+	return fmt.Errorf("%w: %s uses variable %s, which neither dashboard declares", ErrUnresolvedReference, user, variable)
+}
+
+// declaringParameter returns the parameter of a raw dashboard document declaring a variable, nil if there is none
+func declaringParameter(document map[string]interface{}, variable string) map[string]interface{} {
+	for _, field := range []string{"variableName", "beginVariableName", "endVariableName"} {
+		if parameter := findByField(document["parameters"], field, variable); parameter != nil {
+			return parameter
+		}
+	}
+	return nil
+}
+
+// selectedTiles returns the ids of the tiles of the dashboard selected, in the order of the dashboard
+func selectedTiles(dashboard *models.Dashboard, selection Selection) ([]string, error) {
+	selected := map[string]bool{}
+	for _, idOrTitle := range selection.Tiles {
+		tile, err := utils.FindTile(dashboard, idOrTitle)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSelectionNotFound, err)
+		}
+		selected[tile.Id] = true
+	}
+	for _, name := range selection.Pages {
+		found := false
+		for _, page := range dashboard.Pages {
+			if !strings.EqualFold(page.Name, name) {
+				continue
+			}
+			found = true
+			for _, tile := range dashboard.Tiles {
+				if tile.PageId == page.Id {
+					selected[tile.Id] = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: no page named %q", ErrSelectionNotFound, name)
+		}
+	}
+
+	var tileIDs []string
+	for _, tile := range dashboard.Tiles {
+		if selected[tile.Id] {
+			tileIDs = append(tileIDs, tile.Id)
+		}
+	}
+	return tileIDs, nil
+}
+
+// renderDocument returns a raw dashboard document in the form Render returns templates in
+func renderDocument(document map[string]interface{}) (*Rendered, error) {
+	jsonData, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling dashboard data: %v", err)
+	}
+	var dashboard models.Dashboard
+	if err := json.Unmarshal(jsonData, &dashboard); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+	// Canonicalizing sorts lists in place, so it works on a copy
+	var canonical interface{}
+	if err := json.Unmarshal(jsonData, &canonical); err != nil {
+		return nil, fmt.Errorf("error unmarshalling dashboard data: %v", err)
+	}
+	yamlData, err := utils.CanonicalYAML(canonical, utils.DashboardSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rendered{YAML: yamlData, JSON: jsonData, Dashboard: &dashboard}, nil
+}
+
+// replaceByID replaces the element of the list at key of document having the id of element, or appends element, and
+// describes what it did to the element named name
+func replaceByID(document map[string]interface{}, key string, element map[string]interface{}, name string) string {
+	list := asList(document[key])
+	for i, item := range list {
+		if existing, ok := item.(map[string]interface{}); ok && existing["id"] == element["id"] {
+			list[i] = element
+			return "updated " + name
+		}
+	}
+	document[key] = append(list, element)
+	return "added " + name
+}
+
+// indexByID maps the ids of the objects of a raw list to the objects
+func indexByID(list interface{}) map[string]map[string]interface{} {
+	index := map[string]map[string]interface{}{}
+	for _, item := range asList(list) {
+		if object, ok := item.(map[string]interface{}); ok {
+			if id, ok := object["id"].(string); ok {
+				index[id] = object
+			}
+		}
+	}
+	return index
+}
+
+func findByID(list interface{}, id string) map[string]interface{} {
+	return findByField(list, "id", id)
+}
+
+// findByField returns the first object of a raw list whose field has the given value
+func findByField(list interface{}, field string, value string) map[string]interface{} {
+	for _, item := range asList(list) {
+		if object, ok := item.(map[string]interface{}); ok && object[field] == value {
+			return object
+		}
+	}
+	return nil
+}
+
+func asList(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
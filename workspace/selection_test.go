@@ -0,0 +1,183 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSpliceTiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		into    string
+		tileIDs []string
+		edit    func(from map[string]interface{})
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "into an empty dashboard",
+			into:    `{}`,
+			tileIDs: []string{"t3"},
+			want: []string{
+				`added page "Main Page"`,
+				`added tile "Errors"`,
+				`added query of tile "Errors"`,
+				`added data source "help"`,
+				`added parameter "Region"`,
+				`added query of parameter "Region"`,
+			},
+		},
+		{
+			name: "into a dashboard declaring what the tile needs",
+			into: `{
+				"pages": [{"id": "p9", "name": "Main Page"}],
+				"dataSources": [{"id": "ds1", "name": "help"}],
+				"parameters": [{"id": "par9", "variableName": "_region"}]
+			}`,
+			tileIDs: []string{"t3"},
+			want:    []string{`added tile "Errors"`, `added query of tile "Errors"`},
+		},
+		{
+			name:    "time range parameter",
+			into:    `{"pages": [{"id": "p1", "name": "Main Page"}], "dataSources": [{"id": "ds1"}]}`,
+			tileIDs: []string{"t1"},
+			want:    []string{`added tile "Requests per hour"`, `added query of tile "Requests per hour"`, `added parameter "Time range"`},
+		},
+		{
+			name:    "base query",
+			into:    `{"pages": [{"id": "p1"}], "dataSources": [{"id": "ds1"}], "parameters": [{"variableName": "_region"}]}`,
+			tileIDs: []string{"t3"},
+			edit: func(from map[string]interface{}) {
+				query := findByID(from["queries"], "q3")
+				query["usedVariables"] = []interface{}{"_region", "_errors"}
+				from["baseQueries"] = []interface{}{map[string]interface{}{"id": "b1", "queryId": "q2", "variableName": "_errors"}}
+			},
+			want: []string{
+				`added tile "Errors"`,
+				`added query of tile "Errors"`,
+				`added base query _errors`,
+				`added query of base query _errors`,
+			},
+		},
+		{
+			name:    "inline query",
+			into:    `{}`,
+			tileIDs: []string{"t3"},
+			edit:    inlineQuery,
+			want: []string{
+				`added page "Main Page"`,
+				`added tile "Errors"`,
+				`added data source "help"`,
+				`added parameter "Region"`,
+				`added query of parameter "Region"`,
+			},
+		},
+		{
+			name:    "inline query on an unknown data source",
+			into:    `{}`,
+			tileIDs: []string{"t3"},
+			edit: func(from map[string]interface{}) {
+				inlineQuery(from)
+				from["dataSources"] = []interface{}{}
+			},
+			wantErr: ErrUnresolvedReference,
+		},
+		{
+			name:    "inline query using an undeclared variable",
+			into:    `{"dataSources": [{"id": "ds1"}]}`,
+			tileIDs: []string{"t3"},
+			edit: func(from map[string]interface{}) {
+				inlineQuery(from)
+				from["parameters"] = []interface{}{}
+			},
+			wantErr: ErrUnresolvedReference,
+		},
+		{
+			name:    "unknown data source",
+			into:    `{}`,
+			tileIDs: []string{"t3"},
+			edit:    func(from map[string]interface{}) { from["dataSources"] = []interface{}{} },
+			wantErr: ErrUnresolvedReference,
+		},
+		{
+			name:    "undeclared variable",
+			into:    `{"dataSources": [{"id": "ds1"}]}`,
+			tileIDs: []string{"t3"},
+			edit:    func(from map[string]interface{}) { from["parameters"] = []interface{}{} },
+			wantErr: ErrUnresolvedReference,
+		},
+		{
+			name:    "unknown query",
+			into:    `{}`,
+			tileIDs: []string{"t3"},
+			edit:    func(from map[string]interface{}) { from["queries"] = []interface{}{} },
+			wantErr: ErrUnresolvedReference,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := readDocument(t, "testdata/dashboard.json")
+			if tt.edit != nil {
+				tt.edit(from)
+			}
+			var into map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.into), &into); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, _, err := spliceTiles(from, into, tt.tileIDs)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("spliceTiles error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("spliceTiles returned error: %v", err)
+			}
+			if !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("changes = %q, want %q", changes, tt.want)
+			}
+			if problems := validateDocument(t, into); len(problems) > 0 {
+				t.Errorf("spliced dashboard has problems: %v", problems)
+			}
+		})
+	}
+}
+
+// inlineQuery moves query q3 of tile t3 into the tile
+func inlineQuery(from map[string]interface{}) {
+	tile := findByID(from["tiles"], "t3")
+	query := findByID(from["queries"], "q3")
+	delete(query, "id")
+	delete(tile, "queryRef")
+	tile["query"] = query
+	from["queries"] = []interface{}{findByID(from["queries"], "q1"), findByID(from["queries"], "q2")}
+}
+
+func readDocument(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+// validateDocument returns the problems utils.ValidateDashboard finds in a raw dashboard document
+func validateDocument(t *testing.T, document map[string]interface{}) []error {
+	t.Helper()
+	rendered, err := renderDocument(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return (&Workspace{}).validate(rendered.Dashboard).Problems
+}
@@ -0,0 +1,238 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// StagingDir holds the files a pull writes until they are all swapped into the workspace, and the files they replace
+// until the swap is complete. It is only left behind by a pull that was killed, the next pull rolls that one back.
+const StagingDir = ".pull_staging"
+
+const stagingJournalFile = "journal.json"
+
+// ErrInterrupted is returned when a pull is cancelled, the workspace is left as it was before the pull
+var ErrInterrupted = errors.New("pull interrupted")
+
+// stagedFile is a change of a pull to a file of the workspace, the index of the file in the journal names its
+// staged content and its previous content under StagingDir
+type stagedFile struct {
+	Name string `json:"name"`
+	// Write is set for files replaced by staged content, unset for files removed
+	Write bool `json:"write"`
+	// Existed is set for files in the workspace before the pull, they are moved aside to be restored on rollback
+	Existed bool `json:"existed"`
+}
+
+func stagedPath(index int) string {
+	return filepath.Join(StagingDir, "new", strconv.Itoa(index))
+}
+
+func previousPath(index int) string {
+	return filepath.Join(StagingDir, "old", strconv.Itoa(index))
+}
+
+// commitFiles writes and removes files of the workspace all at once or not at all. The files are first written under
+// StagingDir, which cancel interrupts, then a journal of the changes is written and every file is renamed into place,
+// moving the files replaced or removed aside. If any rename fails the journal is played back, and once every file is
+// in place StagingDir is deleted. A process killed during the swap leaves the journal for recoverPull.
+func (w *Workspace) commitFiles(write map[string][]byte, remove []string, cancel <-chan struct{}) error {
+	if err := w.FS.RemoveAll(StagingDir); err != nil {
+		return fmt.Errorf("error cleaning %s: %v", StagingDir, err)
+	}
+
+	var journal []stagedFile
+	for _, name := range sortedNames(write) {
+		journal = append(journal, stagedFile{Name: name, Write: true})
+	}
+	for _, name := range remove {
+		journal = append(journal, stagedFile{Name: name})
+	}
+
+	for i, change := range journal {
+		if interrupted(cancel) {
+			return w.discardStaging(ErrInterrupted)
+		}
+		if _, err := w.FS.Stat(change.Name); err == nil {
+			journal[i].Existed = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return w.discardStaging(fmt.Errorf("error reading %s: %v", change.Name, err))
+		}
+		if change.Write {
+			if err := w.writeFile(stagedPath(i), write[change.Name]); err != nil {
+				return w.discardStaging(err)
+			}
+		}
+	}
+	if interrupted(cancel) {
+		return w.discardStaging(ErrInterrupted)
+	}
+
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return w.discardStaging(fmt.Errorf("error marshalling pull journal: %v", err))
+	}
+	if err := w.writeFile(filepath.Join(StagingDir, stagingJournalFile), append(data, '\n')); err != nil {
+		return w.discardStaging(err)
+	}
+	if err := w.FS.MkdirAll(filepath.Dir(previousPath(0)), 0755); err != nil {
+		return w.rollback(journal, fmt.Errorf("error creating %s: %v", StagingDir, err))
+	}
+
+	for i, change := range journal {
+		if change.Existed {
+			if err := w.FS.Rename(change.Name, previousPath(i)); err != nil {
+				return w.rollback(journal, fmt.Errorf("error moving %s aside: %v", change.Name, err))
+			}
+		}
+		if change.Write {
+			if err := w.FS.MkdirAll(filepath.Dir(change.Name), 0755); err != nil {
+				return w.rollback(journal, fmt.Errorf("error creating directory for %s: %v", change.Name, err))
+			}
+			if err := w.FS.Rename(stagedPath(i), change.Name); err != nil {
+				return w.rollback(journal, fmt.Errorf("error moving %s into place: %v", change.Name, err))
+			}
+		}
+	}
+
+	// Removing the journal commits the pull, what is left of StagingDir is only the previous content
+	if err := w.FS.RemoveAll(filepath.Join(StagingDir, stagingJournalFile)); err != nil {
+		return w.rollback(journal, fmt.Errorf("error removing pull journal: %v", err))
+	}
+	if err := w.FS.RemoveAll(StagingDir); err != nil {
+		return fmt.Errorf("error cleaning %s: %v", StagingDir, err)
+	}
+	return nil
+}
+
+// rollback puts back the files the journal moved aside and removes the files it created, then returns cause, or the
+// error that prevented the rollback
+func (w *Workspace) rollback(journal []stagedFile, cause error) error {
+	if err := w.playBack(journal); err != nil {
+		return fmt.Errorf("%v, and rolling back failed: %v", cause, err)
+	}
+	return w.discardStaging(cause)
+}
+
+func (w *Workspace) playBack(journal []stagedFile) error {
+	for i := len(journal) - 1; i >= 0; i-- {
+		change := journal[i]
+		if change.Existed {
+			if !w.exists(previousPath(i)) {
+				// Not moved aside yet, so not replaced either
+				continue
+			}
+			if err := w.FS.RemoveAll(change.Name); err != nil {
+				return fmt.Errorf("removing %s: %v", change.Name, err)
+			}
+			if err := w.FS.Rename(previousPath(i), change.Name); err != nil {
+				return fmt.Errorf("restoring %s from %s: %v", change.Name, previousPath(i), err)
+			}
+		} else if change.Write && !w.exists(stagedPath(i)) {
+			if err := w.FS.RemoveAll(change.Name); err != nil {
+				return fmt.Errorf("removing %s: %v", change.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// discardStaging removes StagingDir, returning cause
+func (w *Workspace) discardStaging(cause error) error {
+	if err := w.FS.RemoveAll(StagingDir); err != nil {
+		return fmt.Errorf("%v, and cleaning %s failed: %v", cause, StagingDir, err)
+	}
+	return cause
+}
+
+// recoverPull rolls back a pull that was killed while swapping its files into place, reporting whether there was one
+func (w *Workspace) recoverPull() (bool, error) {
+	data, err := w.FS.ReadFile(filepath.Join(StagingDir, stagingJournalFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Killed before the swap started or after it completed, the workspace is consistent either way
+		if err := w.FS.RemoveAll(StagingDir); err != nil {
+			return false, fmt.Errorf("error cleaning %s: %v", StagingDir, err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading pull journal: %v", err)
+	}
+
+	var journal []stagedFile
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return false, fmt.Errorf("error unmarshalling pull journal: %v", err)
+	}
+	if err := w.playBack(journal); err != nil {
+		return false, fmt.Errorf("error rolling back interrupted pull: %v", err)
+	}
+	if err := w.FS.RemoveAll(StagingDir); err != nil {
+		return false, fmt.Errorf("error cleaning %s: %v", StagingDir, err)
+	}
+	return true, nil
+}
+
+func (w *Workspace) exists(name string) bool {
+	_, err := w.FS.Stat(name)
+	return err == nil
+}
+
+func interrupted(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// overlayFS reads through to the workspace and keeps what is written in memory, so utils.FactorSharedLets can write
+// snippets to be committed with the rest of a pull. Only reading and writing files is supported.
+type overlayFS struct {
+	utils.FileSystem
+	written map[string][]byte
+}
+
+func newOverlayFS(fsys utils.FileSystem) *overlayFS {
+	return &overlayFS{FileSystem: fsys, written: map[string][]byte{}}
+}
+
+func (o *overlayFS) ReadFile(name string) ([]byte, error) {
+	if data, ok := o.written[filepath.Clean(name)]; ok {
+		return data, nil
+	}
+	return o.FileSystem.ReadFile(name)
+}
+
+func (o *overlayFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	o.written[filepath.Clean(name)] = append([]byte{}, data...)
+	return nil
+}
+
+func (o *overlayFS) MkdirAll(name string, perm fs.FileMode) error {
+	return nil
+}
+
+func (o *overlayFS) RemoveAll(name string) error {
+	return fmt.Errorf("removing %s: not supported while staging a pull", name)
+}
+
+func (o *overlayFS) Rename(oldname, newname string) error {
+	return fmt.Errorf("renaming %s: not supported while staging a pull", oldname)
+}
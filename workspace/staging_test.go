@@ -0,0 +1,163 @@
+package workspace
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+)
+
+// renameFailingFS fails, or panics as if the process were killed, when a file is renamed to target
+type renameFailingFS struct {
+	*utils.MemFS
+	target string
+	kill   bool
+}
+
+func (f *renameFailingFS) Rename(oldname, newname string) error {
+	if filepath.Clean(newname) == f.target {
+		if f.kill {
+			panic("killed")
+		}
+		return errors.New("disk full")
+	}
+	return f.MemFS.Rename(oldname, newname)
+}
+
+var workspaceBeforePull = map[string]string{
+	"a.kql":      "old a",
+	"c.kql":      "old c",
+	"keep.kql":   "untouched",
+	"dir/d.kql":  "old d",
+	"config.yml": "layout: single",
+}
+
+// commitPullChanges writes a.kql and b.kql and removes c.kql and dir/d.kql
+func commitPullChanges(w *Workspace, cancel <-chan struct{}) error {
+	write := map[string][]byte{"a.kql": []byte("new a"), "b/b.kql": []byte("new b")}
+	return w.commitFiles(write, []string{"c.kql", "dir/d.kql"}, cancel)
+}
+
+// checkFiles fails the test unless the files of fsys are want
+func checkFiles(t *testing.T, fsys *utils.MemFS, want map[string]string) {
+	t.Helper()
+	if files := fsys.Files(); !reflect.DeepEqual(files, want) {
+		t.Errorf("files =\n%v\nwant\n%v", files, want)
+	}
+}
+
+func TestCommitFiles(t *testing.T) {
+	fsys := utils.NewMemFS(workspaceBeforePull)
+	w := &Workspace{FS: fsys}
+
+	if err := commitPullChanges(w, nil); err != nil {
+		t.Fatalf("commitFiles returned error: %v", err)
+	}
+	checkFiles(t, fsys, map[string]string{"a.kql": "new a", "b/b.kql": "new b", "keep.kql": "untouched", "config.yml": "layout: single"})
+	if w.exists(StagingDir) {
+		t.Errorf("%s left behind", StagingDir)
+	}
+}
+
+func TestCommitFilesInterrupted(t *testing.T) {
+	fsys := utils.NewMemFS(workspaceBeforePull)
+	w := &Workspace{FS: fsys}
+	cancel := make(chan struct{})
+	close(cancel)
+
+	if err := commitPullChanges(w, cancel); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("commitFiles error = %v, want ErrInterrupted", err)
+	}
+	checkFiles(t, fsys, workspaceBeforePull)
+}
+
+func TestCommitFilesRollback(t *testing.T) {
+	// The journal is sorted, a.kql is swapped before b/b.kql fails and the removals are not reached
+	fsys := &renameFailingFS{MemFS: utils.NewMemFS(workspaceBeforePull), target: "b/b.kql"}
+	w := &Workspace{FS: fsys}
+
+	err := commitPullChanges(w, nil)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("commitFiles error = %v, want the failed rename", err)
+	}
+	checkFiles(t, fsys.MemFS, workspaceBeforePull)
+}
+
+func TestCommitFilesRollbackOfRemovals(t *testing.T) {
+	// Failing to move dir/d.kql aside, after every write and the removal of c.kql
+	fsys := &renameFailingFS{MemFS: utils.NewMemFS(workspaceBeforePull), target: previousPath(3)}
+	w := &Workspace{FS: fsys}
+
+	if err := commitPullChanges(w, nil); err == nil {
+		t.Fatal("commitFiles returned no error")
+	}
+	checkFiles(t, fsys.MemFS, workspaceBeforePull)
+}
+
+func TestRecoverPull(t *testing.T) {
+	for _, target := range []string{"a.kql", "b/b.kql", previousPath(2), previousPath(3)} {
+		t.Run(target, func(t *testing.T) {
+			// The process is killed while renaming to target, leaving the journal behind
+			fsys := &renameFailingFS{MemFS: utils.NewMemFS(workspaceBeforePull), target: target, kill: true}
+			func() {
+				defer func() { recover() }()
+				commitPullChanges(&Workspace{FS: fsys}, nil)
+				t.Fatal("commitFiles was not killed")
+			}()
+			if _, err := fsys.ReadFile(filepath.Join(StagingDir, stagingJournalFile)); err != nil {
+				t.Fatalf("journal not left behind: %v", err)
+			}
+
+			w := &Workspace{FS: fsys.MemFS}
+			recovered, err := w.recoverPull()
+			if err != nil || !recovered {
+				t.Fatalf("recoverPull = %v, %v, want the pull rolled back", recovered, err)
+			}
+			checkFiles(t, fsys.MemFS, workspaceBeforePull)
+		})
+	}
+}
+
+func TestRecoverPullWithoutJournal(t *testing.T) {
+	// Killed while staging, before the journal was written
+	files := map[string]string{stagedPath(0): "new a"}
+	for name, content := range workspaceBeforePull {
+		files[name] = content
+	}
+	fsys := utils.NewMemFS(files)
+	w := &Workspace{FS: fsys}
+
+	recovered, err := w.recoverPull()
+	if err != nil || recovered {
+		t.Fatalf("recoverPull = %v, %v, want nothing to roll back", recovered, err)
+	}
+	checkFiles(t, fsys, workspaceBeforePull)
+}
+
+// blockingTransport answers no request until release is closed
+type blockingTransport struct {
+	release chan struct{}
+}
+
+func (b *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-b.release
+	return nil, errors.New("released")
+}
+
+func TestPullInterruptedWhileRetrieving(t *testing.T) {
+	transport := &blockingTransport{release: make(chan struct{})}
+	defer close(transport.release)
+	fsys := utils.NewMemFS(workspaceBeforePull)
+	w := &Workspace{FS: fsys, Client: newTestClient(transport)}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, err := w.Pull("remote", PullOptions{Cancel: cancel}); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Pull error = %v, want ErrInterrupted", err)
+	}
+	checkFiles(t, fsys, workspaceBeforePull)
+}
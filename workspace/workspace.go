@@ -0,0 +1,164 @@
+// Package workspace keeps Data Explorer dashboards in sync with dashboard templates in a workspace, for programs that
+// manage dashboards without going through the command line tool.
+//
+// A Workspace reads and writes its files through a utils.FileSystem, utils.DirFS for a directory on disk or
+// utils.MemFS in memory, and talks to the dashboards service through a dataexplorer.DashboardsAPI. Its methods
+// return errors rather than exiting, and structured results rather than printing.
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/omeshp/kusto-dashboards-sync/dataexplorer"
+	"github.com/omeshp/kusto-dashboards-sync/telemetry"
+	"github.com/omeshp/kusto-dashboards-sync/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the workspace configuration file
+const ConfigFile = "config.yml"
+
+// Paths of the dashboard template within a workspace
+const (
+	TemplatePath = "dashboard.yml"
+	SplitPath    = "dashboard"
+)
+
+// Layouts of the dashboard template on disk
+const (
+	LayoutSingle = "single"
+	LayoutSplit  = "split"
+)
+
+// dashboardsSynced counts the dashboards pulled and pushed, by operation
+var dashboardsSynced = telemetry.NewCounter("kds.dashboards.synced", "Dashboards pulled into or pushed from a workspace", "{dashboard}")
+
+// ErrUnknownLayout is returned for layouts other than LayoutSingle and LayoutSplit
+var ErrUnknownLayout = errors.New("unknown layout")
+
+// Config is the configuration of a workspace, as read from config.yml
+type Config struct {
+	DashboardID string `yaml:"dashboard_id"`
+	// Dashboards are other dashboards managed from the workspace, such as copies of DashboardID
+	Dashboards  []string `yaml:"dashboards"`
+	Layout      string   `yaml:"layout"`
+	LargeTables []string `yaml:"large_tables"`
+	SnippetPath []string `yaml:"snippet_path"`
+	TemplateEnv []string `yaml:"template_env"`
+}
+
+// LoadConfig reads config.yml from fsys
+func LoadConfig(fsys utils.FileSystem) (*Config, error) {
+	return LoadConfigFile(fsys, ConfigFile)
+}
+
+// LoadConfigFile reads the workspace configuration from the file at path in fsys instead of config.yml
+func LoadConfigFile(fsys utils.FileSystem, path string) (*Config, error) {
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// Workspace is a directory holding a dashboard template, its query files and config.yml
+type Workspace struct {
+	FS     utils.FileSystem
+	Client dataexplorer.DashboardsAPI
+	Config Config
+	// Context carries the telemetry span the operations of the workspace are children of, context.Background() when nil
+	Context context.Context
+}
+
+// TemplateValues select the values a dashboard template is rendered with, the zero value renders with values.yml only
+type TemplateValues struct {
+	// Environment names the values.<env>.yml overlaying values.yml
+	Environment string
+	// Overrides are key=value pairs overriding the values files, keys may be dotted paths
+	Overrides []string
+}
+
+// Open returns the workspace at the root of fsys, configured by its config.yml when there is one
+func Open(fsys utils.FileSystem, client dataexplorer.DashboardsAPI) (*Workspace, error) {
+	workspace := &Workspace{FS: fsys, Client: client}
+
+	config, err := LoadConfig(fsys)
+	if errors.Is(err, fs.ErrNotExist) {
+		return workspace, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	workspace.Config = *config
+
+	return workspace, nil
+}
+
+// Layout returns the layout of the dashboard template, LayoutSingle unless configured otherwise
+func (w *Workspace) Layout() (string, error) {
+	switch w.Config.Layout {
+	case "", LayoutSingle:
+		return LayoutSingle, nil
+	case LayoutSplit:
+		return LayoutSplit, nil
+	}
+	return "", fmt.Errorf("%w %q, expected %s or %s", ErrUnknownLayout, w.Config.Layout, LayoutSingle, LayoutSplit)
+}
+
+// SnippetPath returns the directories snippets included by query files are looked up in
+func (w *Workspace) SnippetPath() []string {
+	if len(w.Config.SnippetPath) > 0 {
+		return w.Config.SnippetPath
+	}
+	return []string{utils.SnippetsDir}
+}
+
+// TemplateOptions loads the template values and returns the options the dashboard template is rendered with
+func (w *Workspace) TemplateOptions(values TemplateValues) (utils.TemplateOptions, error) {
+	loaded, err := utils.LoadValues(w.FS, ".", values.Environment, values.Overrides)
+	if err != nil {
+		return utils.TemplateOptions{}, err
+	}
+
+	return utils.TemplateOptions{
+		SnippetPath:  w.SnippetPath(),
+		Values:       loaded,
+		Environment:  values.Environment,
+		EnvAllowlist: w.Config.TemplateEnv,
+	}, nil
+}
+
+// client returns the client of the workspace, failing for workspaces opened without one
+func (w *Workspace) client() (dataexplorer.DashboardsAPI, error) {
+	if w.Client == nil {
+		return nil, errors.New("workspace has no dashboards client")
+	}
+	if binder, ok := w.Client.(dataexplorer.ContextBinder); ok && w.Context != nil {
+		return binder.WithContext(w.Context), nil
+	}
+	return w.Client, nil
+}
+
+// context returns the context of the workspace, context.Background() when it has none
+func (w *Workspace) context() context.Context {
+	if w.Context == nil {
+		return context.Background()
+	}
+	return w.Context
+}
+
+// withContext returns a copy of the workspace whose operations run with ctx
+func (w *Workspace) withContext(ctx context.Context) *Workspace {
+	bound := *w
+	bound.Context = ctx
+	return &bound
+}